@@ -0,0 +1,97 @@
+// Package cache provides size-bounded key-value stores that evict the least
+// recently used entries once they are full.
+//
+// Two stores are available:
+//
+//   - Memory: Keeps the values in memory, lost when the process exits.
+//
+//   - Disk: Keeps the values as files in a directory, so that they survive
+//     restarts. Existing files are indexed when the store is opened.
+//
+// Stores are safe for concurrent use. They hold opaque byte slices, leaving
+// the encoding of the values to the caller.
+package cache
+
+import (
+	"container/list"
+)
+
+// Store is a size-bounded key-value store.
+type Store interface {
+	// Get returns the value of the key, marking it as recently used.
+	Get(key string) ([]byte, bool)
+	// Set stores the value of the key, evicting the least recently used
+	// entries if needed. It returns false if the value could not be stored,
+	// such as when it doesn't fit in the store at all.
+	Set(key string, value []byte) bool
+	// Delete removes the key from the store.
+	Delete(key string)
+	// Len returns the number of entries in the store.
+	Len() int
+	// Size returns the size of all the values in the store, in bytes.
+	Size() int64
+}
+
+// EvictFunc is called every time an entry is evicted to make room for others.
+type EvictFunc func()
+
+// lru is an index of the entries of a store, from the most to the least
+// recently used, along with their sizes.
+type lru struct {
+	limit   int64                    // Maximum size of all the entries
+	size    int64                    // Current size of all the entries
+	order   *list.List               // Entries, most recently used first
+	entries map[string]*list.Element // Entries by key
+}
+
+// lruEntry is an entry of the lru index.
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+// newLRU creates an empty lru index bounded by limit bytes.
+func newLRU(limit int64) *lru {
+	return &lru{
+		limit:   limit,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// touch marks the key as recently used. It returns false if the key is not
+// indexed.
+func (l *lru) touch(key string) bool {
+	elem, ok := l.entries[key]
+	if ok {
+		l.order.MoveToFront(elem)
+	}
+	return ok
+}
+
+// add indexes the key with the given size as the most recently used,
+// replacing any previous size. It returns the keys that must be evicted to
+// stay within the bounds, which are no longer indexed.
+func (l *lru) add(key string, size int64) []string {
+	l.remove(key)
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, size: size})
+	l.size += size
+
+	var evicted []string
+	for l.size > l.limit {
+		oldest := l.order.Back().Value.(*lruEntry)
+		l.remove(oldest.key)
+		evicted = append(evicted, oldest.key)
+	}
+
+	return evicted
+}
+
+// remove drops the key from the index, if indexed.
+func (l *lru) remove(key string) {
+	if elem, ok := l.entries[key]; ok {
+		l.size -= elem.Value.(*lruEntry).size
+		l.order.Remove(elem)
+		delete(l.entries, key)
+	}
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStore checks the behavior shared by every store, which is bounded to
+// 10 bytes.
+func testStore(t *testing.T, store Store, evictions *int) {
+	t.Helper()
+
+	if !store.Set("a", []byte("aaaa")) || !store.Set("b", []byte("bbbb")) {
+		t.Fatal("Set()\nExpected values to be stored")
+	}
+	if got, ok := store.Get("a"); !ok || string(got) != "aaaa" {
+		t.Fatalf("Get(a)\nExpected %q, but got: %q (%v)", "aaaa", got, ok)
+	}
+
+	// "b" is the least recently used, so it makes room for "c".
+	if !store.Set("c", []byte("cccc")) {
+		t.Fatal("Set(c)\nExpected value to be stored")
+	}
+	if _, ok := store.Get("b"); ok {
+		t.Error("Get(b)\nExpected least recently used value to be evicted")
+	}
+	if *evictions != 1 {
+		t.Errorf("Set(c)\nExpected 1 eviction, but got: %d", *evictions)
+	}
+	if store.Len() != 2 || store.Size() != 8 {
+		t.Errorf("Len(), Size()\nExpected 2 entries of 8 bytes, but got: %d entries of %d bytes", store.Len(), store.Size())
+	}
+
+	// Replacing a value updates the size.
+	store.Set("a", []byte("a"))
+	if store.Len() != 2 || store.Size() != 5 {
+		t.Errorf("Len(), Size()\nExpected 2 entries of 5 bytes, but got: %d entries of %d bytes", store.Len(), store.Size())
+	}
+
+	// Values larger than the store are not stored, and drop the previous one.
+	if store.Set("a", []byte(strings.Repeat("a", 11))) {
+		t.Error("Set()\nExpected oversized value not to be stored")
+	}
+	if _, ok := store.Get("a"); ok {
+		t.Error("Get(a)\nExpected previous value to be dropped")
+	}
+
+	store.Delete("c")
+	if _, ok := store.Get("c"); ok || store.Len() != 0 || store.Size() != 0 {
+		t.Errorf("Delete(c)\nExpected empty store, but got: %d entries of %d bytes", store.Len(), store.Size())
+	}
+}
+
+// TestMemory checks the Memory store.
+func TestMemory(t *testing.T) {
+	evictions := 0
+	testStore(t, NewMemory(10, func() { evictions++ }), &evictions)
+}
+
+// TestDisk checks the Disk store.
+func TestDisk(t *testing.T) {
+	evictions := 0
+	testStore(t, mustOpenDisk(t, t.TempDir(), 10, func() { evictions++ }), &evictions)
+}
+
+// TestOpenDisk checks that the values of a Disk store survive a restart,
+// evicting the least recently used ones if they no longer fit.
+func TestOpenDisk(t *testing.T) {
+	dir := t.TempDir()
+	store := mustOpenDisk(t, dir, 100, nil)
+	store.Set("old", []byte("old"))
+	store.Set("new", []byte("new"))
+
+	// Make "old" the least recently used, regardless of the file system
+	// timestamp resolution.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, diskName("old")), past, past); err != nil {
+		t.Fatalf("Chtimes() failed: %v", err)
+	}
+	// An interrupted write is cleaned up.
+	if err := os.WriteFile(filepath.Join(dir, "123.tmp"), []byte("partial"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	evictions := 0
+	reopened := mustOpenDisk(t, dir, 3, func() { evictions++ })
+	if got, ok := reopened.Get("new"); !ok || string(got) != "new" {
+		t.Errorf("Get(new)\nExpected %q, but got: %q (%v)", "new", got, ok)
+	}
+	if _, ok := reopened.Get("old"); ok || evictions != 1 {
+		t.Errorf("Get(old)\nExpected least recently used value to be evicted, but got %d evictions", evictions)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "123.tmp")); !os.IsNotExist(err) {
+		t.Errorf("OpenDisk()\nExpected temporary file to be removed, but got: %v", err)
+	}
+}
+
+// mustOpenDisk opens a Disk store or fails the test.
+func mustOpenDisk(t *testing.T, dir string, limit int64, onEvict EvictFunc) *Disk {
+	t.Helper()
+
+	store, err := OpenDisk(dir, limit, onEvict)
+	if err != nil {
+		t.Fatalf("OpenDisk() failed: %v", err)
+	}
+
+	return store
+}
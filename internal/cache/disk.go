@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// diskExt is the extension of the files holding the values of a Disk store.
+const diskExt = ".cache"
+
+// Disk is a Store that keeps every value in a file of a directory, named
+// after the hash of its key. The modification time of the files tracks when
+// they were last used, so that the eviction order survives restarts.
+type Disk struct {
+	mu      sync.Mutex
+	dir     string
+	index   *lru // Indexed by file name
+	onEvict EvictFunc
+}
+
+// OpenDisk opens a Disk store in the directory, which is created if needed,
+// bounded by limit bytes. Existing values are kept, and the least recently used
+// ones evicted if they exceed the bound. The onEvict function, if not nil, is
+// called for every evicted entry.
+func OpenDisk(dir string, limit int64, onEvict EvictFunc) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case diskExt:
+			files = append(files, file{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+		case ".tmp":
+			// Leftovers of writes interrupted by a crash.
+			_ = os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	// Index the files from the least to the most recently used.
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+
+	d := &Disk{dir: dir, index: newLRU(limit), onEvict: onEvict}
+	for _, f := range files {
+		d.evict(d.index.add(f.name, f.size))
+	}
+
+	return d, nil
+}
+
+// Get implements the Store interface.
+func (d *Disk) Get(key string) ([]byte, bool) {
+	name := diskName(key)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.index.touch(name) {
+		return nil, false
+	}
+
+	path := filepath.Join(d.dir, name)
+	value, err := os.ReadFile(path)
+	if err != nil {
+		// The file was removed behind our back.
+		d.index.remove(name)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return value, true
+}
+
+// Set implements the Store interface.
+func (d *Disk) Set(key string, value []byte) bool {
+	name := diskName(key)
+	size := int64(len(value))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if size > d.index.limit {
+		d.delete(name)
+		return false
+	}
+
+	// Write to a temporary file first, so that readers never see a partial
+	// value.
+	tmp, err := os.CreateTemp(d.dir, "*.tmp")
+	if err != nil {
+		return false
+	}
+	_, err = tmp.Write(value)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return false
+	}
+
+	d.evict(d.index.add(name, size))
+
+	return true
+}
+
+// Delete implements the Store interface.
+func (d *Disk) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.delete(diskName(key))
+}
+
+// delete removes the file from the store. The caller must hold the lock.
+func (d *Disk) delete(name string) {
+	d.index.remove(name)
+	_ = os.Remove(filepath.Join(d.dir, name))
+}
+
+// evict removes the files of the evicted entries. The caller must hold the
+// lock.
+func (d *Disk) evict(names []string) {
+	for _, name := range names {
+		_ = os.Remove(filepath.Join(d.dir, name))
+		if d.onEvict != nil {
+			d.onEvict()
+		}
+	}
+}
+
+// Len implements the Store interface.
+func (d *Disk) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.index.entries)
+}
+
+// Size implements the Store interface.
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.index.size
+}
+
+// diskName returns the name of the file holding the value of the key.
+func diskName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskExt
+}
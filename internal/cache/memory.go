@@ -0,0 +1,85 @@
+package cache
+
+import "sync"
+
+// Memory is a Store that keeps the values in memory.
+type Memory struct {
+	mu      sync.Mutex
+	index   *lru
+	values  map[string][]byte
+	onEvict EvictFunc
+}
+
+// NewMemory creates a Memory store bounded by limit bytes. The onEvict
+// function, if not nil, is called for every evicted entry.
+func NewMemory(limit int64, onEvict EvictFunc) *Memory {
+	return &Memory{
+		index:   newLRU(limit),
+		values:  make(map[string][]byte),
+		onEvict: onEvict,
+	}
+}
+
+// Get implements the Store interface.
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.index.touch(key) {
+		return nil, false
+	}
+	return m.values[key], true
+}
+
+// Set implements the Store interface.
+func (m *Memory) Set(key string, value []byte) bool {
+	size := int64(len(value))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if size > m.index.limit {
+		m.delete(key)
+		return false
+	}
+
+	m.values[key] = value
+	for _, evicted := range m.index.add(key, size) {
+		delete(m.values, evicted)
+		if m.onEvict != nil {
+			m.onEvict()
+		}
+	}
+
+	return true
+}
+
+// Delete implements the Store interface.
+func (m *Memory) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.delete(key)
+}
+
+// delete removes the key from the store. The caller must hold the lock.
+func (m *Memory) delete(key string) {
+	m.index.remove(key)
+	delete(m.values, key)
+}
+
+// Len implements the Store interface.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.values)
+}
+
+// Size implements the Store interface.
+func (m *Memory) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.index.size
+}
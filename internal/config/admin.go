@@ -0,0 +1,25 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// AdminConfig represents a configuration for the built-in endpoints served by
+// prxy itself instead of being forwarded to the target.
+type AdminConfig struct {
+	Prefix string `koanf:"prefix"` // Reserved path prefix for the built-in endpoints
+}
+
+// Validate checks if the admin configuration is valid.
+func (cfg AdminConfig) Validate() error {
+	if !strings.HasPrefix(cfg.Prefix, "/") {
+		return errors.New("invalid admin prefix: must start with '/'")
+	}
+
+	if cfg.Prefix == "/" || strings.HasSuffix(cfg.Prefix, "/") {
+		return errors.New("invalid admin prefix: must not end with '/'")
+	}
+
+	return nil
+}
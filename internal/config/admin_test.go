@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+)
+
+// TestAdminConfigValidate checks the Admin Config validation.
+func TestAdminConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string      // Name of the test case
+		config      AdminConfig // The Admin configuration
+		expectError bool        // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_default_prefix",
+			config:      Defaults.Admin,
+			expectError: false,
+		},
+		{
+			name:        "valid_nested_prefix",
+			config:      AdminConfig{Prefix: "/internal/prxy"},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "empty_prefix",
+			config:      AdminConfig{},
+			expectError: true,
+		},
+		{
+			name:        "root_prefix",
+			config:      AdminConfig{Prefix: "/"},
+			expectError: true,
+		},
+		{
+			name:        "prefix_without_leading_slash",
+			config:      AdminConfig{Prefix: "_prxy"},
+			expectError: true,
+		},
+		{
+			name:        "prefix_with_trailing_slash",
+			config:      AdminConfig{Prefix: "/_prxy/"},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
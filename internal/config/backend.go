@@ -0,0 +1,217 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Madh93/prxy/internal/validation"
+)
+
+// BackendConfig represents a target that receives a share of the traffic.
+type BackendConfig struct {
+	URL    string `koanf:"url"`    // Target URL
+	Weight *int   `koanf:"weight"` // Relative share of the traffic, 1 if not set
+}
+
+// backendWeight matches the weight suffix of a backend in its text form.
+var backendWeight = regexp.MustCompile(`=(\d+)$`)
+
+// UnmarshalText parses a backend in the URL or URL=WEIGHT form, as given on
+// the command line.
+func (cfg *BackendConfig) UnmarshalText(text []byte) error {
+	cfg.URL = string(text)
+	cfg.Weight = nil
+
+	if match := backendWeight.FindStringSubmatchIndex(cfg.URL); match != nil {
+		weight, err := strconv.Atoi(cfg.URL[match[2]:match[3]])
+		if err != nil {
+			return fmt.Errorf("invalid backend weight in %q: %v", text, err)
+		}
+		cfg.URL = cfg.URL[:match[0]]
+		cfg.Weight = &weight
+	}
+
+	return nil
+}
+
+// EffectiveWeight returns the weight of the backend, 1 if not set.
+func (cfg BackendConfig) EffectiveWeight() int {
+	if cfg.Weight == nil {
+		return 1
+	}
+	return *cfg.Weight
+}
+
+// BalanceStrategy defines how a backend is chosen for a request.
+type BalanceStrategy string
+
+// BalanceSticky defines how clients are kept on the same backend.
+type BalanceSticky string
+
+// BalanceConfig represents a configuration for spreading the traffic among
+// the backends.
+type BalanceConfig struct {
+	Strategy BalanceStrategy `koanf:"strategy"` // How a backend is chosen for a request
+	Hash     string          `koanf:"hash"`     // Request attribute hashed by the hash strategy
+	Sticky   BalanceSticky   `koanf:"sticky"`   // How clients are kept on the same backend
+	Cookie   string          `koanf:"cookie"`   // Name of the cookie used for sticky assignment
+	Check    BalanceCheck    `koanf:"check"`    // Active health checks of the backends
+	Eject    BalanceEject    `koanf:"eject"`    // Passive health checks of the backends
+}
+
+// BalanceCheck represents a configuration for probing the backends
+// periodically.
+type BalanceCheck struct {
+	Interval time.Duration `koanf:"interval"` // How often the backends are probed, 0 disables it
+	Timeout  time.Duration `koanf:"timeout"`  // Maximum duration of a probe
+	Path     string        `koanf:"path"`     // Path on the backends to probe
+	Status   int           `koanf:"status"`   // Expected status code of the probe
+}
+
+// BalanceEject represents a configuration for taking the backends out of the
+// pool when they fail to serve requests.
+type BalanceEject struct {
+	Failures int           `koanf:"failures"` // Consecutive failures that eject a backend, 0 disables it
+	Duration time.Duration `koanf:"duration"` // How long a backend stays ejected
+}
+
+// Balance strategies.
+const (
+	BalanceStrategyRandom     BalanceStrategy = "random"      // Weighted random choice
+	BalanceStrategyRoundRobin BalanceStrategy = "round-robin" // Weighted round-robin
+	BalanceStrategyLeastConn  BalanceStrategy = "least-conn"  // Fewest requests in flight, relative to the weight
+	BalanceStrategyHash       BalanceStrategy = "hash"        // Consistent hashing of a request attribute
+)
+
+// ValidBalanceStrategies are the allowed balance strategies.
+var ValidBalanceStrategies = []BalanceStrategy{BalanceStrategyRandom, BalanceStrategyRoundRobin, BalanceStrategyLeastConn, BalanceStrategyHash}
+
+// Sticky assignment modes.
+const (
+	BalanceStickyNone   BalanceSticky = "none"   // Every request is assigned on its own
+	BalanceStickyCookie BalanceSticky = "cookie" // Clients are assigned with a cookie
+	BalanceStickyIP     BalanceSticky = "ip"     // Clients are assigned by IP address
+)
+
+// ValidBalanceSticky are the allowed sticky assignment modes.
+var ValidBalanceSticky = []BalanceSticky{BalanceStickyNone, BalanceStickyCookie, BalanceStickyIP}
+
+// Validate checks if the balance configuration is valid.
+func (cfg BalanceConfig) Validate() error {
+	var errs []error
+
+	if err := validation.Validate(cfg.Strategy, ValidBalanceStrategies); err != nil {
+		errs = append(errs, fmt.Errorf("invalid balance strategy: %v", err))
+	}
+
+	if _, _, err := ParseBalanceHash(cfg.Hash); err != nil {
+		errs = append(errs, fmt.Errorf("invalid balance hash: %v", err))
+	}
+
+	if err := validation.Validate(cfg.Sticky, ValidBalanceSticky); err != nil {
+		errs = append(errs, fmt.Errorf("invalid balance sticky mode: %v", err))
+	}
+
+	if cfg.Sticky == BalanceStickyCookie && cfg.Cookie == "" {
+		errs = append(errs, errors.New("balance cookie must be specified when sticky mode is 'cookie'"))
+	}
+
+	if cfg.Check.Interval < 0 {
+		errs = append(errs, fmt.Errorf("invalid balance check interval: %v", cfg.Check.Interval))
+	}
+
+	if cfg.Check.Interval > 0 {
+		if cfg.Check.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("invalid balance check timeout: %v", cfg.Check.Timeout))
+		}
+		if !strings.HasPrefix(cfg.Check.Path, "/") {
+			errs = append(errs, errors.New("invalid balance check path: must start with '/'"))
+		}
+		if http.StatusText(cfg.Check.Status) == "" {
+			errs = append(errs, fmt.Errorf("invalid balance check status: %d", cfg.Check.Status))
+		}
+	}
+
+	if cfg.Eject.Failures < 0 {
+		errs = append(errs, fmt.Errorf("invalid balance eject failures: %d", cfg.Eject.Failures))
+	}
+
+	if cfg.Eject.Failures > 0 && cfg.Eject.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("invalid balance eject duration: %v", cfg.Eject.Duration))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// ParseBalanceHash parses the request attribute hashed by the hash strategy:
+// "ip", "path", "header:<name>" or "cookie:<name>". It returns the kind of
+// attribute and, for headers and cookies, their name.
+func ParseBalanceHash(hash string) (kind, name string, err error) {
+	kind, name, _ = strings.Cut(hash, ":")
+	switch kind {
+	case "ip", "path":
+		if name != "" {
+			return "", "", fmt.Errorf("%q does not take a name", kind)
+		}
+	case "header", "cookie":
+		if name == "" {
+			return "", "", fmt.Errorf("%q requires a name, as %s:<name>", kind, kind)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid value %q (valid values are ip, path, header:<name> and cookie:<name>)", hash)
+	}
+
+	return kind, name, nil
+}
+
+// TargetBackends returns the backends that receive the traffic: the backends
+// if any, or the target otherwise.
+func (cfg Config) TargetBackends() []BackendConfig {
+	if len(cfg.Backends) == 0 {
+		return []BackendConfig{{URL: cfg.Target}}
+	}
+	return cfg.Backends
+}
+
+// validateBackends checks that every backend is valid and that at least one
+// of them receives traffic.
+func validateBackends(backends []BackendConfig) error {
+	var errs []error
+
+	total := 0
+	seen := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		if err := validation.ValidateURL(backend.URL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid backend URL: %v", err))
+		}
+		if seen[backend.URL] {
+			errs = append(errs, fmt.Errorf("duplicated backend URL: %s", backend.URL))
+		}
+		seen[backend.URL] = true
+
+		weight := backend.EffectiveWeight()
+		if weight < 0 {
+			errs = append(errs, fmt.Errorf("invalid backend weight for %s: %d", backend.URL, weight))
+		}
+		total += weight
+	}
+
+	if total <= 0 {
+		errs = append(errs, errors.New("at least one backend must have a positive weight"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBackendConfigUnmarshalText checks the parsing of backends given on the
+// command line.
+func TestBackendConfigUnmarshalText(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name           string // Name of the test case
+		text           string // Backend in text form
+		expectedURL    string // Expected URL
+		expectedWeight int    // Expected effective weight
+		expectError    bool   // true if an error is expected, false otherwise
+	}{
+		{
+			name:           "url_only",
+			text:           "http://old.example.com",
+			expectedURL:    "http://old.example.com",
+			expectedWeight: 1,
+		},
+		{
+			name:           "url_with_weight",
+			text:           "http://old.example.com=90",
+			expectedURL:    "http://old.example.com",
+			expectedWeight: 90,
+		},
+		{
+			name:           "url_with_zero_weight",
+			text:           "https://new.example.com:8443=0",
+			expectedURL:    "https://new.example.com:8443",
+			expectedWeight: 0,
+		},
+		{
+			name:           "equal_sign_in_query",
+			text:           "http://old.example.com/?a=b",
+			expectedURL:    "http://old.example.com/?a=b",
+			expectedWeight: 1,
+		},
+		{
+			name:        "weight_overflow",
+			text:        "http://old.example.com=99999999999999999999",
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backend BackendConfig
+			err := backend.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.expectError {
+				t.Fatalf("UnmarshalText(%q)\nExpected error: %v, but got: %v", tt.text, tt.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+			if backend.URL != tt.expectedURL {
+				t.Errorf("UnmarshalText(%q)\nExpected URL %q, but got: %q", tt.text, tt.expectedURL, backend.URL)
+			}
+			if got := backend.EffectiveWeight(); got != tt.expectedWeight {
+				t.Errorf("UnmarshalText(%q)\nExpected weight %d, but got: %d", tt.text, tt.expectedWeight, got)
+			}
+		})
+	}
+}
+
+// TestValidateBackends checks the validation of the backends.
+func TestValidateBackends(t *testing.T) {
+	zero, ten, negative := 0, 10, -1
+
+	// Test cases
+	tests := []struct {
+		name        string          // Name of the test case
+		backends    []BackendConfig // The backends
+		expectError bool            // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_single_backend",
+			backends:    []BackendConfig{{URL: "http://old.example.com"}},
+			expectError: false,
+		},
+		{
+			name:        "valid_weighted_backends",
+			backends:    []BackendConfig{{URL: "http://old.example.com", Weight: &ten}, {URL: "https://new.example.com"}},
+			expectError: false,
+		},
+		{
+			name:        "valid_drained_backend",
+			backends:    []BackendConfig{{URL: "http://old.example.com"}, {URL: "https://new.example.com", Weight: &zero}},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "invalid_url",
+			backends:    []BackendConfig{{URL: "ftp://old.example.com"}},
+			expectError: true,
+		},
+		{
+			name:        "duplicated_url",
+			backends:    []BackendConfig{{URL: "http://old.example.com"}, {URL: "http://old.example.com"}},
+			expectError: true,
+		},
+		{
+			name:        "negative_weight",
+			backends:    []BackendConfig{{URL: "http://old.example.com", Weight: &negative}, {URL: "https://new.example.com"}},
+			expectError: true,
+		},
+		{
+			name:        "no_traffic",
+			backends:    []BackendConfig{{URL: "http://old.example.com", Weight: &zero}},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateBackends(tt.backends)
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Backends: %+v\nExpected error, but got: %v", tt.backends, got)
+				} else {
+					t.Errorf("Backends: %+v\nExpected no error, but got: %v", tt.backends, got)
+				}
+			}
+		})
+	}
+}
+
+// TestBalanceConfigValidate checks the Balance Config validation.
+func TestBalanceConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string        // Name of the test case
+		config      BalanceConfig // The Balance configuration
+		expectError bool          // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Balance,
+			expectError: false,
+		},
+		{
+			name:        "valid_sticky_cookie",
+			config:      BalanceConfig{Strategy: BalanceStrategyRandom, Hash: "ip", Sticky: BalanceStickyCookie, Cookie: "canary"},
+			expectError: false,
+		},
+		{
+			name:        "valid_sticky_ip",
+			config:      BalanceConfig{Strategy: BalanceStrategyLeastConn, Hash: "ip", Sticky: BalanceStickyIP},
+			expectError: false,
+		},
+		{
+			name:        "valid_hash_header",
+			config:      BalanceConfig{Strategy: BalanceStrategyHash, Hash: "header:X-User", Sticky: BalanceStickyNone},
+			expectError: false,
+		},
+		{
+			name:        "valid_checks_and_ejection",
+			config:      BalanceConfig{Strategy: BalanceStrategyRoundRobin, Hash: "path", Sticky: BalanceStickyNone, Check: BalanceCheck{Interval: time.Second, Timeout: time.Second, Path: "/healthz", Status: 204}, Eject: BalanceEject{Failures: 3, Duration: time.Minute}},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "invalid_strategy",
+			config:      BalanceConfig{Strategy: "fastest", Hash: "ip", Sticky: BalanceStickyNone},
+			expectError: true,
+		},
+		{
+			name:        "invalid_hash",
+			config:      BalanceConfig{Strategy: BalanceStrategyHash, Hash: "header", Sticky: BalanceStickyNone},
+			expectError: true,
+		},
+		{
+			name:        "invalid_sticky",
+			config:      BalanceConfig{Strategy: BalanceStrategyRandom, Hash: "ip", Sticky: "header"},
+			expectError: true,
+		},
+		{
+			name:        "sticky_cookie_without_name",
+			config:      BalanceConfig{Strategy: BalanceStrategyRandom, Hash: "ip", Sticky: BalanceStickyCookie},
+			expectError: true,
+		},
+		{
+			name:        "check_without_timeout",
+			config:      BalanceConfig{Strategy: BalanceStrategyRandom, Hash: "ip", Sticky: BalanceStickyNone, Check: BalanceCheck{Interval: time.Second, Path: "/", Status: 200}},
+			expectError: true,
+		},
+		{
+			name:        "check_with_relative_path",
+			config:      BalanceConfig{Strategy: BalanceStrategyRandom, Hash: "ip", Sticky: BalanceStickyNone, Check: BalanceCheck{Interval: time.Second, Timeout: time.Second, Path: "healthz", Status: 200}},
+			expectError: true,
+		},
+		{
+			name:        "eject_without_duration",
+			config:      BalanceConfig{Strategy: BalanceStrategyRandom, Hash: "ip", Sticky: BalanceStickyNone, Eject: BalanceEject{Failures: 3}},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
+
+// TestParseBalanceHash checks the parsing of the hashed request attribute.
+func TestParseBalanceHash(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		hash         string // Hashed request attribute
+		expectedKind string // Expected kind of attribute
+		expectedName string // Expected header or cookie name
+		expectError  bool   // true if an error is expected, false otherwise
+	}{
+		{hash: "ip", expectedKind: "ip"},
+		{hash: "path", expectedKind: "path"},
+		{hash: "header:X-User", expectedKind: "header", expectedName: "X-User"},
+		{hash: "cookie:session", expectedKind: "cookie", expectedName: "session"},
+		{hash: "ip:x", expectError: true},
+		{hash: "cookie:", expectError: true},
+		{hash: "query:id", expectError: true},
+		{hash: "", expectError: true},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.hash, func(t *testing.T) {
+			kind, name, err := ParseBalanceHash(tt.hash)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ParseBalanceHash(%q)\nExpected error: %v, but got: %v", tt.hash, tt.expectError, err)
+			}
+			if kind != tt.expectedKind || name != tt.expectedName {
+				t.Errorf("ParseBalanceHash(%q)\nExpected %q and %q, but got: %q and %q", tt.hash, tt.expectedKind, tt.expectedName, kind, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BodyConfig represents a configuration for how the bodies of the requests
+// and responses are passed between the clients and the target.
+type BodyConfig struct {
+	Flush  time.Duration `koanf:"flush"`  // Interval between flushes of the response, negative to flush after every write
+	Buffer bool          `koanf:"buffer"` // Whether request bodies are read in full before they are sent
+	Max    int64         `koanf:"max"`    // Maximum size of a buffered request body
+}
+
+// Validate checks if the body configuration is valid.
+func (cfg BodyConfig) Validate() error {
+	var errs []error
+
+	if cfg.Max <= 0 {
+		errs = append(errs, fmt.Errorf("invalid body max: %d", cfg.Max))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// RouteBody holds the body settings of a route. Unset settings fall back to
+// the global ones.
+type RouteBody struct {
+	Flush  *time.Duration `koanf:"flush"`  // Interval between flushes of the response, negative to flush after every write
+	Buffer *bool          `koanf:"buffer"` // Whether request bodies are read in full before they are sent
+	Max    *int64         `koanf:"max"`    // Maximum size of a buffered request body
+}
+
+// Resolve returns the global settings overridden by the ones of the route.
+func (cfg RouteBody) Resolve(global BodyConfig) BodyConfig {
+	if cfg.Flush != nil {
+		global.Flush = *cfg.Flush
+	}
+	if cfg.Buffer != nil {
+		global.Buffer = *cfg.Buffer
+	}
+	if cfg.Max != nil {
+		global.Max = *cfg.Max
+	}
+	return global
+}
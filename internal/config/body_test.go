@@ -0,0 +1,87 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBodyConfigValidate checks the Body Config validation.
+func TestBodyConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string     // Name of the test case
+		config      BodyConfig // The Body configuration
+		expectError bool       // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Body,
+			expectError: false,
+		},
+		{
+			name:        "valid_immediate_flush",
+			config:      BodyConfig{Flush: -1, Buffer: true, Max: 1 << 10},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "zero_max",
+			config:      BodyConfig{Buffer: true},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
+
+// TestRouteBodyResolve checks that the settings of a route override the
+// global ones.
+func TestRouteBodyResolve(t *testing.T) {
+	flush, buffer, limit := 100*time.Millisecond, true, int64(1<<10)
+	global := BodyConfig{Flush: -1, Max: 1 << 20}
+
+	// Test cases
+	tests := []struct {
+		name     string     // Name of the test case
+		route    RouteBody  // The body settings of the route
+		expected BodyConfig // The expected resolved settings
+	}{
+		{
+			name:     "unset",
+			route:    RouteBody{},
+			expected: global,
+		},
+		{
+			name:     "partial",
+			route:    RouteBody{Buffer: &buffer},
+			expected: BodyConfig{Flush: -1, Buffer: true, Max: 1 << 20},
+		},
+		{
+			name:     "full",
+			route:    RouteBody{Flush: &flush, Buffer: &buffer, Max: &limit},
+			expected: BodyConfig{Flush: flush, Buffer: true, Max: limit},
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.route.Resolve(global); got != tt.expected {
+				t.Errorf("Resolve(%+v)\nExpected %+v, but got: %+v", global, tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BreakerConfig represents a configuration for the circuit breaker around the
+// upstream target.
+type BreakerConfig struct {
+	Failures int           `koanf:"failures"` // Consecutive failures that open the circuit, 0 disables it
+	Statuses []int         `koanf:"statuses"` // Response status codes counted as failures, besides connection errors
+	Cooldown time.Duration `koanf:"cooldown"` // How long the circuit stays open before letting probes through
+	Probes   int           `koanf:"probes"`   // Successful probes needed to close the circuit again
+}
+
+// Validate checks if the breaker configuration is valid.
+func (cfg BreakerConfig) Validate() error {
+	var errs []error
+
+	if cfg.Failures < 0 {
+		errs = append(errs, fmt.Errorf("invalid breaker failures: %d", cfg.Failures))
+	}
+
+	for _, status := range cfg.Statuses {
+		if status < 100 || status > 599 {
+			errs = append(errs, fmt.Errorf("invalid breaker status: %d", status))
+		}
+	}
+
+	if cfg.Failures > 0 {
+		if cfg.Cooldown <= 0 {
+			errs = append(errs, fmt.Errorf("invalid breaker cooldown: %v", cfg.Cooldown))
+		}
+		if cfg.Probes <= 0 {
+			errs = append(errs, fmt.Errorf("invalid breaker probes: %d", cfg.Probes))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBreakerConfigValidate checks the Breaker Config validation.
+func TestBreakerConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string        // Name of the test case
+		config      BreakerConfig // The Breaker configuration
+		expectError bool          // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Breaker,
+			expectError: false,
+		},
+		{
+			name:        "valid_breaker",
+			config:      BreakerConfig{Failures: 5, Statuses: []int{502}, Cooldown: time.Second, Probes: 1},
+			expectError: false,
+		},
+		{
+			name:        "disabled_breaker_ignores_cooldown",
+			config:      BreakerConfig{},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_failures",
+			config:      BreakerConfig{Failures: -1},
+			expectError: true,
+		},
+		{
+			name:        "invalid_status",
+			config:      BreakerConfig{Statuses: []int{600}},
+			expectError: true,
+		},
+		{
+			name:        "zero_cooldown",
+			config:      BreakerConfig{Failures: 5, Probes: 1},
+			expectError: true,
+		},
+		{
+			name:        "zero_probes",
+			config:      BreakerConfig{Failures: 5, Cooldown: time.Second},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Madh93/prxy/internal/validation"
+)
+
+// CacheStore defines where the cached responses are stored.
+type CacheStore string
+
+// CacheConfig represents a configuration for caching the responses of the
+// target.
+type CacheConfig struct {
+	Store  CacheStore    `koanf:"store"`  // Where the responses are stored, none disables caching
+	Path   string        `koanf:"path"`   // Directory of the disk store
+	Size   int64         `koanf:"size"`   // Maximum size of all the stored responses, in bytes
+	Object CacheObject   `koanf:"object"` // Stored responses settings
+	Stale  time.Duration `koanf:"stale"`  // Maximum staleness of the responses served when the target fails
+}
+
+// CacheObject holds the settings of every stored response.
+type CacheObject struct {
+	Max int64 `koanf:"max"` // Maximum size of a stored response body, in bytes
+}
+
+// Cache stores.
+const (
+	CacheStoreNone   CacheStore = "none"   // Responses are not cached
+	CacheStoreMemory CacheStore = "memory" // Responses are kept in memory
+	CacheStoreDisk   CacheStore = "disk"   // Responses are kept in a directory
+)
+
+// ValidCacheStores are the allowed cache stores.
+var ValidCacheStores = []CacheStore{CacheStoreNone, CacheStoreMemory, CacheStoreDisk}
+
+// Validate checks if the cache configuration is valid.
+func (cfg CacheConfig) Validate() error {
+	var errs []error
+
+	if err := validation.Validate(cfg.Store, ValidCacheStores); err != nil {
+		errs = append(errs, fmt.Errorf("invalid cache store: %v", err))
+	}
+
+	if cfg.Store == CacheStoreDisk && cfg.Path == "" {
+		errs = append(errs, errors.New("cache path must be specified when store is 'disk'"))
+	}
+
+	if cfg.Size <= 0 {
+		errs = append(errs, fmt.Errorf("invalid cache size: %d", cfg.Size))
+	}
+
+	if cfg.Object.Max <= 0 || cfg.Object.Max > cfg.Size {
+		errs = append(errs, fmt.Errorf("invalid cache object max: %d (must be between 1 and the cache size)", cfg.Object.Max))
+	}
+
+	if cfg.Stale < 0 {
+		errs = append(errs, fmt.Errorf("invalid cache stale: %v", cfg.Stale))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCacheConfigValidate checks the Cache Config validation.
+func TestCacheConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string      // Name of the test case
+		config      CacheConfig // The Cache configuration
+		expectError bool        // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Cache,
+			expectError: false,
+		},
+		{
+			name:        "valid_memory",
+			config:      CacheConfig{Store: CacheStoreMemory, Size: 1 << 20, Object: CacheObject{Max: 1 << 10}, Stale: time.Hour},
+			expectError: false,
+		},
+		{
+			name:        "valid_disk",
+			config:      CacheConfig{Store: CacheStoreDisk, Path: "/var/cache/prxy", Size: 1 << 20, Object: CacheObject{Max: 1 << 20}},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "invalid_store",
+			config:      CacheConfig{Store: "redis", Size: 1 << 20, Object: CacheObject{Max: 1 << 10}},
+			expectError: true,
+		},
+		{
+			name:        "disk_without_path",
+			config:      CacheConfig{Store: CacheStoreDisk, Size: 1 << 20, Object: CacheObject{Max: 1 << 10}},
+			expectError: true,
+		},
+		{
+			name:        "zero_size",
+			config:      CacheConfig{Store: CacheStoreMemory, Object: CacheObject{Max: 1 << 10}},
+			expectError: true,
+		},
+		{
+			name:        "object_larger_than_cache",
+			config:      CacheConfig{Store: CacheStoreMemory, Size: 1 << 10, Object: CacheObject{Max: 1 << 20}},
+			expectError: true,
+		},
+		{
+			name:        "negative_stale",
+			config:      CacheConfig{Store: CacheStoreMemory, Size: 1 << 20, Object: CacheObject{Max: 1 << 10}, Stale: -time.Second},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
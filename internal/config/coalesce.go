@@ -0,0 +1,35 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CoalesceConfig represents a configuration for collapsing identical
+// concurrent requests into a single upstream round trip.
+type CoalesceConfig struct {
+	Enabled bool     `koanf:"enabled"` // Whether requests are coalesced, unless a route says otherwise
+	Headers []string `koanf:"headers"` // Request headers that must match, besides the method and URL
+	Buffer  int64    `koanf:"buffer"`  // Maximum response body size shared with the waiting requests
+}
+
+// Validate checks if the coalesce configuration is valid.
+func (cfg CoalesceConfig) Validate() error {
+	var errs []error
+
+	for _, header := range cfg.Headers {
+		if header == "" {
+			errs = append(errs, errors.New("invalid coalesce header: must not be empty"))
+		}
+	}
+
+	if cfg.Buffer <= 0 {
+		errs = append(errs, fmt.Errorf("invalid coalesce buffer: %d", cfg.Buffer))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import "testing"
+
+// TestCoalesceConfigValidate checks the Coalesce Config validation.
+func TestCoalesceConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string         // Name of the test case
+		config      CoalesceConfig // The Coalesce configuration
+		expectError bool           // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Coalesce,
+			expectError: false,
+		},
+		{
+			name:        "valid_without_headers",
+			config:      CoalesceConfig{Enabled: true, Buffer: 1 << 10},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "empty_header",
+			config:      CoalesceConfig{Enabled: true, Headers: []string{"Accept", ""}, Buffer: 1 << 10},
+			expectError: true,
+		},
+		{
+			name:        "zero_buffer",
+			config:      CoalesceConfig{Enabled: true},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
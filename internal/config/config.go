@@ -9,10 +9,76 @@
 //   - Config: Represents the overall configuration object, containing nested
 //     configurations for Host, Port, Proxy URL and Target URL, and Logging settings.
 //
+//   - BackendConfig: Holds a target URL and the share of the traffic it
+//     receives, when the traffic is split among several targets.
+//
+//   - BalanceConfig: Holds how the traffic is spread among the backends, such
+//     as the balance strategy, the sticky assignment of clients and the active
+//     and passive health checks of the backends.
+//
 //   - LoggingConfig: Holds logging configuration settings, including the log level,
 //     format, output destination, and path for log files. It includes validation
 //     to ensure the logging settings are correct and conform to allowed values.
 //
+//   - AdminConfig: Holds the reserved path prefix for the endpoints served by
+//     prxy itself, such as the health and readiness endpoints.
+//
+//   - HealthConfig: Holds the readiness check settings, including how long the
+//     results are cached and the optional probe path on the target.
+//
+//   - ReadyConfig: Holds the settings to announce the bound address once the
+//     server is listening.
+//
+//   - SocketConfig: Holds the file mode and owner of unix socket listeners.
+//
+//   - TLSConfig: Holds the certificate and key of TLS listeners.
+//
+//   - ServerConfig: Holds the inbound HTTP server settings, such as timeouts.
+//
+//   - UpstreamConfig: Holds the settings of the outbound connections to the
+//     target through the proxy, such as timeouts and connection pool limits.
+//
+//   - RetryConfig: Holds the policy to retry failed upstream round trips.
+//
+//   - BreakerConfig: Holds the thresholds of the circuit breaker that fails
+//     fast while the target is down.
+//
+//   - ErrorConfig: Holds the format of the responses sent to clients when a
+//     request cannot be proxied.
+//
+//   - RecordConfig: Holds the settings to record the proxied traffic to a HAR
+//     file.
+//
+//   - ReplayConfig: Holds the settings to serve recorded responses instead of
+//     contacting the target.
+//
+//   - MirrorConfig: Holds the secondary target that receives a copy of the
+//     proxied traffic, and how it is sent.
+//
+//   - CacheConfig: Holds where the responses of the target are cached, the
+//     size limits and how long stale responses may be served on errors.
+//
+//   - CoalesceConfig: Holds whether identical concurrent requests share a
+//     single upstream round trip, and what makes them identical.
+//
+//   - BodyConfig: Holds how often responses are flushed to the clients and
+//     whether request bodies are buffered before they are sent.
+//
+//   - LimitConfig: Holds the maximum number of requests in flight to the
+//     target and how the ones over it are queued.
+//
+//   - ThrottleConfig: Holds the rate limits of the traffic and the latency
+//     added to every request, to simulate slow links.
+//
+//   - UpgradeConfig: Holds how long upgraded connections, such as WebSockets,
+//     may stay idle and how long they are given to close on shutdown.
+//
+//   - FaultConfig: Holds a rule that injects delays, errors or broken
+//     connections into the requests it matches.
+//
+//   - RouteConfig: Holds the settings that only apply to the requests under a
+//     path, overriding the global ones.
+//
 // The package also provides a New function to create a new configuration
 // instance, initializing it with default values, loading settings from environment
 // variables and processing command line flags. It ensures that settings are
@@ -20,10 +86,16 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"time"
 
 	"github.com/Madh93/prxy/internal/validation"
+	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/cliflagv3"
+	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
 	"github.com/urfave/cli/v3"
 )
@@ -31,11 +103,35 @@ import (
 // Config represents a configuration object. This type is
 // designed to hold server and other configurations.
 type Config struct {
-	Target  string        `koanf:"target"` // Target service URL
-	Proxy   string        `koanf:"proxy"`  // Outbound Proxy URL
-	Host    string        `koanf:"host"`   // Server listening host
-	Port    int           `koanf:"port"`   // Server listening port
-	Logging LoggingConfig `koanf:"log"`    // Logging configuration
+	Target   string          `koanf:"target"`   // Target service URL
+	Proxy    string          `koanf:"proxy"`    // Outbound Proxy URL
+	Backends []BackendConfig `koanf:"backends"` // Targets that share the traffic, override Target
+	Balance  BalanceConfig   `koanf:"balance"`  // Traffic balancing configuration
+	Host     string          `koanf:"host"`     // Server listening host
+	Port     int             `koanf:"port"`     // Server listening port
+	Listen   []string        `koanf:"listen"`   // Server listening addresses, override Host and Port
+	Socket   SocketConfig    `koanf:"socket"`   // Unix socket listener configuration
+	TLS      TLSConfig       `koanf:"tls"`      // TLS listener configuration
+	Server   ServerConfig    `koanf:"server"`   // Inbound server configuration
+	Upstream UpstreamConfig  `koanf:"upstream"` // Outbound connections configuration
+	Retry    RetryConfig     `koanf:"retry"`    // Upstream retries configuration
+	Breaker  BreakerConfig   `koanf:"breaker"`  // Circuit breaker configuration
+	Error    ErrorConfig     `koanf:"error"`    // Error responses configuration
+	Record   RecordConfig    `koanf:"record"`   // Traffic recording configuration
+	Replay   ReplayConfig    `koanf:"replay"`   // Recorded traffic replay configuration
+	Mirror   MirrorConfig    `koanf:"mirror"`   // Traffic mirroring configuration
+	Cache    CacheConfig     `koanf:"cache"`    // Response caching configuration
+	Coalesce CoalesceConfig  `koanf:"coalesce"` // Request coalescing configuration
+	Body     BodyConfig      `koanf:"body"`     // Request and response bodies configuration
+	Limit    LimitConfig     `koanf:"limit"`    // Concurrency limit configuration
+	Throttle ThrottleConfig  `koanf:"throttle"` // Traffic shaping configuration
+	Upgrade  UpgradeConfig   `koanf:"upgrade"`  // Upgraded connections configuration
+	Faults   []FaultConfig   `koanf:"faults"`   // Faults injected into the requests they match
+	Routes   []RouteConfig   `koanf:"routes"`   // Settings of the requests under specific paths
+	Logging  LoggingConfig   `koanf:"log"`      // Logging configuration
+	Admin    AdminConfig     `koanf:"admin"`    // Admin endpoints configuration
+	Health   HealthConfig    `koanf:"health"`   // Readiness checks configuration
+	Ready    ReadyConfig     `koanf:"ready"`    // Ready announcement configuration
 }
 
 // AppName is the name of the application.
@@ -50,10 +146,113 @@ var Defaults = Config{
 		Format: LogFormatText,
 		Output: LogOutputStdout,
 	},
+	Server: ServerConfig{
+		Timeout: ServerTimeouts{
+			Header: 10 * time.Second,
+			Idle:   2 * time.Minute,
+		},
+		HTTP2: true,
+	},
+	Upstream: UpstreamConfig{
+		Timeout: UpstreamTimeouts{
+			Dial:    30 * time.Second,
+			TLS:     10 * time.Second,
+			Connect: 30 * time.Second,
+			Idle:    90 * time.Second,
+		},
+		Idle: UpstreamIdle{
+			Conns: 100,
+		},
+		Host: UpstreamHost{
+			Idle: UpstreamIdle{
+				Conns: 10,
+			},
+		},
+		Warm: UpstreamWarm{
+			Interval: 30 * time.Second,
+		},
+	},
+	Retry: RetryConfig{
+		Methods:  IdempotentMethods,
+		Buffer:   1 << 20, // 1 MiB
+		Deadline: 10 * time.Second,
+		Wait: RetryWait{
+			Min: 100 * time.Millisecond,
+			Max: 2 * time.Second,
+		},
+	},
+	Breaker: BreakerConfig{
+		Cooldown: 30 * time.Second,
+		Probes:   1,
+	},
+	Error: ErrorConfig{
+		Format: ErrorFormatText,
+	},
+	Record: RecordConfig{
+		Body: RecordBody{
+			Max: 1 << 20, // 1 MiB
+		},
+		Redact: []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"},
+	},
+	Replay: ReplayConfig{
+		Unmatched: ReplayUnmatchedNotFound,
+	},
+	Mirror: MirrorConfig{
+		Sample:  1,
+		Queue:   100,
+		Workers: 2,
+		Buffer:  1 << 20, // 1 MiB
+		Timeout: 30 * time.Second,
+	},
+	Cache: CacheConfig{
+		Store: CacheStoreNone,
+		Size:  64 << 20, // 64 MiB
+		Object: CacheObject{
+			Max: 8 << 20, // 8 MiB
+		},
+	},
+	Coalesce: CoalesceConfig{
+		Headers: []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie", "Range"},
+		Buffer:  1 << 20, // 1 MiB
+	},
+	Body: BodyConfig{
+		Max: 10 << 20, // 10 MiB
+	},
+	Limit: LimitConfig{
+		Queue:   100,
+		Timeout: 10 * time.Second,
+	},
+	Upgrade: UpgradeConfig{
+		Idle:  time.Hour,
+		Grace: 5 * time.Second,
+	},
+	Balance: BalanceConfig{
+		Strategy: BalanceStrategyRoundRobin,
+		Hash:     "ip",
+		Sticky:   BalanceStickyNone,
+		Cookie:   "prxy_backend",
+		Check: BalanceCheck{
+			Timeout: 5 * time.Second,
+			Path:    "/",
+			Status:  http.StatusOK,
+		},
+		Eject: BalanceEject{
+			Duration: 30 * time.Second,
+		},
+	},
+	Admin: AdminConfig{
+		Prefix: "/_prxy",
+	},
+	Health: HealthConfig{
+		Interval: 10 * time.Second,
+		Timeout:  5 * time.Second,
+		Status:   http.StatusOK,
+	},
 }
 
 // New loads the application configuration from various sources:
 //   - Defaults
+//   - Configuration file
 //   - Environment Variables
 //   - Flags
 func New(cmd *cli.Command) (*Config, error) {
@@ -63,6 +262,18 @@ func New(cmd *cli.Command) (*Config, error) {
 	// Load defaults
 	cfg := Defaults
 
+	// Load the configuration file, if any, under the same prefix as the flags,
+	// so that environment variables and flags take precedence over it.
+	if path := cmd.String("config"); path != "" {
+		fk := koanf.New(".")
+		if err := fk.Load(file.Provider(path), yaml.Parser()); err != nil {
+			return nil, fmt.Errorf("failed to load config file: %v", err)
+		}
+		if err := k.MergeAt(fk, AppName); err != nil {
+			return nil, fmt.Errorf("failed to merge config file: %v", err)
+		}
+	}
+
 	// Load environment variables and flags
 	if err := k.Load(cliflagv3.Provider(cmd, "-"), nil); err != nil {
 		return nil, fmt.Errorf("failed to load CLI flags: %v", err)
@@ -83,25 +294,158 @@ func New(cmd *cli.Command) (*Config, error) {
 
 // validateConfig checks the validity of the configuration.
 func validateConfig(cfg *Config) error {
-	// Target URL
-	if err := validation.ValidateURL(cfg.Target); err != nil {
-		return fmt.Errorf("invalid target URL: %v", err)
+	// Target URL or backends
+	switch {
+	case cfg.Target != "" && len(cfg.Backends) > 0:
+		return errors.New("target and backends are mutually exclusive")
+	case len(cfg.Backends) > 0:
+		if err := validateBackends(cfg.Backends); err != nil {
+			return err
+		}
+	case cfg.Target == "":
+		return errors.New("target URL or backends are required")
+	default:
+		if err := validation.ValidateURL(cfg.Target); err != nil {
+			return fmt.Errorf("invalid target URL: %v", err)
+		}
 	}
 
 	// Proxy URL
+	if cfg.Proxy == "" {
+		return errors.New("proxy URL is required")
+	}
 	if err := validation.ValidateURL(cfg.Proxy); err != nil {
 		return fmt.Errorf("invalid proxy URL: %v", err)
 	}
 
+	// Balance
+	if err := cfg.Balance.Validate(); err != nil {
+		return err
+	}
+
 	// Port
 	if cfg.Port < 0 || cfg.Port > 65535 {
 		return fmt.Errorf("invalid port: %d", cfg.Port)
 	}
 
+	// Listen addresses
+	for _, raw := range cfg.Listen {
+		address, err := ParseListenAddress(raw)
+		if err != nil {
+			return err
+		}
+		if address.TLS && cfg.TLS.Cert == "" {
+			return fmt.Errorf("TLS certificate and key are required to listen on %s", raw)
+		}
+	}
+	if cfg.Server.HTTP3 && !slices.ContainsFunc(cfg.ListenAddresses(), func(address ListenAddress) bool { return address.TLS }) {
+		return errors.New("HTTP/3 requires a TLS listener")
+	}
+
+	// Socket
+	if err := cfg.Socket.Validate(); err != nil {
+		return err
+	}
+
+	// TLS
+	if err := cfg.TLS.Validate(); err != nil {
+		return err
+	}
+
+	// Server
+	if err := cfg.Server.Validate(); err != nil {
+		return err
+	}
+
+	// Upstream
+	if err := cfg.Upstream.Validate(); err != nil {
+		return err
+	}
+
+	// Retry
+	if err := cfg.Retry.Validate(); err != nil {
+		return err
+	}
+
+	// Breaker
+	if err := cfg.Breaker.Validate(); err != nil {
+		return err
+	}
+
+	// Error
+	if err := cfg.Error.Validate(); err != nil {
+		return err
+	}
+
+	// Record
+	if err := cfg.Record.Validate(); err != nil {
+		return err
+	}
+
+	// Replay
+	if err := cfg.Replay.Validate(); err != nil {
+		return err
+	}
+
+	// Mirror
+	if err := cfg.Mirror.Validate(); err != nil {
+		return err
+	}
+
+	// Cache
+	if err := cfg.Cache.Validate(); err != nil {
+		return err
+	}
+
+	// Coalesce
+	if err := cfg.Coalesce.Validate(); err != nil {
+		return err
+	}
+
+	// Body
+	if err := cfg.Body.Validate(); err != nil {
+		return err
+	}
+
+	// Limit
+	if err := cfg.Limit.Validate(); err != nil {
+		return err
+	}
+
+	// Throttle
+	if err := cfg.Throttle.Validate(); err != nil {
+		return err
+	}
+
+	// Upgrade
+	if err := cfg.Upgrade.Validate(); err != nil {
+		return err
+	}
+
+	// Faults
+	if err := validateFaults(cfg.Faults); err != nil {
+		return err
+	}
+
+	// Routes
+	if err := validateRoutes(cfg.Routes); err != nil {
+		return err
+	}
+
 	// Logging
 	if err := cfg.Logging.Validate(); err != nil {
 		return err
 	}
 
+	// Admin
+	if err := cfg.Admin.Validate(); err != nil {
+		return err
+	}
+
+	// Health
+	if err := cfg.Health.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
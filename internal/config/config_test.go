@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// loadConfig runs a command with the given arguments and returns the
+// configuration it loads.
+func loadConfig(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+
+	var cfg *Config
+	var err error
+	cmd := &cli.Command{
+		Name: AppName,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "config"},
+			&cli.StringFlag{Name: "target"},
+			&cli.StringFlag{Name: "proxy"},
+			&cli.StringSliceFlag{Name: "backends"},
+			&cli.IntFlag{Name: "port"},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			cfg, err = New(cmd)
+			return nil
+		},
+	}
+	if rerr := cmd.Run(context.Background(), append([]string{AppName}, args...)); rerr != nil {
+		t.Fatalf("Run() failed: %v", rerr)
+	}
+
+	return cfg, err
+}
+
+// TestNew_ConfigFile checks that the configuration file is loaded and that
+// flags take precedence over it.
+func TestNew_ConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prxy.yaml")
+	content := `proxy: http://localhost:3128
+port: 8080
+backends:
+  - url: http://old.example.com
+    weight: 90
+  - url: http://new.example.com
+    weight: 10
+balance:
+  sticky: cookie
+routes:
+  - path: /api
+    coalesce: true
+  - path: /api/stream
+    coalesce: false
+    body:
+      flush: -1
+    faults:
+      - abort: 503
+        probability: 0.1
+faults:
+  - match: method == POST
+    delay: 2s
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Run("loads_file", func(t *testing.T) {
+		cfg, err := loadConfig(t, "--config", path)
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+		if cfg.Proxy != "http://localhost:3128" || cfg.Port != 8080 || cfg.Balance.Sticky != BalanceStickyCookie {
+			t.Errorf("New()\nExpected the settings of the file, but got: %+v", cfg)
+		}
+		if len(cfg.Backends) != 2 || cfg.Backends[0].EffectiveWeight() != 90 || cfg.Backends[1].URL != "http://new.example.com" {
+			t.Errorf("New()\nExpected the backends of the file, but got: %+v", cfg.Backends)
+		}
+		if len(cfg.Routes) != 2 || cfg.Routes[0].Path != "/api" || cfg.Routes[0].Coalesce == nil || !*cfg.Routes[0].Coalesce || cfg.Routes[1].Coalesce == nil || *cfg.Routes[1].Coalesce {
+			t.Errorf("New()\nExpected the routes of the file, but got: %+v", cfg.Routes)
+		}
+		if len(cfg.Routes) == 2 && (len(cfg.Routes[1].Faults) != 1 || cfg.Routes[1].Faults[0].Abort != 503 || cfg.Routes[1].Faults[0].EffectiveProbability() != 0.1) {
+			t.Errorf("New()\nExpected the faults of the route, but got: %+v", cfg.Routes[1].Faults)
+		}
+		if len(cfg.Routes) == 2 && (cfg.Routes[1].Body.Flush == nil || *cfg.Routes[1].Body.Flush != -1 || cfg.Routes[1].Body.Buffer != nil) {
+			t.Errorf("New()\nExpected the body settings of the route, but got: %+v", cfg.Routes[1].Body)
+		}
+		if len(cfg.Faults) != 1 || cfg.Faults[0].Match != "method == POST" || cfg.Faults[0].Delay != 2*time.Second {
+			t.Errorf("New()\nExpected the faults of the file, but got: %+v", cfg.Faults)
+		}
+		if cfg.Balance.Cookie != Defaults.Balance.Cookie {
+			t.Errorf("New()\nExpected default cookie %q, but got: %q", Defaults.Balance.Cookie, cfg.Balance.Cookie)
+		}
+	})
+
+	t.Run("flags_override_file", func(t *testing.T) {
+		cfg, err := loadConfig(t, "--config", path, "--port", "9090", "--backends", "http://old.example.com=50", "--backends", "http://new.example.com=50")
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+		if cfg.Port != 9090 {
+			t.Errorf("New()\nExpected port 9090, but got: %d", cfg.Port)
+		}
+		if len(cfg.Backends) != 2 || cfg.Backends[0].EffectiveWeight() != 50 || cfg.Backends[1].EffectiveWeight() != 50 {
+			t.Errorf("New()\nExpected the backends of the flags, but got: %+v", cfg.Backends)
+		}
+	})
+
+	t.Run("missing_file", func(t *testing.T) {
+		if _, err := loadConfig(t, "--config", filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+			t.Error("New()\nExpected error, but got: nil")
+		}
+	})
+
+	t.Run("target_and_backends", func(t *testing.T) {
+		if _, err := loadConfig(t, "--config", path, "--target", "http://example.com"); err == nil {
+			t.Error("New()\nExpected error, but got: nil")
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Madh93/prxy/internal/validation"
+)
+
+// ErrorFormat defines the format of the error responses.
+type ErrorFormat string
+
+// ErrorConfig represents a configuration for the responses sent to clients
+// when a request cannot be proxied.
+type ErrorConfig struct {
+	Format   ErrorFormat `koanf:"format"`   // Error response format
+	Template string      `koanf:"template"` // Optional HTML template file (if format is html)
+}
+
+// Error response formats.
+const (
+	ErrorFormatText    ErrorFormat = "text"    // Plain text
+	ErrorFormatHTML    ErrorFormat = "html"    // HTML page, optionally from a template
+	ErrorFormatProblem ErrorFormat = "problem" // RFC 7807 application/problem+json
+)
+
+// ValidErrorFormats are the allowed error response formats.
+var ValidErrorFormats = []ErrorFormat{ErrorFormatText, ErrorFormatHTML, ErrorFormatProblem}
+
+// Validate checks if the error configuration is valid.
+func (cfg ErrorConfig) Validate() error {
+	var errs []error
+
+	if err := validation.Validate(cfg.Format, ValidErrorFormats); err != nil {
+		errs = append(errs, fmt.Errorf("invalid error format: %v", err))
+	}
+
+	if cfg.Template != "" && cfg.Format != ErrorFormatHTML {
+		errs = append(errs, errors.New("error template can only be used when format is 'html'"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
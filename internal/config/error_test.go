@@ -0,0 +1,60 @@
+package config
+
+import "testing"
+
+// TestErrorConfigValidate checks the Error Config validation.
+func TestErrorConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string      // Name of the test case
+		config      ErrorConfig // The Error configuration
+		expectError bool        // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Error,
+			expectError: false,
+		},
+		{
+			name:        "valid_problem_format",
+			config:      ErrorConfig{Format: ErrorFormatProblem},
+			expectError: false,
+		},
+		{
+			name:        "valid_html_template",
+			config:      ErrorConfig{Format: ErrorFormatHTML, Template: "error.html"},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "empty_config_struct_should_fail",
+			config:      ErrorConfig{},
+			expectError: true,
+		},
+		{
+			name:        "unknown_format",
+			config:      ErrorConfig{Format: "xml"},
+			expectError: true,
+		},
+		{
+			name:        "template_without_html_format",
+			config:      ErrorConfig{Format: ErrorFormatText, Template: "error.html"},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
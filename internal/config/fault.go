@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FaultConfig represents a rule that injects a fault into the requests it
+// matches, to test how clients behave when the target misbehaves.
+type FaultConfig struct {
+	Match       string        `koanf:"match"`       // Expression the requests must match, empty for all of them
+	Probability *float64      `koanf:"probability"` // Fraction of the matching requests the fault is injected into, 1 if not set
+	Delay       time.Duration `koanf:"delay"`       // Delay before the request is forwarded
+	Jitter      time.Duration `koanf:"jitter"`      // Maximum random variation of the delay, in both directions
+	Abort       int           `koanf:"abort"`       // Status code the request is answered with instead of being forwarded
+	Reset       bool          `koanf:"reset"`       // Whether the client connection is reset instead of forwarding the request
+	Truncate    int64         `koanf:"truncate"`    // Bytes of the response body sent before the client connection is reset
+}
+
+// MatchCondition is a condition of a match expression, such as
+// `header:X-Debug == "1"`.
+type MatchCondition struct {
+	Field string // Request attribute: method, host, path, query or header
+	Name  string // Name of the header
+	Op    string // Comparison: ==, !=, =~ or !~
+	Value string // Compared value, or regular expression for =~ and !~
+}
+
+// Match expression operators.
+const (
+	MatchEqual     = "=="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// EffectiveProbability returns the probability of the fault, 1 if not set.
+func (cfg FaultConfig) EffectiveProbability() float64 {
+	if cfg.Probability == nil {
+		return 1
+	}
+	return *cfg.Probability
+}
+
+// Validate checks if the fault configuration is valid.
+func (cfg FaultConfig) Validate() error {
+	var errs []error
+
+	if _, err := ParseMatch(cfg.Match); err != nil {
+		errs = append(errs, fmt.Errorf("invalid fault match: %v", err))
+	}
+
+	if p := cfg.EffectiveProbability(); p <= 0 || p > 1 {
+		errs = append(errs, fmt.Errorf("invalid fault probability: %v (must be greater than 0 and up to 1)", p))
+	}
+
+	if cfg.Delay < 0 {
+		errs = append(errs, fmt.Errorf("invalid fault delay: %v", cfg.Delay))
+	}
+
+	if cfg.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("invalid fault jitter: %v", cfg.Jitter))
+	}
+
+	// Informational statuses are not final responses, so they can't answer a
+	// request on their own.
+	if cfg.Abort != 0 && (cfg.Abort < 200 || cfg.Abort > 599) {
+		errs = append(errs, fmt.Errorf("invalid fault abort status: %d (must be between 200 and 599)", cfg.Abort))
+	}
+
+	if cfg.Truncate < 0 {
+		errs = append(errs, fmt.Errorf("invalid fault truncate: %d", cfg.Truncate))
+	}
+
+	actions := 0
+	for _, set := range []bool{cfg.Abort != 0, cfg.Reset, cfg.Truncate > 0} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		errs = append(errs, errors.New("fault abort, reset and truncate are mutually exclusive"))
+	}
+	if actions == 0 && cfg.Delay == 0 && cfg.Jitter == 0 {
+		errs = append(errs, errors.New("fault must delay, abort, reset or truncate"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateFaults checks that every fault is valid.
+func validateFaults(faults []FaultConfig) error {
+	var errs []error
+	for i, fault := range faults {
+		if err := fault.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("fault %d: %w", i+1, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// ParseMatch parses a match expression: conditions joined by "&&", each one
+// made of a request attribute, an operator and a value, optionally quoted.
+// Values containing "&&" must be quoted. For example:
+//
+//	method == GET && path =~ "^/api/" && header:X-Debug != ""
+//
+// The attributes are method, host, path, query and header:<name>. The
+// operators are == and != for exact comparisons, and =~ and !~ for regular
+// expressions. An empty expression has no conditions and matches everything.
+func ParseMatch(expr string) ([]MatchCondition, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	terms, err := splitMatch(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []MatchCondition
+	for _, term := range terms {
+		condition, err := parseMatchCondition(strings.TrimSpace(term))
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+// splitMatch splits a match expression into its conditions, at the "&&" found
+// outside of quoted values.
+func splitMatch(expr string) ([]string, error) {
+	var terms []string
+	start, quoted := 0, false
+	for i := 0; i < len(expr); i++ {
+		switch {
+		case quoted && expr[i] == '\\':
+			i++ // Skip the escaped character, which may be a quote
+		case expr[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(expr[i:], "&&"):
+			terms = append(terms, expr[start:i])
+			start = i + 2
+			i++
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted value in %q", expr)
+	}
+
+	return append(terms, expr[start:]), nil
+}
+
+// parseMatchCondition parses a single condition of a match expression.
+func parseMatchCondition(term string) (MatchCondition, error) {
+	field, rest, ok := strings.Cut(term, " ")
+	rest = strings.TrimSpace(rest)
+	if !ok || len(rest) < 2 {
+		return MatchCondition{}, fmt.Errorf("invalid condition %q (expected <attribute> <operator> <value>)", term)
+	}
+
+	condition := MatchCondition{Op: rest[:2], Value: strings.TrimSpace(rest[2:])}
+
+	condition.Field, condition.Name, _ = strings.Cut(field, ":")
+	switch condition.Field {
+	case "method", "host", "path", "query":
+		if condition.Name != "" {
+			return MatchCondition{}, fmt.Errorf("%q does not take a name", condition.Field)
+		}
+	case "header":
+		if condition.Name == "" {
+			return MatchCondition{}, errors.New(`"header" requires a name, as header:<name>`)
+		}
+	default:
+		return MatchCondition{}, fmt.Errorf("invalid attribute %q (valid attributes are method, host, path, query and header:<name>)", field)
+	}
+
+	if strings.HasPrefix(condition.Value, `"`) {
+		value, err := strconv.Unquote(condition.Value)
+		if err != nil {
+			return MatchCondition{}, fmt.Errorf("invalid quoted value %s: %v", condition.Value, err)
+		}
+		condition.Value = value
+	}
+
+	switch condition.Op {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		if _, err := regexp.Compile(condition.Value); err != nil {
+			return MatchCondition{}, fmt.Errorf("invalid regular expression %q: %v", condition.Value, err)
+		}
+	default:
+		return MatchCondition{}, fmt.Errorf("invalid operator %q (valid operators are ==, !=, =~ and !~)", condition.Op)
+	}
+
+	return condition, nil
+}
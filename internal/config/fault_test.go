@@ -0,0 +1,146 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestFaultConfigValidate checks the Fault Config validation.
+func TestFaultConfigValidate(t *testing.T) {
+	half, zero, over := 0.5, 0.0, 1.5
+
+	// Test cases
+	tests := []struct {
+		name        string      // Name of the test case
+		config      FaultConfig // The Fault configuration
+		expectError bool        // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_delay",
+			config:      FaultConfig{Delay: time.Second, Jitter: 500 * time.Millisecond},
+			expectError: false,
+		},
+		{
+			name:        "valid_abort",
+			config:      FaultConfig{Match: "method == POST", Probability: &half, Abort: 503},
+			expectError: false,
+		},
+		{
+			name:        "valid_delayed_reset",
+			config:      FaultConfig{Delay: time.Second, Reset: true},
+			expectError: false,
+		},
+		{
+			name:        "valid_truncate",
+			config:      FaultConfig{Truncate: 1024},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "no_action",
+			config:      FaultConfig{Match: "path == /"},
+			expectError: true,
+		},
+		{
+			name:        "zero_probability",
+			config:      FaultConfig{Probability: &zero, Abort: 500},
+			expectError: true,
+		},
+		{
+			name:        "probability_over_one",
+			config:      FaultConfig{Probability: &over, Abort: 500},
+			expectError: true,
+		},
+		{
+			name:        "invalid_status",
+			config:      FaultConfig{Abort: 999},
+			expectError: true,
+		},
+		{
+			name:        "informational_status",
+			config:      FaultConfig{Abort: 103},
+			expectError: true,
+		},
+		{
+			name:        "abort_and_reset",
+			config:      FaultConfig{Abort: 500, Reset: true},
+			expectError: true,
+		},
+		{
+			name:        "negative_delay",
+			config:      FaultConfig{Delay: -time.Second},
+			expectError: true,
+		},
+		{
+			name:        "invalid_match",
+			config:      FaultConfig{Match: "body == x", Abort: 500},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
+
+// TestParseMatch checks the parsing of match expressions.
+func TestParseMatch(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		expr        string           // Match expression
+		expected    []MatchCondition // Expected conditions
+		expectError bool             // true if an error is expected, false otherwise
+	}{
+		{expr: "", expected: nil},
+		{expr: "method == GET", expected: []MatchCondition{{Field: "method", Op: MatchEqual, Value: "GET"}}},
+		{
+			expr: `path =~ "^/api/" && header:X-Debug != ""`,
+			expected: []MatchCondition{
+				{Field: "path", Op: MatchRegexp, Value: "^/api/"},
+				{Field: "header", Name: "X-Debug", Op: MatchNotEqual, Value: ""},
+			},
+		},
+		{
+			expr: `header:Cookie =~ "a=1&&b=2" && path == "/a \"&&\" b"`,
+			expected: []MatchCondition{
+				{Field: "header", Name: "Cookie", Op: MatchRegexp, Value: "a=1&&b=2"},
+				{Field: "path", Op: MatchEqual, Value: `/a "&&" b`},
+			},
+		},
+		{expr: "query !~ token=", expected: []MatchCondition{{Field: "query", Op: MatchNotRegexp, Value: "token="}}},
+		{expr: "method", expectError: true},
+		{expr: "method = GET", expectError: true},
+		{expr: "body == x", expectError: true},
+		{expr: "header: == x", expectError: true},
+		{expr: "path:x == /", expectError: true},
+		{expr: "path =~ (", expectError: true},
+		{expr: `path == "unterminated`, expectError: true},
+		{expr: `path == "unterminated && method == GET`, expectError: true},
+		{expr: `method == GET &&`, expectError: true},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseMatch(tt.expr)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ParseMatch(%q)\nExpected error: %v, but got: %v", tt.expr, tt.expectError, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseMatch(%q)\nExpected: %+v\nGot: %+v", tt.expr, tt.expected, got)
+			}
+		})
+	}
+}
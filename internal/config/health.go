@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HealthConfig represents a configuration for the readiness checks.
+type HealthConfig struct {
+	Interval time.Duration `koanf:"interval"` // How long a readiness result is cached
+	Timeout  time.Duration `koanf:"timeout"`  // Maximum duration of a single readiness check
+	Path     string        `koanf:"path"`     // Optional path on the target to probe
+	Status   int           `koanf:"status"`   // Expected status code of the target probe
+}
+
+// Validate checks if the health configuration is valid.
+func (cfg HealthConfig) Validate() error {
+	var errs []error
+
+	if cfg.Interval < 0 {
+		errs = append(errs, fmt.Errorf("invalid health interval: %v", cfg.Interval))
+	}
+
+	if cfg.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid health timeout: %v", cfg.Timeout))
+	}
+
+	if cfg.Path != "" && !strings.HasPrefix(cfg.Path, "/") {
+		errs = append(errs, errors.New("invalid health path: must start with '/'"))
+	}
+
+	if http.StatusText(cfg.Status) == "" {
+		errs = append(errs, fmt.Errorf("invalid health status: %d", cfg.Status))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
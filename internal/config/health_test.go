@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHealthConfigValidate checks the Health Config validation.
+func TestHealthConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string       // Name of the test case
+		config      HealthConfig // The Health configuration
+		expectError bool         // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Health,
+			expectError: false,
+		},
+		{
+			name:        "valid_probe_path_without_cache",
+			config:      HealthConfig{Interval: 0, Timeout: time.Second, Path: "/status?full=1", Status: 204},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "empty_config_struct_should_fail",
+			config:      HealthConfig{},
+			expectError: true,
+		},
+		{
+			name:        "negative_interval",
+			config:      HealthConfig{Interval: -time.Second, Timeout: time.Second, Status: 200},
+			expectError: true,
+		},
+		{
+			name:        "zero_timeout",
+			config:      HealthConfig{Interval: time.Second, Status: 200},
+			expectError: true,
+		},
+		{
+			name:        "relative_probe_path",
+			config:      HealthConfig{Interval: time.Second, Timeout: time.Second, Path: "status", Status: 200},
+			expectError: true,
+		},
+		{
+			name:        "unknown_status_code",
+			config:      HealthConfig{Interval: time.Second, Timeout: time.Second, Status: 999},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
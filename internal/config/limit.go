@@ -0,0 +1,38 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LimitConfig represents a configuration for capping the requests in flight
+// to the upstream target, queueing the ones over the cap.
+type LimitConfig struct {
+	Concurrency int           `koanf:"concurrency"` // Maximum requests in flight to the target, 0 disables it
+	Queue       int           `koanf:"queue"`       // Maximum requests waiting for their turn, rejected beyond it
+	Timeout     time.Duration `koanf:"timeout"`     // Maximum time a request waits for its turn, 0 waits indefinitely
+}
+
+// Validate checks if the limit configuration is valid.
+func (cfg LimitConfig) Validate() error {
+	var errs []error
+
+	if cfg.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("invalid limit concurrency: %d", cfg.Concurrency))
+	}
+
+	if cfg.Queue < 0 {
+		errs = append(errs, fmt.Errorf("invalid limit queue: %d", cfg.Queue))
+	}
+
+	if cfg.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid limit timeout: %v", cfg.Timeout))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLimitConfigValidate checks the Limit Config validation.
+func TestLimitConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string      // Name of the test case
+		config      LimitConfig // The Limit configuration
+		expectError bool        // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Limit,
+			expectError: false,
+		},
+		{
+			name:        "valid_without_queue",
+			config:      LimitConfig{Concurrency: 4},
+			expectError: false,
+		},
+		{
+			name:        "valid_queue",
+			config:      LimitConfig{Concurrency: 4, Queue: 100, Timeout: 10 * time.Second},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_concurrency",
+			config:      LimitConfig{Concurrency: -1},
+			expectError: true,
+		},
+		{
+			name:        "negative_queue",
+			config:      LimitConfig{Concurrency: 4, Queue: -1},
+			expectError: true,
+		},
+		{
+			name:        "negative_timeout",
+			config:      LimitConfig{Concurrency: 4, Queue: 100, Timeout: -time.Second},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
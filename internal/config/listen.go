@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Listen networks.
+const (
+	ListenNetworkTCP  = "tcp"
+	ListenNetworkUnix = "unix"
+)
+
+// ListenSchemeTLS is the scheme of TCP addresses that serve TLS.
+const ListenSchemeTLS = "tls"
+
+// ListenAddress represents an address to listen on.
+type ListenAddress struct {
+	Network string // Listen network, either tcp or unix
+	Address string // host:port for tcp, socket path for unix
+	TLS     bool   // Serve TLS on the address
+}
+
+// ParseListenAddress parses an address in one of the following forms:
+//   - host:port
+//   - tcp://host:port
+//   - tls://host:port
+//   - unix:///path/to/socket
+func ParseListenAddress(raw string) (ListenAddress, error) {
+	scheme, rest, found := strings.Cut(raw, "://")
+	if !found {
+		scheme, rest = ListenNetworkTCP, raw
+	}
+
+	switch scheme {
+	case ListenNetworkTCP, ListenSchemeTLS:
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return ListenAddress{}, fmt.Errorf("invalid TCP address %q: %v", raw, err)
+		}
+		return ListenAddress{Network: ListenNetworkTCP, Address: rest, TLS: scheme == ListenSchemeTLS}, nil
+	case ListenNetworkUnix:
+		if !strings.HasPrefix(rest, "/") {
+			return ListenAddress{}, fmt.Errorf("invalid unix socket address %q: path must be absolute", raw)
+		}
+		return ListenAddress{Network: ListenNetworkUnix, Address: rest}, nil
+	default:
+		return ListenAddress{}, fmt.Errorf("invalid listen address %q: scheme must be one of: %v", raw, []string{ListenNetworkTCP, ListenSchemeTLS, ListenNetworkUnix})
+	}
+}
+
+// String returns the address in the form accepted by ParseListenAddress.
+func (addr ListenAddress) String() string {
+	switch {
+	case addr.Network == ListenNetworkUnix:
+		return ListenNetworkUnix + "://" + addr.Address
+	case addr.TLS:
+		return ListenSchemeTLS + "://" + addr.Address
+	default:
+		return addr.Address
+	}
+}
+
+// ListenAddresses returns the addresses to listen on: the listen addresses if
+// any, or the host and port otherwise. It assumes the configuration is valid.
+func (cfg Config) ListenAddresses() []ListenAddress {
+	if len(cfg.Listen) == 0 {
+		return []ListenAddress{{Network: ListenNetworkTCP, Address: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))}}
+	}
+
+	addresses := make([]ListenAddress, 0, len(cfg.Listen))
+	for _, raw := range cfg.Listen {
+		address, _ := ParseListenAddress(raw)
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// SocketConfig represents a configuration for unix socket listeners.
+type SocketConfig struct {
+	Mode  string `koanf:"mode"`  // Octal file mode of the socket, e.g. 0660
+	Owner string `koanf:"owner"` // Owner of the socket, as user[:group]
+}
+
+// Validate checks if the socket configuration is valid.
+func (cfg SocketConfig) Validate() error {
+	var errs []error
+
+	if cfg.Mode != "" {
+		if mode, err := strconv.ParseUint(cfg.Mode, 8, 32); err != nil || mode > 0o777 {
+			errs = append(errs, fmt.Errorf("invalid socket mode %q: must be an octal permission like 0660", cfg.Mode))
+		}
+	}
+
+	if cfg.Owner != "" {
+		user, group, _ := strings.Cut(cfg.Owner, ":")
+		if user == "" && group == "" || strings.Contains(group, ":") {
+			errs = append(errs, fmt.Errorf("invalid socket owner %q: must be user[:group]", cfg.Owner))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// FileMode returns the parsed socket mode, or 0 if no mode is configured.
+// It assumes the configuration is valid.
+func (cfg SocketConfig) FileMode() fs.FileMode {
+	mode, _ := strconv.ParseUint(cfg.Mode, 8, 32)
+	return fs.FileMode(mode)
+}
+
+// TLSConfig represents a configuration for TLS listeners.
+type TLSConfig struct {
+	Cert string `koanf:"cert"` // Path to the PEM encoded certificate
+	Key  string `koanf:"key"`  // Path to the PEM encoded private key
+}
+
+// Validate checks if the TLS configuration is valid.
+func (cfg TLSConfig) Validate() error {
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return errors.New("TLS certificate and key must be specified together")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"testing"
+)
+
+// TestParseListenAddress checks the parsing of listen addresses.
+func TestParseListenAddress(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string        // Name of the test case
+		raw         string        // The raw listen address
+		expected    ListenAddress // The expected parsed address
+		expectError bool          // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:     "bare_host_port",
+			raw:      "127.0.0.1:12345",
+			expected: ListenAddress{Network: ListenNetworkTCP, Address: "127.0.0.1:12345"},
+		},
+		{
+			name:     "tcp_ipv6_host_port",
+			raw:      "tcp://[::1]:12345",
+			expected: ListenAddress{Network: ListenNetworkTCP, Address: "[::1]:12345"},
+		},
+		{
+			name:     "tls_host_port",
+			raw:      "tls://0.0.0.0:8443",
+			expected: ListenAddress{Network: ListenNetworkTCP, Address: "0.0.0.0:8443", TLS: true},
+		},
+		{
+			name:     "unix_socket",
+			raw:      "unix:///run/prxy.sock",
+			expected: ListenAddress{Network: ListenNetworkUnix, Address: "/run/prxy.sock"},
+		},
+		// Invalid test cases
+		{
+			name:        "missing_port",
+			raw:         "localhost",
+			expectError: true,
+		},
+		{
+			name:        "relative_unix_socket",
+			raw:         "unix://prxy.sock",
+			expectError: true,
+		},
+		{
+			name:        "unsupported_scheme",
+			raw:         "udp://127.0.0.1:53",
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseListenAddress(tt.raw)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ParseListenAddress(%q)\nExpected error: %v, but got: %v", tt.raw, tt.expectError, err)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseListenAddress(%q)\nExpected %+v, but got: %+v", tt.raw, tt.expected, got)
+			}
+			if !tt.expectError && got.String() != tt.raw && "tcp://"+got.String() != tt.raw {
+				t.Errorf("ListenAddress.String()\nExpected %q, but got: %q", tt.raw, got.String())
+			}
+		})
+	}
+}
+
+// TestSocketConfigValidate checks the Socket Config validation.
+func TestSocketConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string       // Name of the test case
+		config      SocketConfig // The Socket configuration
+		expectError bool         // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "empty_config_keeps_defaults",
+			config:      SocketConfig{},
+			expectError: false,
+		},
+		{
+			name:        "valid_mode_and_owner",
+			config:      SocketConfig{Mode: "0660", Owner: "prxy:www-data"},
+			expectError: false,
+		},
+		{
+			name:        "valid_numeric_group_only",
+			config:      SocketConfig{Mode: "600", Owner: ":33"},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "non_octal_mode",
+			config:      SocketConfig{Mode: "0990"},
+			expectError: true,
+		},
+		{
+			name:        "mode_out_of_range",
+			config:      SocketConfig{Mode: "1777"},
+			expectError: true,
+		},
+		{
+			name:        "owner_without_user_and_group",
+			config:      SocketConfig{Owner: ":"},
+			expectError: true,
+		},
+		{
+			name:        "owner_with_too_many_parts",
+			config:      SocketConfig{Owner: "a:b:c"},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
+
+// TestConfigListenAddresses checks the addresses to listen on.
+func TestConfigListenAddresses(t *testing.T) {
+	t.Run("should_default_to_host_and_port", func(t *testing.T) {
+		cfg := Config{Host: "localhost", Port: 12345}
+		got := cfg.ListenAddresses()
+		if len(got) != 1 || got[0] != (ListenAddress{Network: ListenNetworkTCP, Address: "localhost:12345"}) {
+			t.Errorf("ListenAddresses()\nExpected only localhost:12345, but got: %+v", got)
+		}
+	})
+
+	t.Run("should_override_host_and_port", func(t *testing.T) {
+		cfg := Config{Host: "localhost", Port: 12345, Listen: []string{"127.0.0.1:12345", "[::1]:12345", "unix:///run/prxy.sock"}}
+		got := cfg.ListenAddresses()
+		if len(got) != len(cfg.Listen) {
+			t.Fatalf("ListenAddresses()\nExpected %d addresses, but got: %+v", len(cfg.Listen), got)
+		}
+		for i, address := range got {
+			if address.String() != cfg.Listen[i] {
+				t.Errorf("ListenAddresses()[%d]\nExpected %q, but got: %q", i, cfg.Listen[i], address)
+			}
+		}
+	})
+}
+
+// TestTLSConfigValidate checks the TLS Config validation.
+func TestTLSConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string    // Name of the test case
+		config      TLSConfig // The TLS configuration
+		expectError bool      // true if an error is expected, false otherwise
+	}{
+		{
+			name:        "empty_config",
+			config:      TLSConfig{},
+			expectError: false,
+		},
+		{
+			name:        "certificate_and_key",
+			config:      TLSConfig{Cert: "/etc/prxy/cert.pem", Key: "/etc/prxy/key.pem"},
+			expectError: false,
+		},
+		{
+			name:        "certificate_without_key",
+			config:      TLSConfig{Cert: "/etc/prxy/cert.pem"},
+			expectError: true,
+		},
+		{
+			name:        "key_without_certificate",
+			config:      TLSConfig{Key: "/etc/prxy/key.pem"},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
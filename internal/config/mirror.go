@@ -0,0 +1,66 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Madh93/prxy/internal/validation"
+)
+
+// MirrorConfig represents a configuration for mirroring the proxied traffic
+// to a secondary target.
+type MirrorConfig struct {
+	Target  string        `koanf:"target"`  // Secondary target URL, empty disables mirroring
+	Proxy   string        `koanf:"proxy"`   // Outbound proxy URL of the secondary target, defaults to the main one
+	Sample  float64       `koanf:"sample"`  // Fraction of the requests that are mirrored, from 0 to 1
+	Queue   int           `koanf:"queue"`   // Maximum number of requests waiting to be mirrored
+	Workers int           `koanf:"workers"` // Number of requests mirrored concurrently
+	Buffer  int64         `koanf:"buffer"`  // Maximum request body size buffered to be mirrored
+	Timeout time.Duration `koanf:"timeout"` // Maximum duration of a mirrored request
+}
+
+// Validate checks if the mirror configuration is valid.
+func (cfg MirrorConfig) Validate() error {
+	if cfg.Target == "" {
+		return nil
+	}
+
+	var errs []error
+
+	if err := validation.ValidateURL(cfg.Target); err != nil {
+		errs = append(errs, fmt.Errorf("invalid mirror target URL: %v", err))
+	}
+
+	if cfg.Proxy != "" {
+		if err := validation.ValidateURL(cfg.Proxy); err != nil {
+			errs = append(errs, fmt.Errorf("invalid mirror proxy URL: %v", err))
+		}
+	}
+
+	if cfg.Sample < 0 || cfg.Sample > 1 {
+		errs = append(errs, fmt.Errorf("invalid mirror sample: %v, must be between 0 and 1", cfg.Sample))
+	}
+
+	if cfg.Queue <= 0 {
+		errs = append(errs, fmt.Errorf("invalid mirror queue: %d", cfg.Queue))
+	}
+
+	if cfg.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("invalid mirror workers: %d", cfg.Workers))
+	}
+
+	if cfg.Buffer < 0 {
+		errs = append(errs, fmt.Errorf("invalid mirror buffer: %d", cfg.Buffer))
+	}
+
+	if cfg.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid mirror timeout: %v", cfg.Timeout))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
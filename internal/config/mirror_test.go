@@ -0,0 +1,81 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMirrorConfigValidate checks the Mirror Config validation.
+func TestMirrorConfigValidate(t *testing.T) {
+	valid := Defaults.Mirror
+	valid.Target = "http://new.example.com"
+
+	// Test cases
+	tests := []struct {
+		name        string       // Name of the test case
+		config      MirrorConfig // The Mirror configuration
+		expectError bool         // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Mirror,
+			expectError: false,
+		},
+		{
+			name:        "valid_mirror",
+			config:      valid,
+			expectError: false,
+		},
+		{
+			name:        "valid_mirror_with_own_proxy",
+			config:      MirrorConfig{Target: "https://new.example.com", Proxy: "http://localhost:3128", Sample: 0.1, Queue: 1, Workers: 1, Timeout: time.Second},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "invalid_target",
+			config:      MirrorConfig{Target: "ftp://new.example.com", Sample: 1, Queue: 1, Workers: 1, Timeout: time.Second},
+			expectError: true,
+		},
+		{
+			name:        "invalid_proxy",
+			config:      MirrorConfig{Target: "http://new.example.com", Proxy: "localhost", Sample: 1, Queue: 1, Workers: 1, Timeout: time.Second},
+			expectError: true,
+		},
+		{
+			name:        "sample_above_one",
+			config:      MirrorConfig{Target: "http://new.example.com", Sample: 1.5, Queue: 1, Workers: 1, Timeout: time.Second},
+			expectError: true,
+		},
+		{
+			name:        "zero_queue",
+			config:      MirrorConfig{Target: "http://new.example.com", Sample: 1, Workers: 1, Timeout: time.Second},
+			expectError: true,
+		},
+		{
+			name:        "zero_workers",
+			config:      MirrorConfig{Target: "http://new.example.com", Sample: 1, Queue: 1, Timeout: time.Second},
+			expectError: true,
+		},
+		{
+			name:        "zero_timeout",
+			config:      MirrorConfig{Target: "http://new.example.com", Sample: 1, Queue: 1, Workers: 1},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package config
+
+// ReadyConfig represents a configuration for announcing that the server is
+// listening, which is useful for scripts that spawn prxy with a random port.
+type ReadyConfig struct {
+	File string `koanf:"file"` // File path to write the bound address to
+	JSON bool   `koanf:"json"` // Print a JSON ready line on stdout
+}
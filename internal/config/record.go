@@ -0,0 +1,40 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RecordConfig represents a configuration for recording the proxied traffic
+// to a HAR file.
+type RecordConfig struct {
+	File   string     `koanf:"file"`   // HAR file to record to, empty disables recording
+	Body   RecordBody `koanf:"body"`   // Recorded bodies settings
+	Redact []string   `koanf:"redact"` // Headers whose values are redacted
+}
+
+// RecordBody holds the settings of the recorded bodies.
+type RecordBody struct {
+	Max int64 `koanf:"max"` // Maximum recorded size of each body, 0 records no bodies
+}
+
+// Validate checks if the record configuration is valid.
+func (cfg RecordConfig) Validate() error {
+	var errs []error
+
+	if cfg.Body.Max < 0 {
+		errs = append(errs, fmt.Errorf("invalid record body max: %d", cfg.Body.Max))
+	}
+
+	for _, header := range cfg.Redact {
+		if header == "" {
+			errs = append(errs, errors.New("invalid record redact header: must not be empty"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import "testing"
+
+// TestRecordConfigValidate checks the Record Config validation.
+func TestRecordConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string       // Name of the test case
+		config      RecordConfig // The Record configuration
+		expectError bool         // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Record,
+			expectError: false,
+		},
+		{
+			name:        "valid_without_bodies",
+			config:      RecordConfig{File: "traffic.har"},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_body_max",
+			config:      RecordConfig{File: "traffic.har", Body: RecordBody{Max: -1}},
+			expectError: true,
+		},
+		{
+			name:        "empty_redact_header",
+			config:      RecordConfig{File: "traffic.har", Redact: []string{""}},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
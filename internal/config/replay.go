@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Madh93/prxy/internal/validation"
+)
+
+// ReplayUnmatched defines what happens to the requests without a recorded
+// response.
+type ReplayUnmatched string
+
+// ReplayConfig represents a configuration for serving recorded responses
+// instead of contacting the target.
+type ReplayConfig struct {
+	Path      string          `koanf:"path"`      // HAR file or directory of HAR files to replay, empty disables replay
+	Match     ReplayMatch     `koanf:"match"`     // Request matching settings
+	Unmatched ReplayUnmatched `koanf:"unmatched"` // Strategy for unmatched requests
+}
+
+// ReplayMatch holds the request matching settings. Requests are always
+// matched by method, path and query.
+type ReplayMatch struct {
+	Body bool `koanf:"body"` // Whether the request body must match too
+}
+
+// Strategies for unmatched requests.
+const (
+	ReplayUnmatchedNotFound    ReplayUnmatched = "404"         // Answer 404 Not Found
+	ReplayUnmatchedPassthrough ReplayUnmatched = "passthrough" // Forward the request to the target
+	ReplayUnmatchedError       ReplayUnmatched = "error"       // Answer an error response
+)
+
+// ValidReplayUnmatched are the allowed strategies for unmatched requests.
+var ValidReplayUnmatched = []ReplayUnmatched{ReplayUnmatchedNotFound, ReplayUnmatchedPassthrough, ReplayUnmatchedError}
+
+// Validate checks if the replay configuration is valid.
+func (cfg ReplayConfig) Validate() error {
+	var errs []error
+
+	if err := validation.Validate(cfg.Unmatched, ValidReplayUnmatched); err != nil {
+		errs = append(errs, fmt.Errorf("invalid replay unmatched strategy: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import "testing"
+
+// TestReplayConfigValidate checks the Replay Config validation.
+func TestReplayConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string       // Name of the test case
+		config      ReplayConfig // The Replay configuration
+		expectError bool         // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Replay,
+			expectError: false,
+		},
+		{
+			name:        "valid_passthrough",
+			config:      ReplayConfig{Path: "traffic.har", Match: ReplayMatch{Body: true}, Unmatched: ReplayUnmatchedPassthrough},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "empty_config_struct_should_fail",
+			config:      ReplayConfig{},
+			expectError: true,
+		},
+		{
+			name:        "unknown_unmatched_strategy",
+			config:      ReplayConfig{Path: "traffic.har", Unmatched: "500"},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RetryConfig represents a configuration for retrying failed upstream round
+// trips.
+type RetryConfig struct {
+	Attempts int           `koanf:"attempts"` // Maximum number of retries, 0 disables them
+	Statuses []int         `koanf:"statuses"` // Response status codes that are retried, besides connection errors
+	Methods  []string      `koanf:"methods"`  // Request methods that are retried
+	Buffer   int64         `koanf:"buffer"`   // Maximum request body size buffered to be replayed on retries
+	Deadline time.Duration `koanf:"deadline"` // Total time after which no more retries are attempted, positive if retries are enabled
+	Wait     RetryWait     `koanf:"wait"`     // Backoff between retries
+}
+
+// RetryWait holds the bounds of the exponential backoff between retries.
+type RetryWait struct {
+	Min time.Duration `koanf:"min"` // Wait before the first retry
+	Max time.Duration `koanf:"max"` // Maximum wait between retries
+}
+
+// IdempotentMethods are the request methods that are safe to retry, as
+// defined by RFC 9110.
+var IdempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+
+// Validate checks if the retry configuration is valid.
+func (cfg RetryConfig) Validate() error {
+	var errs []error
+
+	if cfg.Attempts < 0 {
+		errs = append(errs, fmt.Errorf("invalid retry attempts: %d", cfg.Attempts))
+	}
+
+	for _, status := range cfg.Statuses {
+		if status < 100 || status > 599 {
+			errs = append(errs, fmt.Errorf("invalid retry status: %d", status))
+		}
+	}
+
+	for _, method := range cfg.Methods {
+		if method == "" || method != strings.ToUpper(method) {
+			errs = append(errs, fmt.Errorf("invalid retry method %q: must be an uppercase HTTP method", method))
+		}
+	}
+
+	if cfg.Buffer < 0 {
+		errs = append(errs, fmt.Errorf("invalid retry buffer: %d", cfg.Buffer))
+	}
+
+	// No retry would ever start with a zero deadline.
+	if cfg.Deadline < 0 || cfg.Attempts > 0 && cfg.Deadline == 0 {
+		errs = append(errs, fmt.Errorf("invalid retry deadline: %v (must be positive when retries are enabled)", cfg.Deadline))
+	}
+
+	if cfg.Wait.Min <= 0 || cfg.Wait.Max < cfg.Wait.Min {
+		errs = append(errs, fmt.Errorf("invalid retry wait: min (%v) must be positive and not greater than max (%v)", cfg.Wait.Min, cfg.Wait.Max))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Retries reports whether requests with the given method may be retried.
+func (cfg RetryConfig) Retries(method string) bool {
+	return cfg.Attempts > 0 && slices.Contains(cfg.Methods, method)
+}
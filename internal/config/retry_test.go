@@ -0,0 +1,105 @@
+package config
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestRetryConfigValidate checks the Retry Config validation.
+func TestRetryConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string      // Name of the test case
+		config      RetryConfig // The Retry configuration
+		expectError bool        // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Retry,
+			expectError: false,
+		},
+		{
+			name:        "valid_retries",
+			config:      RetryConfig{Attempts: 3, Statuses: []int{502, 503}, Methods: []string{"GET", "POST"}, Deadline: time.Second, Wait: RetryWait{Min: time.Millisecond, Max: time.Second}},
+			expectError: false,
+		},
+		{
+			name:        "zero_deadline_without_retries",
+			config:      RetryConfig{Attempts: 0, Wait: RetryWait{Min: time.Millisecond, Max: time.Second}},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_attempts",
+			config:      RetryConfig{Attempts: -1},
+			expectError: true,
+		},
+		{
+			name:        "invalid_status",
+			config:      RetryConfig{Attempts: 1, Statuses: []int{99}},
+			expectError: true,
+		},
+		{
+			name:        "lowercase_method",
+			config:      RetryConfig{Attempts: 1, Methods: []string{"get"}},
+			expectError: true,
+		},
+		{
+			name:        "negative_buffer",
+			config:      RetryConfig{Attempts: 1, Buffer: -1},
+			expectError: true,
+		},
+		{
+			name:        "negative_deadline",
+			config:      RetryConfig{Attempts: 1, Deadline: -time.Second},
+			expectError: true,
+		},
+		{
+			name:        "zero_deadline",
+			config:      RetryConfig{Attempts: 1, Wait: RetryWait{Min: time.Millisecond, Max: time.Second}},
+			expectError: true,
+		},
+		{
+			name:        "zero_min_wait",
+			config:      RetryConfig{Attempts: 1, Wait: RetryWait{Min: 0, Max: time.Second}},
+			expectError: true,
+		},
+		{
+			name:        "max_wait_below_min_wait",
+			config:      RetryConfig{Attempts: 1, Wait: RetryWait{Min: time.Second, Max: time.Millisecond}},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
+
+// TestRetryConfigRetries checks which methods are retried.
+func TestRetryConfigRetries(t *testing.T) {
+	cfg := Defaults.Retry
+	if cfg.Retries(http.MethodGet) {
+		t.Error("Retries(GET)\nExpected false when attempts is 0, but got true")
+	}
+
+	cfg.Attempts = 2
+	if !cfg.Retries(http.MethodGet) {
+		t.Error("Retries(GET)\nExpected true, but got false")
+	}
+	if cfg.Retries(http.MethodPost) {
+		t.Error("Retries(POST)\nExpected false, but got true")
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RouteConfig represents settings that only apply to the requests under a
+// path. Unset settings fall back to the global ones.
+type RouteConfig struct {
+	Path     string        `koanf:"path"`     // Path prefix of the requests of the route
+	Coalesce *bool         `koanf:"coalesce"` // Whether identical concurrent requests are coalesced
+	Body     RouteBody     `koanf:"body"`     // How the bodies of the route are passed through
+	Faults   []FaultConfig `koanf:"faults"`   // Faults injected into the requests of the route
+}
+
+// Match reports whether the request path belongs to the route. The prefix
+// matches whole path segments, so that "/api" matches "/api" and "/api/v1",
+// but not "/apiary".
+func (cfg RouteConfig) Match(path string) bool {
+	prefix := strings.TrimSuffix(cfg.Path, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Validate checks if the route configuration is valid.
+func (cfg RouteConfig) Validate() error {
+	var errs []error
+
+	if !strings.HasPrefix(cfg.Path, "/") {
+		errs = append(errs, fmt.Errorf("invalid route path %q: must start with '/'", cfg.Path))
+	}
+
+	if cfg.Body.Max != nil && *cfg.Body.Max <= 0 {
+		errs = append(errs, fmt.Errorf("invalid body max of route %s: %d", cfg.Path, *cfg.Body.Max))
+	}
+
+	if err := validateFaults(cfg.Faults); err != nil {
+		errs = append(errs, fmt.Errorf("route %s: %w", cfg.Path, err))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateRoutes checks that every route is valid and that no path is
+// repeated.
+func validateRoutes(routes []RouteConfig) error {
+	var errs []error
+
+	seen := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if err := route.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if seen[route.Path] {
+			errs = append(errs, fmt.Errorf("duplicated route path: %s", route.Path))
+		}
+		seen[route.Path] = true
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import "testing"
+
+// TestValidateRoutes checks the Route Config validation.
+func TestValidateRoutes(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string        // Name of the test case
+		config      []RouteConfig // The Route configurations
+		expectError bool          // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_none",
+			config:      nil,
+			expectError: false,
+		},
+		{
+			name:        "valid_nested",
+			config:      []RouteConfig{{Path: "/api"}, {Path: "/api/stream/"}, {Path: "/"}},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "relative_path",
+			config:      []RouteConfig{{Path: "api"}},
+			expectError: true,
+		},
+		{
+			name:        "empty_path",
+			config:      []RouteConfig{{}},
+			expectError: true,
+		},
+		{
+			name:        "invalid_fault",
+			config:      []RouteConfig{{Path: "/api", Faults: []FaultConfig{{Abort: 1000}}}},
+			expectError: true,
+		},
+		{
+			name:        "invalid_body_max",
+			config:      []RouteConfig{{Path: "/upload", Body: RouteBody{Max: new(int64)}}},
+			expectError: true,
+		},
+		{
+			name:        "duplicated_path",
+			config:      []RouteConfig{{Path: "/api"}, {Path: "/api"}},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateRoutes(tt.config)
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
+
+// TestRouteConfigMatch checks that routes match whole path segments.
+func TestRouteConfigMatch(t *testing.T) {
+	tests := []struct {
+		route string
+		path  string
+		want  bool
+	}{
+		{route: "/api", path: "/api", want: true},
+		{route: "/api", path: "/api/v1/users", want: true},
+		{route: "/api/", path: "/api/v1", want: true},
+		{route: "/api", path: "/apiary", want: false},
+		{route: "/api", path: "/", want: false},
+		{route: "/", path: "/anything", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := (RouteConfig{Path: tt.route}).Match(tt.path); got != tt.want {
+			t.Errorf("RouteConfig{Path: %q}.Match(%q)\nExpected: %v\nGot: %v", tt.route, tt.path, tt.want, got)
+		}
+	}
+}
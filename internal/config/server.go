@@ -0,0 +1,50 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ServerConfig represents a configuration for the inbound HTTP server.
+type ServerConfig struct {
+	Timeout ServerTimeouts `koanf:"timeout"` // Inbound connection timeouts
+	HTTP2   bool           `koanf:"http2"`   // Whether HTTP/2 is served on TLS listeners
+	H2C     bool           `koanf:"h2c"`     // Whether cleartext HTTP/2 is served on the other listeners
+	HTTP3   bool           `koanf:"http3"`   // Whether HTTP/3 is served on UDP alongside TLS listeners
+}
+
+// ServerTimeouts holds the inbound connection timeouts. A zero value means no
+// timeout.
+type ServerTimeouts struct {
+	Header time.Duration `koanf:"header"` // Maximum duration to read the request headers
+	Read   time.Duration `koanf:"read"`   // Maximum duration to read the entire request
+	Write  time.Duration `koanf:"write"`  // Maximum duration to write the response
+	Idle   time.Duration `koanf:"idle"`   // Maximum duration to wait for the next request on keep-alive connections
+}
+
+// Validate checks if the server configuration is valid.
+func (cfg ServerConfig) Validate() error {
+	var errs []error
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"header", cfg.Timeout.Header},
+		{"read", cfg.Timeout.Read},
+		{"write", cfg.Timeout.Write},
+		{"idle", cfg.Timeout.Idle},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("invalid server %s timeout: %v", timeout.name, timeout.value))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestServerConfigValidate checks the Server Config validation.
+func TestServerConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string       // Name of the test case
+		config      ServerConfig // The Server configuration
+		expectError bool         // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Server,
+			expectError: false,
+		},
+		{
+			name:        "valid_disabled_timeouts",
+			config:      ServerConfig{},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_header_timeout",
+			config:      ServerConfig{Timeout: ServerTimeouts{Header: -time.Second}},
+			expectError: true,
+		},
+		{
+			name:        "negative_write_timeout",
+			config:      ServerConfig{Timeout: ServerTimeouts{Write: -time.Second}},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ThrottleConfig represents a configuration for shaping the traffic between
+// the clients and the target, to simulate slow links.
+type ThrottleConfig struct {
+	Download   int64              `koanf:"download"`   // Maximum response rate of all the clients, in bytes per second, 0 disables it
+	Upload     int64              `koanf:"upload"`     // Maximum request rate of all the clients, in bytes per second, 0 disables it
+	Connection ThrottleConnection `koanf:"connection"` // Rate limits of every client connection
+	Latency    time.Duration      `koanf:"latency"`    // Delay added before forwarding every request
+	Jitter     time.Duration      `koanf:"jitter"`     // Maximum random variation of the latency, in both directions
+}
+
+// ThrottleConnection represents the rate limits of every client connection.
+type ThrottleConnection struct {
+	Download int64 `koanf:"download"` // Maximum response rate, in bytes per second, 0 disables it
+	Upload   int64 `koanf:"upload"`   // Maximum request rate, in bytes per second, 0 disables it
+}
+
+// Enabled reports whether any traffic shaping is configured.
+func (cfg ThrottleConfig) Enabled() bool {
+	return cfg.Download > 0 || cfg.Upload > 0 || cfg.Connection.Download > 0 || cfg.Connection.Upload > 0 || cfg.Latency > 0 || cfg.Jitter > 0
+}
+
+// Validate checks if the throttle configuration is valid.
+func (cfg ThrottleConfig) Validate() error {
+	var errs []error
+
+	rates := []struct {
+		name string
+		rate int64
+	}{
+		{"download", cfg.Download},
+		{"upload", cfg.Upload},
+		{"connection download", cfg.Connection.Download},
+		{"connection upload", cfg.Connection.Upload},
+	}
+	for _, r := range rates {
+		if r.rate < 0 {
+			errs = append(errs, fmt.Errorf("invalid throttle %s rate: %d", r.name, r.rate))
+		}
+	}
+
+	if cfg.Latency < 0 {
+		errs = append(errs, fmt.Errorf("invalid throttle latency: %v", cfg.Latency))
+	}
+
+	if cfg.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("invalid throttle jitter: %v", cfg.Jitter))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
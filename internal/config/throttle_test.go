@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestThrottleConfigValidate checks the Throttle Config validation.
+func TestThrottleConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string         // Name of the test case
+		config      ThrottleConfig // The Throttle configuration
+		expectError bool           // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Throttle,
+			expectError: false,
+		},
+		{
+			name:        "valid_rates",
+			config:      ThrottleConfig{Download: 1 << 20, Upload: 1 << 18, Connection: ThrottleConnection{Download: 1 << 16, Upload: 1 << 14}},
+			expectError: false,
+		},
+		{
+			name:        "valid_latency",
+			config:      ThrottleConfig{Latency: 300 * time.Millisecond, Jitter: 100 * time.Millisecond},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_download",
+			config:      ThrottleConfig{Download: -1},
+			expectError: true,
+		},
+		{
+			name:        "negative_connection_upload",
+			config:      ThrottleConfig{Connection: ThrottleConnection{Upload: -1}},
+			expectError: true,
+		},
+		{
+			name:        "negative_latency",
+			config:      ThrottleConfig{Latency: -time.Second},
+			expectError: true,
+		},
+		{
+			name:        "negative_jitter",
+			config:      ThrottleConfig{Jitter: -time.Second},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
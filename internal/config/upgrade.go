@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// UpgradeConfig represents a configuration for the connections upgraded to
+// another protocol, such as WebSockets.
+type UpgradeConfig struct {
+	Idle  time.Duration `koanf:"idle"`  // Maximum time without traffic before the connection is closed, 0 disables it
+	Grace time.Duration `koanf:"grace"` // Time given to the connections to close on shutdown before they are dropped
+}
+
+// Validate checks if the upgrade configuration is valid.
+func (cfg UpgradeConfig) Validate() error {
+	var errs []error
+
+	if cfg.Idle < 0 {
+		errs = append(errs, fmt.Errorf("invalid upgrade idle timeout: %v", cfg.Idle))
+	}
+
+	if cfg.Grace < 0 {
+		errs = append(errs, fmt.Errorf("invalid upgrade grace period: %v", cfg.Grace))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestUpgradeConfigValidate checks the Upgrade Config validation.
+func TestUpgradeConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string        // Name of the test case
+		config      UpgradeConfig // The Upgrade configuration
+		expectError bool          // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Upgrade,
+			expectError: false,
+		},
+		{
+			name:        "valid_without_timeouts",
+			config:      UpgradeConfig{},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_idle",
+			config:      UpgradeConfig{Idle: -time.Second, Grace: time.Second},
+			expectError: true,
+		},
+		{
+			name:        "negative_grace",
+			config:      UpgradeConfig{Idle: time.Hour, Grace: -time.Second},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// UpstreamConfig represents a configuration for the outbound connections to
+// the target through the proxy.
+type UpstreamConfig struct {
+	Timeout UpstreamTimeouts `koanf:"timeout"` // Outbound connection timeouts
+	Idle    UpstreamIdle     `koanf:"idle"`    // Idle connections pool limits
+	Host    UpstreamHost     `koanf:"host"`    // Per target host connection limits
+	Warm    UpstreamWarm     `koanf:"warm"`    // Warm pool of established connections
+	HTTP2   bool             `koanf:"http2"`   // Whether HTTP/2 is attempted with HTTPS targets
+}
+
+// UpstreamTimeouts holds the outbound connection timeouts. A zero value means
+// no timeout.
+type UpstreamTimeouts struct {
+	Dial     time.Duration `koanf:"dial"`     // Maximum duration to connect to the proxy
+	TLS      time.Duration `koanf:"tls"`      // Maximum duration of the TLS handshake with the target
+	Connect  time.Duration `koanf:"connect"`  // Maximum duration for the proxy to answer a CONNECT request
+	Response time.Duration `koanf:"response"` // Maximum duration to wait for the response headers
+	Idle     time.Duration `koanf:"idle"`     // Maximum duration an idle connection is kept open
+}
+
+// UpstreamIdle holds the limits of the idle connections pool.
+type UpstreamIdle struct {
+	Conns int `koanf:"conns"` // Maximum number of idle connections, 0 means no limit
+}
+
+// UpstreamHost holds the connection limits per target host.
+type UpstreamHost struct {
+	Conns int          `koanf:"conns"` // Maximum number of connections per host, 0 means no limit
+	Idle  UpstreamIdle `koanf:"idle"`  // Maximum number of idle connections per host
+}
+
+// UpstreamWarm holds the settings of the warm pool, which keeps connections
+// to the target established so that requests after idle periods don't pay for
+// a fresh CONNECT and TLS handshake.
+type UpstreamWarm struct {
+	Conns    int           `koanf:"conns"`    // Number of connections to keep established, 0 disables it
+	Interval time.Duration `koanf:"interval"` // How often the connections are refreshed
+}
+
+// Validate checks if the upstream configuration is valid.
+func (cfg UpstreamConfig) Validate() error {
+	var errs []error
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"dial", cfg.Timeout.Dial},
+		{"tls", cfg.Timeout.TLS},
+		{"connect", cfg.Timeout.Connect},
+		{"response", cfg.Timeout.Response},
+		{"idle", cfg.Timeout.Idle},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("invalid upstream %s timeout: %v", timeout.name, timeout.value))
+		}
+	}
+
+	if cfg.Idle.Conns < 0 {
+		errs = append(errs, fmt.Errorf("invalid upstream idle connections: %d", cfg.Idle.Conns))
+	}
+
+	if cfg.Host.Conns < 0 {
+		errs = append(errs, fmt.Errorf("invalid upstream connections per host: %d", cfg.Host.Conns))
+	}
+
+	if cfg.Host.Idle.Conns < 0 {
+		errs = append(errs, fmt.Errorf("invalid upstream idle connections per host: %d", cfg.Host.Idle.Conns))
+	}
+
+	if cfg.Warm.Conns < 0 {
+		errs = append(errs, fmt.Errorf("invalid upstream warm connections: %d", cfg.Warm.Conns))
+	}
+
+	// The warm connections must fit in the idle pool and be refreshed before
+	// they are closed for being idle.
+	if cfg.Warm.Conns > 0 {
+		if cfg.Warm.Interval <= 0 {
+			errs = append(errs, fmt.Errorf("invalid upstream warm interval: %v", cfg.Warm.Interval))
+		}
+		if cfg.Timeout.Idle > 0 && cfg.Warm.Interval >= cfg.Timeout.Idle {
+			errs = append(errs, fmt.Errorf("upstream warm interval (%v) must be shorter than the idle timeout (%v)", cfg.Warm.Interval, cfg.Timeout.Idle))
+		}
+		// A zero limit of idle connections per host means Go's default.
+		hostIdleConns := cfg.Host.Idle.Conns
+		if hostIdleConns == 0 {
+			hostIdleConns = http.DefaultMaxIdleConnsPerHost
+		}
+		if hostIdleConns < cfg.Warm.Conns || (cfg.Idle.Conns > 0 && cfg.Idle.Conns < cfg.Warm.Conns) {
+			errs = append(errs, fmt.Errorf("upstream warm connections (%d) must not exceed the idle connections limits", cfg.Warm.Conns))
+		}
+		if cfg.Host.Conns > 0 && cfg.Host.Conns < cfg.Warm.Conns {
+			errs = append(errs, fmt.Errorf("upstream warm connections (%d) must not exceed the connections per host (%d)", cfg.Warm.Conns, cfg.Host.Conns))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
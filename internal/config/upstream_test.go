@@ -0,0 +1,93 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestUpstreamConfigValidate checks the Upstream Config validation.
+func TestUpstreamConfigValidate(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string         // Name of the test case
+		config      UpstreamConfig // The Upstream configuration
+		expectError bool           // true if an error is expected, false otherwise
+	}{
+		// Valid tests cases
+		{
+			name:        "valid_defaults",
+			config:      Defaults.Upstream,
+			expectError: false,
+		},
+		{
+			name:        "valid_disabled_timeouts",
+			config:      UpstreamConfig{},
+			expectError: false,
+		},
+		{
+			name:        "valid_warm_pool",
+			config:      UpstreamConfig{Timeout: UpstreamTimeouts{Idle: time.Minute}, Host: UpstreamHost{Idle: UpstreamIdle{Conns: 4}}, Warm: UpstreamWarm{Conns: 4, Interval: 30 * time.Second}},
+			expectError: false,
+		},
+		{
+			name:        "valid_warm_pool_within_default_idle_pool",
+			config:      UpstreamConfig{Warm: UpstreamWarm{Conns: 2, Interval: 30 * time.Second}},
+			expectError: false,
+		},
+		// Invalid test cases
+		{
+			name:        "negative_dial_timeout",
+			config:      UpstreamConfig{Timeout: UpstreamTimeouts{Dial: -time.Second}},
+			expectError: true,
+		},
+		{
+			name:        "negative_idle_connections",
+			config:      UpstreamConfig{Idle: UpstreamIdle{Conns: -1}},
+			expectError: true,
+		},
+		{
+			name:        "warm_pool_without_interval",
+			config:      UpstreamConfig{Host: UpstreamHost{Idle: UpstreamIdle{Conns: 4}}, Warm: UpstreamWarm{Conns: 2}},
+			expectError: true,
+		},
+		{
+			name:        "warm_interval_longer_than_idle_timeout",
+			config:      UpstreamConfig{Timeout: UpstreamTimeouts{Idle: time.Minute}, Host: UpstreamHost{Idle: UpstreamIdle{Conns: 4}}, Warm: UpstreamWarm{Conns: 2, Interval: 2 * time.Minute}},
+			expectError: true,
+		},
+		{
+			name:        "warm_pool_larger_than_idle_pool",
+			config:      UpstreamConfig{Host: UpstreamHost{Idle: UpstreamIdle{Conns: 2}}, Warm: UpstreamWarm{Conns: 4, Interval: time.Second}},
+			expectError: true,
+		},
+		{
+			name:        "warm_pool_larger_than_default_idle_pool",
+			config:      UpstreamConfig{Warm: UpstreamWarm{Conns: 4, Interval: time.Second}},
+			expectError: true,
+		},
+		{
+			name:        "warm_pool_larger_than_connections_per_host",
+			config:      UpstreamConfig{Host: UpstreamHost{Conns: 2, Idle: UpstreamIdle{Conns: 4}}, Warm: UpstreamWarm{Conns: 4, Interval: time.Second}},
+			expectError: true,
+		},
+		{
+			name:        "negative_connect_timeout",
+			config:      UpstreamConfig{Timeout: UpstreamTimeouts{Connect: -time.Second}},
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Validate()
+			if (got != nil) != tt.expectError {
+				if tt.expectError {
+					t.Errorf("Config: %+v\nExpected error, but got: %v", tt.config, got)
+				} else {
+					t.Errorf("Config: %+v\nExpected no error, but got: %v", tt.config, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package har reads and writes HTTP Archive (HAR) 1.2 files.
+//
+// It defines the subset of the HAR format that prxy records, and a Writer
+// that appends entries to a file while keeping it a valid HAR document after
+// every entry, so that recordings survive a crash.
+//
+// Use Create to start a recording, Writer.Add to append entries to it, and
+// Read to load it back.
+// Fields that are not part of the HAR specification are prefixed with an
+// underscore, as the specification allows.
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Version is the version of the HAR format.
+const Version = "1.2"
+
+// HAR is the root object of a HAR document.
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log holds the recorded entries.
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+// Creator identifies the application that created the log.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is a recorded request and response pair.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"` // Total elapsed time, in milliseconds
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// Response is a recorded response. A response with a zero status records a
+// request that failed without a response.
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+	Error       string      `json:"_error,omitempty"` // Why the request failed, if it did
+}
+
+// Cookie is a recorded cookie.
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NameValue is a recorded header or query string parameter.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is a recorded request body.
+type PostData struct {
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text"`
+	Encoding  string `json:"_encoding,omitempty"`  // "base64" for binary bodies
+	Truncated bool   `json:"_truncated,omitempty"` // Whether the body exceeded the size cap
+}
+
+// Content is a recorded response body.
+type Content struct {
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`   // "base64" for binary bodies
+	Truncated bool   `json:"_truncated,omitempty"` // Whether the body exceeded the size cap
+}
+
+// Timings holds the duration of each phase of a request, in milliseconds. A
+// value of -1 means the phase does not apply.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// Headers converts HTTP headers to recorded headers, sorted by name. The
+// values of the headers in redact, compared case-insensitively, are replaced.
+func Headers(header http.Header, redact []string) []NameValue {
+	headers := make([]NameValue, 0, len(header))
+	for name, values := range header {
+		redacted := slices.ContainsFunc(redact, func(r string) bool { return http.CanonicalHeaderKey(r) == http.CanonicalHeaderKey(name) })
+		for _, value := range values {
+			if redacted {
+				value = Redacted
+			}
+			headers = append(headers, NameValue{Name: name, Value: value})
+		}
+	}
+	slices.SortStableFunc(headers, func(a, b NameValue) int { return strings.Compare(a.Name, b.Name) })
+
+	return headers
+}
+
+// Redacted is the value that replaces redacted headers.
+const Redacted = "[REDACTED]"
+
+// QueryString converts URL query values to recorded parameters, sorted by
+// name.
+func QueryString(query url.Values) []NameValue {
+	params := make([]NameValue, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, NameValue{Name: name, Value: value})
+		}
+	}
+	slices.SortStableFunc(params, func(a, b NameValue) int { return strings.Compare(a.Name, b.Name) })
+
+	return params
+}
+
+// EncodeBody returns the text of a recorded body, and "base64" as encoding if
+// the body is not valid UTF-8.
+func EncodeBody(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// DecodeBody returns the bytes of a recorded body.
+func DecodeBody(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// Milliseconds converts a duration to milliseconds, as used by HAR times.
+func Milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Read reads a HAR file.
+func Read(path string) (*HAR, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc HAR
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid HAR file %s: %w", path, err)
+	}
+
+	return &doc, nil
+}
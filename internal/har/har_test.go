@@ -0,0 +1,78 @@
+package har
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// TestHeaders checks the conversion and redaction of headers.
+func TestHeaders(t *testing.T) {
+	header := http.Header{
+		"Content-Type":  {"text/plain"},
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"a=1", "b=2"},
+	}
+
+	got := Headers(header, []string{"authorization", "COOKIE"})
+	expected := []NameValue{
+		{Name: "Authorization", Value: Redacted},
+		{Name: "Content-Type", Value: "text/plain"},
+		{Name: "Cookie", Value: Redacted},
+		{Name: "Cookie", Value: Redacted},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Headers()\nExpected %v, but got: %v", expected, got)
+	}
+}
+
+// TestQueryString checks the conversion of query parameters.
+func TestQueryString(t *testing.T) {
+	query, _ := url.ParseQuery("b=2&a=1&b=3")
+
+	got := QueryString(query)
+	expected := []NameValue{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "b", Value: "3"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("QueryString()\nExpected %v, but got: %v", expected, got)
+	}
+}
+
+// TestEncodeBody checks that bodies survive a round trip through their
+// recorded form.
+func TestEncodeBody(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name             string // Name of the test case
+		body             []byte // Body to encode
+		expectedEncoding string // Expected encoding
+	}{
+		{
+			name: "text",
+			body: []byte(`{"hello":"wörld"}`),
+		},
+		{
+			name:             "binary",
+			body:             []byte{0x1f, 0x8b, 0x08, 0x00, 0xff},
+			expectedEncoding: "base64",
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, encoding := EncodeBody(tt.body)
+			if encoding != tt.expectedEncoding {
+				t.Errorf("EncodeBody()\nExpected encoding %q, but got: %q", tt.expectedEncoding, encoding)
+			}
+
+			body, err := DecodeBody(text, encoding)
+			if err != nil {
+				t.Fatalf("DecodeBody() failed: %v", err)
+			}
+			if string(body) != string(tt.body) {
+				t.Errorf("DecodeBody()\nExpected %q, but got: %q", tt.body, body)
+			}
+		})
+	}
+}
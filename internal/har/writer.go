@@ -0,0 +1,87 @@
+package har
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// footer closes the entries array and the log and root objects.
+const footer = "\n]}}\n"
+
+// Writer appends entries to a HAR file. After every entry, the file is
+// rewritten from the end of the previous entry, so that it is always a
+// complete HAR document, even if the process crashes.
+type Writer struct {
+	mu      sync.Mutex
+	file    *os.File
+	offset  int64 // Where the footer starts
+	entries int   // Number of entries written
+}
+
+// Create creates or truncates the HAR file at path and writes an empty log.
+// New files are only readable by their owner, since the recorded bodies may
+// hold sensitive data.
+func Create(path string, creator Creator) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	creatorJSON, err := json.Marshal(creator)
+	if err != nil {
+		file.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	header := fmt.Sprintf(`{"log":{"version":%q,"creator":%s,"entries":[`, Version, creatorJSON)
+	w := &Writer{file: file}
+	if err := w.write(header); err != nil {
+		file.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Add appends an entry to the file.
+func (w *Writer) Add(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	separator := ",\n"
+	if w.entries == 0 {
+		separator = "\n"
+	}
+	if err := w.write(separator + string(data)); err != nil {
+		return err
+	}
+	w.entries++
+
+	return nil
+}
+
+// Close closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// write writes s, followed by the footer, where the footer starts. It must be
+// called with the lock held, except by Create.
+func (w *Writer) write(s string) error {
+	if _, err := w.file.WriteAt([]byte(s+footer), w.offset); err != nil {
+		return err
+	}
+	w.offset += int64(len(s))
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package har
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestWriter_Add checks that the file is a valid HAR document after every
+// entry.
+func TestWriter_Add(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	w, err := Create(path, Creator{Name: "prxy", Version: "test"})
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+
+	urls := []string{"", "http://example.com/a", "http://example.com/b", "http://example.com/c"}
+	for i, url := range urls {
+		if url != "" {
+			if err := w.Add(Entry{Request: Request{Method: "GET", URL: url}}); err != nil {
+				t.Fatalf("Add() failed: %v", err)
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		var doc HAR
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("After %d entries\nExpected a valid HAR document, but got: %v\n%s", i, err, data)
+		}
+
+		if doc.Log.Version != Version || doc.Log.Creator.Name != "prxy" {
+			t.Errorf("Expected version %q by prxy, but got: %+v", Version, doc.Log)
+		}
+		if len(doc.Log.Entries) != i {
+			t.Fatalf("Expected %d entries, but got: %d", i, len(doc.Log.Entries))
+		}
+		if i > 0 && doc.Log.Entries[i-1].Request.URL != url {
+			t.Errorf("Expected last entry URL %q, but got: %q", url, doc.Log.Entries[i-1].Request.URL)
+		}
+	}
+}
+
+// TestCreate_Mode checks that new files are only readable by their owner.
+func TestCreate_Mode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	w, err := Create(path, Creator{Name: "prxy", Version: "test"})
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("Expected mode %v, but got: %v", os.FileMode(0o600), mode)
+	}
+}
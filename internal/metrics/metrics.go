@@ -0,0 +1,259 @@
+// Package metrics provides a minimal registry of metrics exposed in the
+// Prometheus text exposition format.
+//
+// It supports counters and gauges, optionally partitioned by labels, which is
+// all prxy needs to report its internal state without pulling the Prometheus
+// client library and its dependencies.
+//
+// Use NewRegistry to create a Registry, register metrics with its Counter and
+// Gauge methods, and serve them with its Handler.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds a set of metrics to be exposed together.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Counter registers a counter with the given name, help text and label names.
+// Registering the same name twice returns the existing counter.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels)}
+}
+
+// Gauge registers a gauge with the given name, help text and label names.
+// Registering the same name twice returns the existing gauge.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels)}
+}
+
+// register returns the family with the given name, creating it if needed.
+func (r *Registry) register(name, help, kind string, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || !slices.Equal(f.labels, labels) {
+			panic(fmt.Sprintf("metrics: %s registered twice with different types or labels", name))
+		}
+		return f
+	}
+
+	f := &family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+	r.families[name] = f
+
+	return f
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format,
+// sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(families, func(a, b *family) int { return strings.Compare(a.name, b.name) })
+
+	var sb strings.Builder
+	for _, f := range families {
+		f.write(&sb)
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// Handler returns an http.Handler that serves the metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", ContentType)
+		_, _ = r.WriteTo(rw)
+	})
+}
+
+// Counter is a metric that only goes up.
+type Counter struct {
+	f *family
+}
+
+// Inc increments the counter with the given label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments the counter with the given label values by delta, which
+// must not be negative.
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: %s counter cannot decrease", c.f.name))
+	}
+	c.f.update(values, func(v float64) float64 { return v + delta })
+}
+
+// Value returns the current value of the counter with the given label values.
+func (c *Counter) Value(values ...string) float64 {
+	return c.f.value(values)
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	f *family
+}
+
+// Set sets the gauge with the given label values to v.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.update(values, func(float64) float64 { return v })
+}
+
+// Add adds delta, which may be negative, to the gauge with the given label
+// values.
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.f.update(values, func(v float64) float64 { return v + delta })
+}
+
+// Inc increments the gauge with the given label values by one.
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec decrements the gauge with the given label values by one.
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// Value returns the current value of the gauge with the given label values.
+func (g *Gauge) Value(values ...string) float64 {
+	return g.f.value(values)
+}
+
+// family holds every series of a metric, one per combination of label values.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single value of a metric.
+type series struct {
+	values []string
+	value  float64
+}
+
+// update applies fn to the series with the given label values.
+func (f *family) update(values []string, fn func(float64) float64) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: slices.Clone(values)}
+		f.series[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+// value returns the value of the series with the given label values, or 0 if
+// it doesn't exist yet.
+func (f *family) value(values []string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.series[strings.Join(values, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// write writes the family in the Prometheus text exposition format, with the
+// series sorted by label values. A family without labels and without series
+// is written as zero, so that it is visible before it is first updated.
+func (f *family) write(sb *strings.Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(sb, "# TYPE %s %s\n", f.name, f.kind)
+
+	if len(f.labels) == 0 && len(f.series) == 0 {
+		fmt.Fprintf(sb, "%s 0\n", f.name)
+		return
+	}
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		sb.WriteString(f.name)
+		if len(f.labels) > 0 {
+			sb.WriteByte('{')
+			for i, label := range f.labels {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				fmt.Fprintf(sb, "%s=\"%s\"", label, escapeLabel(s.values[i]))
+			}
+			sb.WriteByte('}')
+		}
+		fmt.Fprintf(sb, " %s\n", formatValue(s.value))
+	}
+}
+
+// formatValue formats a sample value as expected by Prometheus.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes backslashes and line feeds in help texts.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes backslashes, double quotes and line feeds in label
+// values.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRegistry_WriteTo checks the text exposition format of the metrics.
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("test_requests_total", "Total number of requests.", "code")
+	inflight := r.Gauge("test_inflight", "Number of requests in flight.")
+	r.Counter("test_errors_total", "Total number of errors.")
+
+	requests.Inc("200")
+	requests.Add(2, "200")
+	requests.Inc(`5"x`)
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+
+	// Registering the same metric again returns the existing one.
+	if got := r.Counter("test_requests_total", "Total number of requests.", "code").Value("200"); got != 3 {
+		t.Errorf("Value()\nExpected 3, but got: %v", got)
+	}
+
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo() failed: %v", err)
+	}
+
+	expected := `# HELP test_errors_total Total number of errors.
+# TYPE test_errors_total counter
+test_errors_total 0
+# HELP test_inflight Number of requests in flight.
+# TYPE test_inflight gauge
+test_inflight 1
+# HELP test_requests_total Total number of requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 3
+test_requests_total{code="5\"x"} 1
+`
+	if sb.String() != expected {
+		t.Errorf("WriteTo()\nExpected:\n%s\nBut got:\n%s", expected, sb.String())
+	}
+}
+
+// TestRegistry_Handler checks that the metrics are served with the expected
+// content type.
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("test_up", "Whether the test is up.").Set(1)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Expected content type %q, but got: %q", ContentType, got)
+	}
+	if !strings.Contains(rec.Body.String(), "test_up 1\n") {
+		t.Errorf("Expected body to contain the gauge, but got: %q", rec.Body)
+	}
+}
+
+// TestRegistry_Panics checks that misuses of the metrics panic.
+func TestRegistry_Panics(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name string          // Name of the test case
+		fn   func(*Registry) // Misuse of the registry
+	}{
+		{
+			name: "conflicting_registration",
+			fn: func(r *Registry) {
+				r.Counter("test_total", "Test.")
+				r.Gauge("test_total", "Test.")
+			},
+		},
+		{
+			name: "wrong_label_count",
+			fn:   func(r *Registry) { r.Counter("test_total", "Test.", "code").Inc() },
+		},
+		{
+			name: "decreasing_counter",
+			fn:   func(r *Registry) { r.Counter("test_total", "Test.").Add(-1) },
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected a panic, but got none")
+				}
+			}()
+			tt.fn(NewRegistry())
+		})
+	}
+}
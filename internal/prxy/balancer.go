@@ -0,0 +1,475 @@
+package prxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// backend is a target that receives a share of the traffic.
+type backend struct {
+	id       string              // Stable identifier, used as the sticky cookie value
+	url      *url.URL            // Target URL
+	label    string              // Target URL without credentials, used in logs and metrics
+	director func(*http.Request) // Rewrites a request to the target
+	weight   atomic.Int64        // Relative share of the traffic
+	inFlight atomic.Int64        // Requests being served
+	healthy  atomic.Bool         // Whether the last active health check passed
+	failures atomic.Int64        // Consecutive failed requests
+	ejected  atomic.Int64        // Until when the backend is out of the pool, in Unix nanoseconds
+	current  int64               // Current weight of the round-robin strategy, guarded by balancer.mu
+}
+
+// newBackend creates a backend for the given target.
+func newBackend(cfg config.BackendConfig) (*backend, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &backend{
+		id:       backendID(cfg.URL),
+		url:      u,
+		label:    u.Redacted(),
+		director: httputil.NewSingleHostReverseProxy(u).Director,
+	}
+	b.weight.Store(int64(cfg.EffectiveWeight()))
+	b.healthy.Store(true)
+
+	return b, nil
+}
+
+// backendID returns the identifier of the backend with the given URL.
+func backendID(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+// available reports whether the backend can receive new requests: it has a
+// positive weight, passes the active health checks and is not ejected.
+func (b *backend) available(now time.Time) bool {
+	return b.weight.Load() > 0 && b.up(now)
+}
+
+// up reports whether the backend passes the active health checks and is not
+// ejected.
+func (b *backend) up(now time.Time) bool {
+	return b.healthy.Load() && now.UnixNano() >= b.ejected.Load()
+}
+
+// backendContextKey is the context key of the backend chosen for a request.
+type backendContextKey struct{}
+
+// balancer spreads the traffic among the backends according to the strategy
+// and their weights, optionally keeping every client on the same backend.
+//
+// Backends failing the active health checks or ejected after consecutive
+// failures are kept out of the pool. If no backend is left, the traffic is
+// spread among all of them, since failing fast would not help either.
+type balancer struct {
+	logger    *logging.Logger
+	cfg       config.BalanceConfig
+	backends  []*backend
+	hashKind  string           // Kind of request attribute hashed by the hash strategy
+	hashName  string           // Name of the hashed header or cookie
+	now       func() time.Time // Current time, replaceable in tests
+	mu        sync.Mutex       // Guards the round-robin state
+	weights   *metrics.Gauge   // Current weight, by backend
+	requests  *metrics.Counter // Served requests, by backend and status code
+	seconds   *metrics.Counter // Time spent serving requests, by backend
+	inFlight  *metrics.Gauge   // Requests being served, by backend
+	up        *metrics.Gauge   // Whether the backend is in the pool, by backend
+	ejections *metrics.Counter // Passive ejections, by backend
+}
+
+// newBalancer creates a balancer for the given backends.
+func newBalancer(cfg config.BalanceConfig, backends []config.BackendConfig, logger *logging.Logger, registry *metrics.Registry) (*balancer, error) {
+	hashKind, hashName, err := config.ParseBalanceHash(cfg.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	lb := &balancer{
+		logger:    logger,
+		cfg:       cfg,
+		hashKind:  hashKind,
+		hashName:  hashName,
+		now:       time.Now,
+		weights:   registry.Gauge("prxy_backend_weight", "Current weight of the backend.", "backend"),
+		requests:  registry.Counter("prxy_backend_requests_total", "Total number of requests served by the backend, by status code.", "backend", "code"),
+		seconds:   registry.Counter("prxy_backend_request_seconds_total", "Total time spent serving requests by the backend, in seconds.", "backend"),
+		inFlight:  registry.Gauge("prxy_backend_in_flight", "Number of requests being served by the backend.", "backend"),
+		up:        registry.Gauge("prxy_backend_up", "Whether the backend passes the health checks and is not ejected.", "backend"),
+		ejections: registry.Counter("prxy_backend_ejections_total", "Total number of times the backend was ejected after consecutive failures.", "backend"),
+	}
+
+	for _, cfg := range backends {
+		b, err := newBackend(cfg)
+		if err != nil {
+			return nil, err
+		}
+		lb.backends = append(lb.backends, b)
+		lb.weights.Set(float64(b.weight.Load()), b.label)
+		lb.up.Set(1, b.label)
+	}
+
+	return lb, nil
+}
+
+// wrap returns a handler that chooses a backend for every request before
+// serving it with next.
+func (lb *balancer) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		b := lb.choose(rw, req)
+
+		b.inFlight.Add(1)
+		lb.inFlight.Inc(b.label)
+		defer func() {
+			b.inFlight.Add(-1)
+			lb.inFlight.Dec(b.label)
+		}()
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: rw}
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), backendContextKey{}, b)))
+
+		lb.requests.Inc(b.label, strconv.Itoa(sw.status()))
+		lb.seconds.Add(time.Since(start).Seconds(), b.label)
+	})
+}
+
+// choose returns the backend for the request, according to the sticky mode
+// and the strategy. In cookie mode, clients without a valid assignment get a
+// cookie for the chosen backend.
+func (lb *balancer) choose(rw http.ResponseWriter, req *http.Request) *backend {
+	pool := lb.pool()
+
+	switch lb.cfg.Sticky {
+	case config.BalanceStickyCookie:
+		if cookie, err := req.Cookie(lb.cfg.Cookie); err == nil {
+			for _, b := range pool {
+				if b.id == cookie.Value {
+					return b
+				}
+			}
+		}
+		b := lb.pick(pool, req)
+		http.SetCookie(rw, &http.Cookie{Name: lb.cfg.Cookie, Value: b.id, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+		return b
+	case config.BalanceStickyIP:
+		return lb.hashed(pool, clientIP(req))
+	default:
+		return lb.pick(pool, req)
+	}
+}
+
+// pool returns the backends that can receive new requests or, if there are
+// none, every backend with a positive weight.
+func (lb *balancer) pool() []*backend {
+	now := lb.now()
+	pool := make([]*backend, 0, len(lb.backends))
+	for _, b := range lb.backends {
+		if b.available(now) {
+			pool = append(pool, b)
+		}
+	}
+	if len(pool) > 0 {
+		return pool
+	}
+
+	for _, b := range lb.backends {
+		if b.weight.Load() > 0 {
+			pool = append(pool, b)
+		}
+	}
+	if len(pool) > 0 {
+		return pool
+	}
+
+	return lb.backends
+}
+
+// pick returns a backend of the pool according to the strategy.
+func (lb *balancer) pick(pool []*backend, req *http.Request) *backend {
+	switch lb.cfg.Strategy {
+	case config.BalanceStrategyRoundRobin:
+		return lb.roundRobin(pool)
+	case config.BalanceStrategyLeastConn:
+		return lb.leastConn(pool)
+	case config.BalanceStrategyHash:
+		if key := lb.hashKey(req); key != "" {
+			return lb.hashed(pool, key)
+		}
+		return lb.random(pool)
+	default:
+		return lb.random(pool)
+	}
+}
+
+// random returns a backend of the pool chosen at random, in proportion to the
+// weights.
+func (lb *balancer) random(pool []*backend) *backend {
+	var total int64
+	for _, b := range pool {
+		total += b.weight.Load()
+	}
+	if total <= 0 {
+		return pool[0]
+	}
+
+	n := rand.N(total)
+	for _, b := range pool {
+		if n -= b.weight.Load(); n < 0 {
+			return b
+		}
+	}
+
+	return pool[len(pool)-1]
+}
+
+// roundRobin returns the next backend of the pool in a smooth weighted
+// round-robin, which interleaves the backends instead of sending consecutive
+// requests to the heaviest one.
+func (lb *balancer) roundRobin(pool []*backend) *backend {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	var chosen *backend
+	var total int64
+	for _, b := range pool {
+		weight := b.weight.Load()
+		b.current += weight
+		total += weight
+		if chosen == nil || b.current > chosen.current {
+			chosen = b
+		}
+	}
+	chosen.current -= total
+
+	return chosen
+}
+
+// leastConn returns the backend of the pool with the fewest requests in
+// flight relative to its weight. Ties are broken at random.
+func (lb *balancer) leastConn(pool []*backend) *backend {
+	var chosen *backend
+	offset := rand.N(len(pool))
+	for i := range pool {
+		b := pool[(offset+i)%len(pool)]
+		if b.weight.Load() <= 0 {
+			continue
+		}
+		// Compare inFlight/weight without dividing.
+		if chosen == nil || b.inFlight.Load()*chosen.weight.Load() < chosen.inFlight.Load()*b.weight.Load() {
+			chosen = b
+		}
+	}
+	if chosen == nil {
+		return pool[0]
+	}
+
+	return chosen
+}
+
+// hashed returns the backend of the pool assigned to the key, in proportion
+// to the weights. It uses weighted rendezvous hashing, so that changing a
+// weight or the pool only moves the keys from or to the affected backends.
+func (lb *balancer) hashed(pool []*backend, key string) *backend {
+	var chosen *backend
+	best := math.Inf(-1)
+	for _, b := range pool {
+		weight := b.weight.Load()
+		if weight <= 0 {
+			continue
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(b.id))
+		_, _ = h.Write([]byte(key))
+		// Map the hash to (0, 1) so that its logarithm is finite.
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		if score := float64(weight) / -math.Log(u); score > best {
+			chosen, best = b, score
+		}
+	}
+	if chosen == nil {
+		return pool[0]
+	}
+
+	return chosen
+}
+
+// hashKey returns the request attribute hashed by the hash strategy, empty if
+// the request doesn't have it.
+func (lb *balancer) hashKey(req *http.Request) string {
+	switch lb.hashKind {
+	case "ip":
+		return clientIP(req)
+	case "path":
+		return req.URL.Path
+	case "header":
+		return req.Header.Get(lb.hashName)
+	case "cookie":
+		if cookie, err := req.Cookie(lb.hashName); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// clientIP returns the IP address of the client of the request.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// failed records a failed request to the backend, ejecting it from the pool
+// after the configured number of consecutive failures.
+func (lb *balancer) failed(b *backend) {
+	if lb.cfg.Eject.Failures <= 0 {
+		return
+	}
+	if b.failures.Add(1) < int64(lb.cfg.Eject.Failures) {
+		return
+	}
+
+	b.failures.Store(0)
+	b.ejected.Store(lb.now().Add(lb.cfg.Eject.Duration).UnixNano())
+	lb.ejections.Inc(b.label)
+	lb.up.Set(0, b.label)
+	lb.logger.Warn("Backend ejected after consecutive failures", "backend", b.label, "failures", lb.cfg.Eject.Failures, "duration", lb.cfg.Eject.Duration)
+
+	time.AfterFunc(lb.cfg.Eject.Duration, func() {
+		if b.up(lb.now()) {
+			lb.up.Set(1, b.label)
+			lb.logger.Info("Backend returned to the pool", "backend", b.label)
+		}
+	})
+}
+
+// succeeded records a successful request to the backend.
+func (lb *balancer) succeeded(b *backend) {
+	b.failures.Store(0)
+}
+
+// direct rewrites the request to the backend chosen for it.
+func (lb *balancer) direct(req *http.Request) {
+	b, ok := req.Context().Value(backendContextKey{}).(*backend)
+	if !ok {
+		b = lb.backends[0]
+	}
+	b.director(req)
+	// Ensure the Host header is rewritten to the backend's host.
+	req.Host = b.url.Host
+}
+
+// retarget returns a copy of the request of a client, already directed to a
+// backend, directed to another backend than the failed ones, for a retry. The
+// request is returned as is if no other backend is available.
+func (lb *balancer) retarget(req *http.Request, failed []*backend) *http.Request {
+	var pool []*backend
+	for _, b := range lb.pool() {
+		if !slices.Contains(failed, b) {
+			pool = append(pool, b)
+		}
+	}
+	if len(pool) == 0 || req.RequestURI == "" {
+		return req
+	}
+
+	// The URL was rewritten to the failed backend, so it is rewritten again
+	// from the one requested by the client.
+	u, err := url.ParseRequestURI(req.RequestURI)
+	if err != nil {
+		return req
+	}
+	b := lb.pick(pool, req)
+	retry := req.Clone(context.WithValue(req.Context(), backendContextKey{}, b))
+	retry.URL = u
+	lb.direct(retry)
+
+	return retry
+}
+
+// reload applies the weights of the given backends. Backends cannot be added
+// or removed at runtime, so any change to the set of URLs is only logged.
+func (lb *balancer) reload(backends []config.BackendConfig) {
+	weights := make(map[string]int, len(backends))
+	for _, cfg := range backends {
+		weights[backendID(cfg.URL)] = cfg.EffectiveWeight()
+	}
+
+	for _, b := range lb.backends {
+		weight, ok := weights[b.id]
+		if !ok {
+			continue
+		}
+		delete(weights, b.id)
+		if old := b.weight.Swap(int64(weight)); old != int64(weight) {
+			lb.logger.Info("Backend weight changed", "backend", b.label, "old", old, "new", weight)
+		}
+		lb.weights.Set(float64(weight), b.label)
+	}
+
+	if len(weights) > 0 || len(backends) != len(lb.backends) {
+		lb.logger.Warn("Backends were added or removed, restart to apply them")
+	}
+}
+
+// targets returns the label of every backend.
+func (lb *balancer) targets() []string {
+	labels := make([]string, 0, len(lb.backends))
+	for _, b := range lb.backends {
+		labels = append(labels, b.label)
+	}
+	return labels
+}
+
+// ejectTransport is an http.RoundTripper that reports the outcome of every
+// round trip to the balancer, so that failing backends are ejected.
+type ejectTransport struct {
+	next http.RoundTripper
+	lb   *balancer
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *ejectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+
+	b, ok := req.Context().Value(backendContextKey{}).(*backend)
+	if !ok {
+		return resp, err
+	}
+
+	switch {
+	case err != nil:
+		// Requests canceled by the client say nothing about the backend.
+		if req.Context().Err() == nil {
+			t.lb.failed(b)
+		}
+	case resp.StatusCode == http.StatusBadGateway, resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		t.lb.failed(b)
+	default:
+		t.lb.succeeded(b)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,349 @@
+package prxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// newTestBalancer creates a balancer for the given weights, with backends
+// named after their position.
+func newTestBalancer(t *testing.T, cfg config.BalanceConfig, weights ...int) *balancer {
+	t.Helper()
+
+	backends := make([]config.BackendConfig, 0, len(weights))
+	for i, weight := range weights {
+		backends = append(backends, config.BackendConfig{URL: fmt.Sprintf("http://backend%d.example.com", i), Weight: &weight})
+	}
+	lb, err := newBalancer(cfg, backends, newTestLogger(t), metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("newBalancer() failed: %v", err)
+	}
+
+	return lb
+}
+
+// TestBalancer_Random checks that the traffic is split according to the
+// weights.
+func TestBalancer_Random(t *testing.T) {
+	lb := newTestBalancer(t, config.Defaults.Balance, 90, 10, 0)
+
+	counts := make(map[*backend]int)
+	for range 10000 {
+		counts[lb.random(lb.backends)]++
+	}
+
+	if got := counts[lb.backends[1]]; got < 800 || got > 1200 {
+		t.Errorf("random()\nExpected about 1000 picks of the canary, but got: %d", got)
+	}
+	if got := counts[lb.backends[2]]; got != 0 {
+		t.Errorf("random()\nExpected no picks of the drained backend, but got: %d", got)
+	}
+}
+
+// TestBalancer_RoundRobin checks that the traffic is split exactly according
+// to the weights, interleaving the backends.
+func TestBalancer_RoundRobin(t *testing.T) {
+	lb := newTestBalancer(t, config.Defaults.Balance, 3, 1)
+
+	var picks []string
+	for range 8 {
+		picks = append(picks, lb.roundRobin(lb.backends).label)
+	}
+
+	old, canary := lb.backends[0].label, lb.backends[1].label
+	expected := []string{old, old, canary, old, old, old, canary, old}
+	if !slices.Equal(picks, expected) {
+		t.Errorf("roundRobin()\nExpected %v, but got: %v", expected, picks)
+	}
+}
+
+// TestBalancer_LeastConn checks that the backend with the fewest requests in
+// flight relative to its weight is chosen.
+func TestBalancer_LeastConn(t *testing.T) {
+	lb := newTestBalancer(t, config.Defaults.Balance, 1, 2, 1)
+	lb.backends[0].inFlight.Store(2)
+	lb.backends[1].inFlight.Store(3) // 1.5 per weight unit
+	lb.backends[2].inFlight.Store(1)
+
+	for range 10 {
+		if got := lb.leastConn(lb.backends); got != lb.backends[2] {
+			t.Fatalf("leastConn()\nExpected %s, but got: %s", lb.backends[2].label, got.label)
+		}
+	}
+}
+
+// TestBalancer_Hash checks that requests with the same hashed attribute are
+// sent to the same backend.
+func TestBalancer_Hash(t *testing.T) {
+	cfg := config.Defaults.Balance
+	cfg.Strategy, cfg.Hash = config.BalanceStrategyHash, "header:X-User"
+	lb := newTestBalancer(t, cfg, 1, 1, 1)
+
+	users := make(map[string]*backend)
+	for i := range 300 {
+		user := fmt.Sprintf("user-%d", i%100)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", user)
+		b := lb.choose(httptest.NewRecorder(), req)
+		if previous, ok := users[user]; ok && previous != b {
+			t.Fatalf("choose()\nExpected %s to stay on %s, but got: %s", user, previous.label, b.label)
+		}
+		users[user] = b
+	}
+
+	// Removing a backend from the pool only moves its keys.
+	pool := lb.backends[:2]
+	for user, previous := range users {
+		if got := lb.hashed(pool, user); previous != lb.backends[2] && got != previous {
+			t.Errorf("hashed(%s)\nExpected %s, but got: %s", user, previous.label, got.label)
+		}
+	}
+}
+
+// TestBalancer_Eject checks that backends failing consecutive requests are
+// kept out of the pool for the configured duration.
+func TestBalancer_Eject(t *testing.T) {
+	cfg := config.Defaults.Balance
+	cfg.Eject = config.BalanceEject{Failures: 2, Duration: time.Hour}
+	lb := newTestBalancer(t, cfg, 1, 1)
+	now := time.Now()
+	lb.now = func() time.Time { return now }
+
+	failing := lb.backends[0]
+	transport := &ejectTransport{
+		next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			b := req.Context().Value(backendContextKey{}).(*backend)
+			if b == failing {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}),
+		lb: lb,
+	}
+	send := func(b *backend) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), backendContextKey{}, b))
+		if resp, err := transport.RoundTrip(req); err == nil {
+			resp.Body.Close() //nolint:errcheck
+		}
+	}
+
+	send(failing)
+	if got := lb.pool(); len(got) != 2 {
+		t.Fatalf("pool()\nExpected both backends after a single failure, but got: %d", len(got))
+	}
+
+	send(failing)
+	if got := lb.pool(); len(got) != 1 || got[0] == failing {
+		t.Fatalf("pool()\nExpected the failing backend to be ejected, but got: %d backends", len(got))
+	}
+	if got := lb.ejections.Value(failing.label); got != 1 {
+		t.Errorf("ejections\nExpected 1, but got: %v", got)
+	}
+
+	// Without available backends, every backend is used.
+	lb.backends[1].healthy.Store(false)
+	if got := lb.pool(); len(got) != 2 {
+		t.Errorf("pool()\nExpected every backend when none is available, but got: %d", len(got))
+	}
+	lb.backends[1].healthy.Store(true)
+
+	now = now.Add(time.Hour)
+	if got := lb.pool(); len(got) != 2 {
+		t.Errorf("pool()\nExpected the backend to return after the ejection, but got: %d backends", len(got))
+	}
+}
+
+// TestBackendChecker checks that backends failing the active health checks
+// are kept out of the pool until they pass again.
+func TestBackendChecker(t *testing.T) {
+	var status atomic.Int64
+	status.Store(http.StatusServiceUnavailable)
+	sick := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/healthz" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(sick.Close)
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(healthy.Close)
+
+	backends := []config.BackendConfig{{URL: sick.URL}, {URL: healthy.URL}}
+	lb, err := newBalancer(config.Defaults.Balance, backends, newTestLogger(t), metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("newBalancer() failed: %v", err)
+	}
+	check := config.Defaults.Balance.Check
+	check.Path = "/healthz"
+	bc := newBackendChecker(check, lb, http.DefaultTransport, newTestLogger(t))
+
+	bc.checkAll()
+	if got := lb.pool(); len(got) != 1 || got[0] != lb.backends[1] {
+		t.Fatalf("pool()\nExpected only the healthy backend, but got: %d backends", len(got))
+	}
+	if got := lb.up.Value(lb.backends[0].label); got != 0 {
+		t.Errorf("prxy_backend_up\nExpected 0 for the sick backend, but got: %v", got)
+	}
+
+	status.Store(http.StatusOK)
+	bc.checkAll()
+	if got := lb.pool(); len(got) != 2 {
+		t.Errorf("pool()\nExpected the recovered backend to return, but got: %d backends", len(got))
+	}
+	if got := lb.up.Value(lb.backends[0].label); got != 1 {
+		t.Errorf("prxy_backend_up\nExpected 1 for the recovered backend, but got: %v", got)
+	}
+}
+
+// TestBalancer_StickyCookie checks that clients are kept on the backend of
+// their cookie, as long as it receives traffic.
+func TestBalancer_StickyCookie(t *testing.T) {
+	cfg := config.Defaults.Balance
+	cfg.Sticky, cfg.Cookie = config.BalanceStickyCookie, "canary"
+	lb := newTestBalancer(t, cfg, 1, 1)
+
+	// First request gets a cookie.
+	rec := httptest.NewRecorder()
+	first := lb.choose(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "canary" || cookies[0].Value != first.id {
+		t.Fatalf("choose()\nExpected a cookie for backend %s, but got: %v", first.id, cookies)
+	}
+
+	// Next requests with the cookie stay on the same backend.
+	for range 20 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		if got := lb.choose(rec, req); got != first {
+			t.Fatalf("choose()\nExpected backend %s, but got: %s", first.label, got.label)
+		}
+		if rec.Header().Get("Set-Cookie") != "" {
+			t.Fatal("choose()\nExpected no new cookie for an assigned client")
+		}
+	}
+
+	// Clients of a drained backend are reassigned.
+	first.weight.Store(0)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	if got := lb.choose(rec, req); got == first {
+		t.Error("choose()\nExpected a drained backend not to be chosen")
+	}
+	if rec.Header().Get("Set-Cookie") == "" {
+		t.Error("choose()\nExpected a new cookie for a reassigned client")
+	}
+}
+
+// TestBalancer_StickyIP checks that clients are kept on the same backend by
+// IP address, and that changing a weight only moves clients to or from that
+// backend.
+func TestBalancer_StickyIP(t *testing.T) {
+	cfg := config.Defaults.Balance
+	cfg.Sticky = config.BalanceStickyIP
+	lb := newTestBalancer(t, cfg, 45, 45, 10)
+
+	assigned := make(map[string]*backend)
+	for i := range 1000 {
+		ip := fmt.Sprintf("10.0.%d.%d", i%500/250, i%250) // Every IP is seen twice
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		b := lb.choose(httptest.NewRecorder(), req)
+		if previous, ok := assigned[ip]; ok && previous != b {
+			t.Fatalf("choose()\nExpected %s to stay on %s, but got: %s", ip, previous.label, b.label)
+		}
+		assigned[ip] = b
+	}
+
+	// Increasing the canary weight only moves clients to the canary.
+	canary := lb.backends[2]
+	canary.weight.Store(50)
+	for ip, previous := range assigned {
+		if got := lb.hashed(lb.backends, ip); got != previous && got != canary {
+			t.Errorf("hashed(%s)\nExpected %s or the canary, but got: %s", ip, previous.label, got.label)
+		}
+	}
+}
+
+// TestBalancer_Reload checks that reloading applies the new weights.
+func TestBalancer_Reload(t *testing.T) {
+	registry := metrics.NewRegistry()
+	ninety, ten := 90, 10
+	backends := []config.BackendConfig{{URL: "http://old.example.com", Weight: &ninety}, {URL: "http://new.example.com", Weight: &ten}}
+	lb, err := newBalancer(config.Defaults.Balance, backends, newTestLogger(t), registry)
+	if err != nil {
+		t.Fatalf("newBalancer() failed: %v", err)
+	}
+
+	fifty := 50
+	lb.reload([]config.BackendConfig{{URL: "http://old.example.com", Weight: &fifty}, {URL: "http://new.example.com", Weight: &fifty}, {URL: "http://extra.example.com"}})
+
+	for _, b := range lb.backends {
+		if got := b.weight.Load(); got != 50 {
+			t.Errorf("reload()\nExpected weight 50 for %s, but got: %d", b.label, got)
+		}
+		if got := lb.weights.Value(b.label); got != 50 {
+			t.Errorf("reload()\nExpected weight metric 50 for %s, but got: %v", b.label, got)
+		}
+	}
+	if len(lb.backends) != 2 {
+		t.Errorf("reload()\nExpected backends not to be added, but got: %d", len(lb.backends))
+	}
+}
+
+// TestNew_Backends checks that the traffic is spread among the backends, with
+// the Host header rewritten to each of them, and reported by backend.
+func TestNew_Backends(t *testing.T) {
+	newTarget := func(name string) *httptest.Server {
+		target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = io.WriteString(rw, name+":"+req.Host)
+		}))
+		t.Cleanup(target.Close)
+		return target
+	}
+	old, canary := newTarget("old"), newTarget("new")
+	proxy := newTestProxy(t)
+
+	cfg := newTestConfig("", proxy.URL)
+	one := 1
+	cfg.Backends = []config.BackendConfig{{URL: old.URL, Weight: &one}, {URL: canary.URL, Weight: &one}}
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for range 50 {
+		rec := httptest.NewRecorder()
+		prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		body := rec.Body.String()
+		if body != "old:"+strings.TrimPrefix(old.URL, "http://") && body != "new:"+strings.TrimPrefix(canary.URL, "http://") {
+			t.Fatalf("GET /\nExpected the response of a backend with its host, but got: %q", body)
+		}
+		seen[body] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("GET /\nExpected both backends to receive traffic, but got: %v", seen)
+	}
+
+	rec := httptest.NewRecorder()
+	prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_prxy/metrics", nil))
+	if want := `prxy_backend_requests_total{backend="` + old.URL + `",code="200"}`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("GET /_prxy/metrics\nExpected %s, but got:\n%s", want, rec.Body)
+	}
+}
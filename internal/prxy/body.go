@@ -0,0 +1,82 @@
+package prxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// errBodyTooLarge is returned for the requests whose body cannot be buffered.
+var errBodyTooLarge = errors.New("request body exceeds the buffer size")
+
+// bodyHandler serves the requests with the reverse proxy, according to the
+// body settings of their route: how often the response is flushed, and whether
+// the request body is buffered before it is sent to the target.
+type bodyHandler struct {
+	proxy *httputil.ReverseProxy
+	cfg   config.BodyConfig
+}
+
+// newBodyHandler creates a bodyHandler that serves the requests with proxy.
+func newBodyHandler(cfg config.BodyConfig, proxy *httputil.ReverseProxy) *bodyHandler {
+	proxy.FlushInterval = cfg.Flush
+	return &bodyHandler{proxy: proxy, cfg: cfg}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *bodyHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	cfg := h.cfg
+	if route, ok := requestRoute(req); ok {
+		cfg = route.Body.Resolve(cfg)
+	}
+
+	// Protocol switches, such as WebSockets, and gRPC calls may stream in
+	// both directions, so they are never buffered.
+	if cfg.Buffer && req.Header.Get("Upgrade") == "" && !isGRPC(req) {
+		if err := readBody(req, cfg.Max); err != nil {
+			h.proxy.ErrorHandler(rw, req, err)
+			return
+		}
+	}
+
+	proxy := h.proxy
+	if cfg.Flush != proxy.FlushInterval {
+		routeProxy := *h.proxy
+		routeProxy.FlushInterval = cfg.Flush
+		proxy = &routeProxy
+	}
+	proxy.ServeHTTP(rw, req)
+}
+
+// readBody reads the request body in full, so that it is sent to the target
+// with a known length and can be replayed. It fails with errBodyTooLarge if
+// the body exceeds the limit.
+func readBody(req *http.Request, limit int64) error {
+	if req.ContentLength > limit {
+		return errBodyTooLarge
+	}
+
+	body, replayable, err := bufferBody(req, limit)
+	if err != nil {
+		return err
+	}
+	if !replayable {
+		return errBodyTooLarge
+	}
+	if body == nil {
+		return nil
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+
+	return nil
+}
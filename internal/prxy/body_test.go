@@ -0,0 +1,216 @@
+package prxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// newTestBodyServer starts a server that proxies the requests with a
+// bodyHandler to the transport, looking up the given routes first.
+func newTestBodyServer(t *testing.T, cfg config.BodyConfig, routes []config.RouteConfig, transport http.RoundTripper) *httptest.Server {
+	t.Helper()
+
+	targetURL, _ := url.Parse("http://target.example.com")
+	errorHandler, err := newErrorResponder(config.Defaults.Error, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("newErrorResponder() failed: %v", err)
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite:      func(pr *httputil.ProxyRequest) { pr.SetURL(targetURL) },
+		Transport:    transport,
+		ErrorHandler: errorHandler.ServeError,
+	}
+	server := httptest.NewServer(newRouter(routes).wrap(newBodyHandler(cfg, proxy)))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// TestBodyHandler_Buffer checks that request bodies are streamed or buffered
+// as configured globally and by route, and that buffered bodies are bounded.
+func TestBodyHandler_Buffer(t *testing.T) {
+	buffered, streamed, small := true, false, int64(4)
+	routes := []config.RouteConfig{
+		{Path: "/upload", Body: config.RouteBody{Buffer: &buffered}},
+		{Path: "/upload/small", Body: config.RouteBody{Max: &small}},
+		{Path: "/stream", Body: config.RouteBody{Buffer: &streamed}},
+	}
+
+	// Test cases
+	tests := []struct {
+		name                  string // Name of the test case
+		buffer                bool   // Whether request bodies are buffered globally
+		path                  string // Requested path
+		body                  string // Request body, sent without a length
+		expectedStatus        int    // Expected status code
+		expectedContentLength int64  // Expected length of the body received by the target
+	}{
+		{
+			name:                  "streams_by_default",
+			path:                  "/",
+			body:                  "payload",
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: -1,
+		},
+		{
+			name:                  "buffers_if_enabled",
+			buffer:                true,
+			path:                  "/",
+			body:                  "payload",
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: 7,
+		},
+		{
+			name:                  "buffers_by_route",
+			path:                  "/upload",
+			body:                  "payload",
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: 7,
+		},
+		{
+			name:                  "streams_by_route",
+			buffer:                true,
+			path:                  "/stream",
+			body:                  "payload",
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: -1,
+		},
+		{
+			name:           "rejects_bodies_over_the_max",
+			buffer:         true,
+			path:           "/upload/small",
+			body:           "payload",
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:                  "accepts_bodies_within_the_max",
+			buffer:                true,
+			path:                  "/upload/small",
+			body:                  "data",
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: 4,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contentLength int64
+			var received string
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				contentLength = req.ContentLength
+				body, _ := io.ReadAll(req.Body)
+				received = string(body)
+				return newTestResponse(http.StatusOK, "ok"), nil
+			})
+			cfg := config.Defaults.Body
+			cfg.Buffer = tt.buffer
+			server := newTestBodyServer(t, cfg, routes, transport)
+
+			// Hide the length of the body, so that it is sent chunked.
+			body := struct{ io.Reader }{strings.NewReader(tt.body)}
+			resp, err := http.Post(server.URL+tt.path, "text/plain", body)
+			if err != nil {
+				t.Fatalf("POST %s failed: %v", tt.path, err)
+			}
+			resp.Body.Close() //nolint:errcheck
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("POST %s\nExpected status %d, but got: %d", tt.path, tt.expectedStatus, resp.StatusCode)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if contentLength != tt.expectedContentLength {
+				t.Errorf("POST %s\nExpected the target to receive a length of %d, but got: %d", tt.path, tt.expectedContentLength, contentLength)
+			}
+			if received != tt.body {
+				t.Errorf("POST %s\nExpected the target to receive %q, but got: %q", tt.path, tt.body, received)
+			}
+		})
+	}
+}
+
+// TestBodyHandler_Flush checks that the responses of a route with a negative
+// flush interval reach the client as they are written, while the others are
+// held back until the server buffer fills up.
+func TestBodyHandler_Flush(t *testing.T) {
+	flush := time.Duration(-1)
+	routes := []config.RouteConfig{{Path: "/events", Body: config.RouteBody{Flush: &flush}}}
+
+	// Test cases
+	tests := []struct {
+		name          string // Name of the test case
+		path          string // Requested path
+		expectFlushed bool   // Whether the first part must arrive before the rest
+	}{
+		{
+			name:          "holds_back_by_default",
+			path:          "/",
+			expectFlushed: false,
+		},
+		{
+			name:          "flushes_every_write_by_route",
+			path:          "/events",
+			expectFlushed: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			transport := roundTripFunc(func(*http.Request) (*http.Response, error) {
+				pr, pw := io.Pipe()
+				go func() {
+					_, _ = io.WriteString(pw, "first")
+					<-release
+					_, _ = io.WriteString(pw, "-last")
+					pw.Close() //nolint:errcheck
+				}()
+				resp := newTestResponse(http.StatusOK, "")
+				resp.Body, resp.ContentLength = pr, int64(len("first-last"))
+				return resp, nil
+			})
+			server := newTestBodyServer(t, config.Defaults.Body, routes, transport)
+
+			// The response headers are held back along with the first part.
+			first := make(chan string, 1)
+			go func() {
+				defer close(first)
+				resp, err := http.Get(server.URL + tt.path)
+				if err != nil {
+					t.Errorf("GET %s failed: %v", tt.path, err)
+					return
+				}
+				defer resp.Body.Close() //nolint:errcheck
+				buf := make([]byte, len("first"))
+				n, _ := io.ReadFull(resp.Body, buf)
+				first <- string(buf[:n])
+				_, _ = io.Copy(io.Discard, resp.Body)
+			}()
+
+			// Read the first part while the rest is held by the target.
+			select {
+			case got := <-first:
+				if !tt.expectFlushed {
+					t.Errorf("GET %s\nExpected the response to be held back, but got: %q", tt.path, got)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.expectFlushed {
+					t.Errorf("GET %s\nExpected the first part to be flushed, but it was held back", tt.path)
+				}
+			}
+			close(release)
+			<-first
+		})
+	}
+}
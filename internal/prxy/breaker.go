@@ -0,0 +1,224 @@
+package prxy
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// breakerState is the state of a circuit breaker.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // Requests flow normally
+	breakerOpen                         // Requests fail fast
+	breakerHalfOpen                     // A few probes are let through
+)
+
+// breakerStates are all the states, in the order they are reported.
+var breakerStates = []breakerState{breakerClosed, breakerOpen, breakerHalfOpen}
+
+// String returns the name of the state.
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitOpenError is returned for the requests rejected while the circuit is
+// open.
+type circuitOpenError struct {
+	retryAfter time.Duration // Time left until the circuit lets probes through
+}
+
+// Error implements the error interface.
+func (e *circuitOpenError) Error() string {
+	return "circuit breaker is open"
+}
+
+// RetryAfter returns the value of the Retry-After header, in seconds and at
+// least one.
+func (e *circuitOpenError) RetryAfter() string {
+	return strconv.Itoa(max(1, int(math.Ceil(e.retryAfter.Seconds()))))
+}
+
+// breakerTransport is an http.RoundTripper that stops sending requests to the
+// target after too many consecutive failures, so that clients fail fast
+// instead of waiting for the dial timeout while the target is down.
+//
+// Once the cooldown has passed, the circuit becomes half-open and lets a few
+// probes through: it closes when they succeed and opens again when any of
+// them fails.
+type breakerTransport struct {
+	next   http.RoundTripper
+	cfg    config.BreakerConfig
+	logger *logging.Logger
+	now    func() time.Time
+
+	stateGauge  *metrics.Gauge   // Current state, one series per state
+	transitions *metrics.Counter // State transitions, by new state
+	rejected    *metrics.Counter // Requests rejected while open
+
+	mu         sync.Mutex
+	state      breakerState
+	generation uint64    // Incremented on every transition to ignore stale results
+	failures   int       // Consecutive failures while closed
+	openedAt   time.Time // When the circuit was last opened
+	probes     int       // Probes in flight while half-open
+	successes  int       // Successful probes while half-open
+}
+
+// newBreakerTransport wraps the next transport with a circuit breaker that
+// reports its state to the registry.
+func newBreakerTransport(cfg config.BreakerConfig, next http.RoundTripper, logger *logging.Logger, registry *metrics.Registry) *breakerTransport {
+	b := &breakerTransport{
+		next:        next,
+		cfg:         cfg,
+		logger:      logger,
+		now:         time.Now,
+		stateGauge:  registry.Gauge("prxy_breaker_state", "Current state of the circuit breaker.", "state"),
+		transitions: registry.Counter("prxy_breaker_transitions_total", "Total number of circuit breaker state transitions.", "state"),
+		rejected:    registry.Counter("prxy_breaker_rejected_total", "Total number of requests rejected by the open circuit breaker."),
+	}
+	b.reportState()
+
+	return b
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (b *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	generation, err := b.allow()
+	if err != nil {
+		b.rejected.Inc()
+		return nil, err
+	}
+
+	resp, err := b.next.RoundTrip(req)
+
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// The client went away, which says nothing about the target.
+		b.release(generation)
+	case err != nil || slices.Contains(b.cfg.Statuses, resp.StatusCode):
+		b.record(generation, false)
+	default:
+		b.record(generation, true)
+	}
+
+	return resp, err
+}
+
+// allow reports whether a request may be sent, returning the generation it
+// belongs to, or a circuitOpenError if it must be rejected.
+func (b *breakerTransport) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		remaining := b.cfg.Cooldown - b.now().Sub(b.openedAt)
+		if remaining > 0 {
+			return 0, &circuitOpenError{retryAfter: remaining}
+		}
+		b.transition(breakerHalfOpen)
+	}
+
+	if b.state == breakerHalfOpen {
+		if b.probes >= b.cfg.Probes {
+			return 0, &circuitOpenError{}
+		}
+		b.probes++
+	}
+
+	return b.generation, nil
+}
+
+// record updates the state with the result of a request.
+func (b *breakerTransport) record(generation uint64, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case breakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.Failures {
+			b.transition(breakerOpen)
+		}
+	case breakerHalfOpen:
+		b.probes--
+		if !success {
+			b.transition(breakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.Probes {
+			b.transition(breakerClosed)
+		}
+	}
+}
+
+// release frees the probe slot of a request without a meaningful result.
+func (b *breakerTransport) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == breakerHalfOpen {
+		b.probes--
+	}
+}
+
+// transition moves the circuit to the given state. It must be called with the
+// lock held.
+func (b *breakerTransport) transition(state breakerState) {
+	previous := b.state
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+
+	switch state {
+	case breakerOpen:
+		b.openedAt = b.now()
+		b.logger.Warn("Circuit breaker opened", "from", previous, "cooldown", b.cfg.Cooldown)
+	case breakerHalfOpen:
+		b.logger.Info("Circuit breaker half-open", "from", previous, "probes", b.cfg.Probes)
+	case breakerClosed:
+		b.logger.Info("Circuit breaker closed", "from", previous)
+	}
+
+	b.transitions.Inc(state.String())
+	b.reportState()
+}
+
+// reportState sets the state gauge to 1 for the current state and to 0 for
+// the others.
+func (b *breakerTransport) reportState() {
+	for _, state := range breakerStates {
+		value := 0.0
+		if state == b.state {
+			value = 1
+		}
+		b.stateGauge.Set(value, state.String())
+	}
+}
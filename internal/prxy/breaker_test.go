@@ -0,0 +1,206 @@
+package prxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// roundTripFunc is an http.RoundTripper backed by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements the http.RoundTripper interface.
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// TestBreakerTransport_States checks the transitions between the closed, open
+// and half-open states.
+func TestBreakerTransport_States(t *testing.T) {
+	var fail bool
+	var calls int
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+
+	now := time.Now()
+	registry := metrics.NewRegistry()
+	cfg := config.BreakerConfig{Failures: 3, Cooldown: 10 * time.Second, Probes: 2}
+	b := newBreakerTransport(cfg, next, newTestLogger(t), registry)
+	b.now = func() time.Time { return now }
+
+	roundTrip := func() error {
+		req := httptest.NewRequest(http.MethodGet, "http://target/", nil)
+		resp, err := b.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close() //nolint:errcheck
+		}
+		return err
+	}
+	expectState := func(state breakerState) {
+		t.Helper()
+		if b.state != state {
+			t.Fatalf("Expected state %s, but got: %s", state, b.state)
+		}
+		if got := b.stateGauge.Value(state.String()); got != 1 {
+			t.Errorf("Expected the %s state gauge to be 1, but got: %v", state, got)
+		}
+	}
+
+	// Failures below the threshold, interleaved with a success, keep it closed.
+	fail = true
+	_ = roundTrip()
+	_ = roundTrip()
+	fail = false
+	_ = roundTrip()
+	fail = true
+	_ = roundTrip()
+	_ = roundTrip()
+	expectState(breakerClosed)
+
+	// The third consecutive failure opens the circuit.
+	_ = roundTrip()
+	expectState(breakerOpen)
+
+	// While open, requests fail fast without reaching the target.
+	calls = 0
+	var openErr *circuitOpenError
+	if err := roundTrip(); !errors.As(err, &openErr) {
+		t.Fatalf("RoundTrip()\nExpected a circuitOpenError, but got: %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no requests to the target while open, but got: %d", calls)
+	}
+	if got := openErr.RetryAfter(); got != "10" {
+		t.Errorf("RetryAfter()\nExpected %q, but got: %q", "10", got)
+	}
+	if got := b.rejected.Value(); got != 1 {
+		t.Errorf("Expected 1 rejected request, but got: %v", got)
+	}
+
+	// After the cooldown, a failed probe opens the circuit again.
+	now = now.Add(cfg.Cooldown)
+	_ = roundTrip()
+	expectState(breakerOpen)
+
+	// After another cooldown, successful probes close it.
+	now = now.Add(cfg.Cooldown)
+	fail = false
+	_ = roundTrip()
+	expectState(breakerHalfOpen)
+	_ = roundTrip()
+	expectState(breakerClosed)
+
+	if got := b.transitions.Value("open"); got != 2 {
+		t.Errorf("Expected 2 transitions to open, but got: %v", got)
+	}
+}
+
+// TestBreakerTransport_HalfOpenProbes checks that only the configured number
+// of probes are let through while half-open, and that canceled requests free
+// their slot.
+func TestBreakerTransport_HalfOpenProbes(t *testing.T) {
+	block := make(chan struct{})
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		select {
+		case <-block:
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	})
+
+	cfg := config.BreakerConfig{Failures: 1, Cooldown: time.Second, Probes: 1}
+	b := newBreakerTransport(cfg, next, newTestLogger(t), metrics.NewRegistry())
+	b.state = breakerHalfOpen
+
+	// The probe is in flight until it is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := b.RoundTrip(httptest.NewRequest(http.MethodGet, "http://target/", nil).WithContext(ctx))
+		done <- err
+	}()
+	for {
+		b.mu.Lock()
+		probes := b.probes
+		b.mu.Unlock()
+		if probes == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Further requests are rejected while the probe is in flight.
+	var openErr *circuitOpenError
+	if _, err := b.RoundTrip(httptest.NewRequest(http.MethodGet, "http://target/", nil)); !errors.As(err, &openErr) {
+		t.Fatalf("RoundTrip()\nExpected a circuitOpenError, but got: %v", err)
+	}
+
+	// Canceling the probe frees its slot without changing the state.
+	cancel()
+	<-done
+	if b.state != breakerHalfOpen || b.probes != 0 {
+		t.Fatalf("Expected half-open state with no probes, but got: %s with %d probes", b.state, b.probes)
+	}
+
+	close(block)
+	resp, err := b.RoundTrip(httptest.NewRequest(http.MethodGet, "http://target/", nil))
+	if err != nil {
+		t.Fatalf("RoundTrip()\nExpected no error, but got: %v", err)
+	}
+	resp.Body.Close() //nolint:errcheck
+	if b.state != breakerClosed {
+		t.Errorf("Expected state %s, but got: %s", breakerClosed, b.state)
+	}
+}
+
+// TestNew_Breaker checks that requests fail fast with 503 once the circuit
+// breaker opens.
+func TestNew_Breaker(t *testing.T) {
+	proxy := newTestProxy(t)
+	cfg := newTestConfig("https://127.0.0.1:1", proxy.URL)
+	cfg.Breaker.Failures = 2
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	statuses := make([]int, 0, 3)
+	for range 3 {
+		rec := httptest.NewRecorder()
+		prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		statuses = append(statuses, rec.Code)
+		if rec.Code == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Error("Expected a Retry-After header on 503, but got none")
+		}
+	}
+
+	expected := []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusServiceUnavailable}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Fatalf("Expected statuses %v, but got: %v", expected, statuses)
+		}
+	}
+	if got := proxy.connects.Load(); got != 2 {
+		t.Errorf("Expected 2 CONNECT requests to the proxy, but got: %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_prxy/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `prxy_breaker_state{state="open"} 1`) {
+		t.Errorf("Expected the metrics to report the open state, but got:\n%s", rec.Body)
+	}
+}
@@ -0,0 +1,621 @@
+package prxy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/cache"
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// cacheHeader is the response header that tells how the cache served it.
+const cacheHeader = "X-Cache"
+
+// Outcomes of a request to the cache, reported in the cacheHeader and in the
+// metrics.
+const (
+	cacheHit         = "hit"         // Served from the cache
+	cacheMiss        = "miss"        // Fetched from the target
+	cacheRevalidated = "revalidated" // Served from the cache after the target confirmed it
+	cacheStale       = "stale"       // Served from the cache after the target failed
+	cacheBypass      = "bypass"      // Not cacheable, forwarded to the target
+)
+
+// maxCacheVariants is the maximum number of variants of a response, selected
+// by its Vary header, kept for the same URL.
+const maxCacheVariants = 8
+
+// heuristicStatuses are the status codes of the responses that can be
+// cached without explicit freshness information, as defined by RFC 9110.
+var heuristicStatuses = map[int]bool{
+	http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true,
+	http.StatusMultipleChoices: true, http.StatusMovedPermanently: true, http.StatusPermanentRedirect: true,
+	http.StatusNotFound: true, http.StatusMethodNotAllowed: true, http.StatusGone: true,
+	http.StatusRequestURITooLong: true, http.StatusNotImplemented: true,
+}
+
+// cacheEntry is a stored response, along with the request header values it
+// varies on.
+type cacheEntry struct {
+	Status       int
+	Header       http.Header
+	Body         []byte
+	Vary         http.Header // Values of the request headers named in the Vary header
+	RequestTime  time.Time   // When the request was sent
+	ResponseTime time.Time   // When the response was received
+}
+
+// cacheTransport is an http.RoundTripper that caches the responses of the
+// target as a shared cache, as defined by RFC 9111.
+//
+// Only GET requests are served from the cache. Stale responses are
+// revalidated with conditional requests, and served as is when the target
+// fails, for as long as allowed by the configuration or by the stale-if-error
+// directive of the response.
+type cacheTransport struct {
+	next      http.RoundTripper
+	cfg       config.CacheConfig
+	backends  []*backend // Backends whose responses are stored apart
+	store     cache.Store
+	logger    *logging.Logger
+	now       func() time.Time // Current time, replaceable in tests
+	mu        sync.Mutex       // Serializes the updates of the variants of a URL
+	requests  *metrics.Counter // Requests, by outcome
+	entries   *metrics.Gauge   // Stored URLs
+	size      *metrics.Gauge   // Size of the stored responses
+	evictions *metrics.Counter // Evicted URLs
+}
+
+// newCacheTransport creates a cacheTransport that sends the requests that
+// cannot be served from the cache to next, storing the responses of every
+// backend apart.
+func newCacheTransport(cfg config.CacheConfig, next http.RoundTripper, backends []*backend, logger *logging.Logger, registry *metrics.Registry) (*cacheTransport, error) {
+	t := &cacheTransport{
+		next:      next,
+		cfg:       cfg,
+		backends:  backends,
+		logger:    logger,
+		now:       time.Now,
+		requests:  registry.Counter("prxy_cache_requests_total", "Total number of requests to the cache, by outcome.", "outcome"),
+		entries:   registry.Gauge("prxy_cache_entries", "Number of URLs in the cache."),
+		size:      registry.Gauge("prxy_cache_size_bytes", "Size of the responses in the cache, in bytes."),
+		evictions: registry.Counter("prxy_cache_evictions_total", "Total number of URLs evicted from the cache to make room for others."),
+	}
+
+	onEvict := func() { t.evictions.Inc() }
+	switch cfg.Store {
+	case config.CacheStoreDisk:
+		store, err := cache.OpenDisk(cfg.Path, cfg.Size, onEvict)
+		if err != nil {
+			return nil, err
+		}
+		t.store = store
+	default:
+		t.store = cache.NewMemory(cfg.Size, onEvict)
+	}
+	t.updateMetrics()
+
+	return t, nil
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req)
+
+	if req.Method != http.MethodGet {
+		resp, err := t.next.RoundTrip(req)
+		// Successful unsafe requests invalidate the stored responses.
+		if err == nil && resp.StatusCode < 400 && !isSafeMethod(req.Method) {
+			t.invalidate(req.URL.RequestURI())
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") || req.Header.Get("Range") != "" {
+		t.requests.Inc(cacheBypass)
+		return t.next.RoundTrip(req)
+	}
+
+	entry := matchVariant(t.load(key), req)
+	if entry == nil {
+		if reqCC.has("only-if-cached") {
+			return gatewayTimeout(req), nil
+		}
+		return t.fetch(req, key, cacheMiss)
+	}
+
+	now := t.now()
+	age := entry.age(now)
+	if entry.fresh(reqCC, age) {
+		t.requests.Inc(cacheHit)
+		return entry.response(req, age, cacheHit), nil
+	}
+	if reqCC.has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+
+	return t.revalidate(req, key, entry, age)
+}
+
+// fetch sends the request to the target, storing the response if allowed.
+func (t *cacheTransport) fetch(req *http.Request, key, outcome string) (*http.Response, error) {
+	requestTime := t.now()
+	resp, err := t.next.RoundTrip(req)
+	t.requests.Inc(outcome)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Header.Set(cacheHeader, strings.ToUpper(outcome))
+	t.storeResponse(req, key, resp, requestTime)
+
+	return resp, nil
+}
+
+// revalidate asks the target whether the stale entry can still be used,
+// serving it if the target confirms it or fails.
+func (t *cacheTransport) revalidate(req *http.Request, key string, entry *cacheEntry, age time.Duration) (*http.Response, error) {
+	condReq := req.Clone(req.Context())
+	condReq.Header.Del("If-None-Match")
+	condReq.Header.Del("If-Modified-Since")
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		condReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		condReq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	requestTime := t.now()
+	resp, err := t.next.RoundTrip(condReq)
+
+	switch {
+	case err != nil || isServerError(resp.StatusCode):
+		staleness := age - entry.freshnessLifetime()
+		// Requests canceled by the client are not a failure of the target.
+		if req.Context().Err() == nil && t.staleAllowed(entry, staleness) {
+			attrs := []any{"url", req.URL.Redacted(), "staleness", staleness.Round(time.Second)}
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			} else {
+				attrs = append(attrs, "status", resp.StatusCode)
+				drainBody(resp)
+			}
+			t.logger.Warn("Serving stale response from the cache", attrs...)
+			t.requests.Inc(cacheStale)
+			return entry.response(req, age, cacheStale), nil
+		}
+		t.requests.Inc(cacheMiss)
+		return resp, err
+	case resp.StatusCode == http.StatusNotModified:
+		drainBody(resp)
+		// Refresh the stored entry with the headers of the confirmation.
+		for name, values := range resp.Header {
+			if name != "Content-Length" && !isHopHeader(name) {
+				entry.Header[name] = values
+			}
+		}
+		entry.RequestTime, entry.ResponseTime = requestTime, t.now()
+		t.save(key, entry)
+		t.requests.Inc(cacheRevalidated)
+		return entry.response(req, entry.age(t.now()), cacheRevalidated), nil
+	default:
+		t.requests.Inc(cacheMiss)
+		resp.Header.Set(cacheHeader, strings.ToUpper(cacheMiss))
+		t.storeResponse(req, key, resp, requestTime)
+		return resp, nil
+	}
+}
+
+// staleAllowed reports whether the entry can be served with the given
+// staleness because the target failed.
+func (t *cacheTransport) staleAllowed(entry *cacheEntry, staleness time.Duration) bool {
+	cc := parseCacheControl(entry.Header)
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage") || cc.has("no-cache") {
+		return false
+	}
+
+	allowed := t.cfg.Stale
+	if seconds, ok := cc.seconds("stale-if-error"); ok && seconds > allowed {
+		allowed = seconds
+	}
+
+	return staleness <= allowed
+}
+
+// storeResponse arranges for the response to be stored once its body has been
+// read, if it is allowed to be stored.
+func (t *cacheTransport) storeResponse(req *http.Request, key string, resp *http.Response, requestTime time.Time) {
+	if !storable(req, resp) || resp.ContentLength > t.cfg.Object.Max {
+		return
+	}
+
+	entry := &cacheEntry{
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Vary:         varyValues(resp.Header, req.Header),
+		RequestTime:  requestTime,
+		ResponseTime: t.now(),
+	}
+	entry.Header.Del(cacheHeader)
+	for name := range entry.Header {
+		if isHopHeader(name) {
+			entry.Header.Del(name)
+		}
+	}
+
+	resp.Body = &cacheBody{
+		ReadCloser: resp.Body,
+		max:        t.cfg.Object.Max,
+		done: func(body []byte) {
+			entry.Body = body
+			t.save(key, entry)
+		},
+	}
+}
+
+// load returns the stored variants of the key.
+func (t *cacheTransport) load(key string) []*cacheEntry {
+	data, ok := t.store.Get(key)
+	if !ok {
+		return nil
+	}
+
+	var entries []*cacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entries); err != nil {
+		t.logger.Warn("Failed to decode cached response", "error", err)
+		t.store.Delete(key)
+		return nil
+	}
+
+	return entries
+}
+
+// save stores the entry, replacing the variant that matches the same request
+// headers, if any.
+func (t *cacheTransport) save(key string, entry *cacheEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := []*cacheEntry{entry}
+	for _, variant := range t.load(key) {
+		if len(entries) == maxCacheVariants {
+			break
+		}
+		if !variant.sameVariant(entry) {
+			entries = append(entries, variant)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entries); err != nil {
+		t.logger.Warn("Failed to encode cached response", "error", err)
+		return
+	}
+	if !t.store.Set(key, buf.Bytes()) {
+		t.logger.Debug("Response too large to be cached", "key", key, "size", buf.Len())
+	}
+	t.updateMetrics()
+}
+
+// invalidate removes the stored variants of the path and query, whatever the
+// backend they come from, since the backends usually share their state.
+func (t *cacheTransport) invalidate(uri string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.store.Delete(uri)
+	for _, b := range t.backends {
+		t.store.Delete(b.id + " " + uri)
+	}
+	t.updateMetrics()
+}
+
+// updateMetrics reports the size of the store.
+func (t *cacheTransport) updateMetrics() {
+	t.entries.Set(float64(t.store.Len()))
+	t.size.Set(float64(t.store.Size()))
+}
+
+// cacheKey returns the key of the stored responses of the request: its path
+// and query, prefixed by the backend chosen for it, if any. Backends may serve
+// different content, such as the versions of a canary release.
+func cacheKey(req *http.Request) string {
+	if b, ok := req.Context().Value(backendContextKey{}).(*backend); ok {
+		return b.id + " " + req.URL.RequestURI()
+	}
+	return req.URL.RequestURI()
+}
+
+// storable reports whether the response to the request can be stored by a
+// shared cache.
+func storable(req *http.Request, resp *http.Response) bool {
+	if resp.StatusCode < 200 || resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" || resp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+	// Responses to requests with credentials are likely personalized, even
+	// without explicit freshness information.
+	if (req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "") && !cc.has("public") {
+		return false
+	}
+
+	return cc.has("public") || cc.has("max-age") || cc.has("s-maxage") || resp.Header.Get("Expires") != "" || heuristicStatuses[resp.StatusCode]
+}
+
+// age returns the current age of the entry, as defined by RFC 9111.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+
+	apparentAge := max(0, e.ResponseTime.Sub(date))
+	ageValue, _ := strconv.Atoi(e.Header.Get("Age"))
+	correctedAgeValue := time.Duration(ageValue)*time.Second + e.ResponseTime.Sub(e.RequestTime)
+	correctedInitialAge := max(apparentAge, correctedAgeValue)
+
+	return correctedInitialAge + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime returns for how long the entry is fresh, as defined by
+// RFC 9111.
+func (e *cacheEntry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if expiresValue := e.Header.Get("Expires"); expiresValue != "" {
+		expires, err := http.ParseTime(expiresValue)
+		if err != nil {
+			return 0 // Invalid dates mean already expired
+		}
+		return max(0, expires.Sub(date))
+	}
+
+	// Heuristic freshness: 10% of the time since the last modification,
+	// capped to a day.
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicStatuses[e.Status] {
+		return min(max(0, date.Sub(lastModified))/10, 24*time.Hour)
+	}
+
+	return 0
+}
+
+// fresh reports whether the entry can be served without revalidation.
+func (e *cacheEntry) fresh(reqCC cacheControl, age time.Duration) bool {
+	if reqCC.has("no-cache") || parseCacheControl(e.Header).has("no-cache") {
+		return false
+	}
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	return age < e.freshnessLifetime()
+}
+
+// sameVariant reports whether both entries answer requests with the same
+// values of the headers they vary on.
+func (e *cacheEntry) sameVariant(other *cacheEntry) bool {
+	if len(e.Vary) != len(other.Vary) {
+		return false
+	}
+	for name := range e.Vary {
+		if e.Vary.Get(name) != other.Vary.Get(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// response builds a response to the request from the entry. Conditional
+// requests matching the entry are answered with 304 Not Modified.
+func (e *cacheEntry) response(req *http.Request, age time.Duration, outcome string) *http.Response {
+	resp := &http.Response{
+		Status:     strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode: e.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     e.Header.Clone(),
+		Request:    req,
+	}
+	resp.Header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	resp.Header.Set(cacheHeader, strings.ToUpper(outcome))
+
+	if e.Status == http.StatusOK && notModified(req, e.Header) {
+		resp.Status = "304 " + http.StatusText(http.StatusNotModified)
+		resp.StatusCode = http.StatusNotModified
+		resp.Body = http.NoBody
+		return resp
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(e.Body))
+	resp.ContentLength = int64(len(e.Body))
+
+	return resp
+}
+
+// matchVariant returns the entry whose varying request headers match the
+// request, nil if there is none.
+func matchVariant(entries []*cacheEntry, req *http.Request) *cacheEntry {
+	for _, entry := range entries {
+		if entry.sameVariant(&cacheEntry{Vary: varyValues(entry.Header, req.Header)}) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// varyValues returns the values of the request headers named in the Vary
+// header of the response, normalized for comparison.
+func varyValues(respHeader, reqHeader http.Header) http.Header {
+	values := make(http.Header)
+	for _, field := range respHeader.Values("Vary") {
+		for name := range strings.SplitSeq(field, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			values.Set(name, strings.Join(strings.Fields(strings.Join(reqHeader.Values(name), ",")), " "))
+		}
+	}
+	return values
+}
+
+// notModified reports whether the conditional request matches the stored
+// response headers, as defined by RFC 9110.
+func notModified(req *http.Request, header http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for candidate := range strings.SplitSeq(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// cacheBody is an io.ReadCloser that keeps a copy of the body while it is
+// read, calling done with it once the whole body has been read, unless it
+// exceeds max bytes.
+type cacheBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	max      int64
+	overflow bool
+	done     func([]byte)
+}
+
+// Read implements the io.Reader interface.
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if int64(b.buf.Len()+n) > b.max {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && b.done != nil {
+		b.done(bytes.Clone(b.buf.Bytes()))
+		b.done = nil
+	}
+	return n, err
+}
+
+// cacheControl holds the directives of a Cache-Control header.
+type cacheControl map[string]string
+
+// parseCacheControl parses the Cache-Control header. A Pragma: no-cache
+// header is honored when there is no Cache-Control header.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, field := range header.Values("Cache-Control") {
+		for directive := range strings.SplitSeq(field, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	if len(cc) == 0 && strings.EqualFold(header.Get("Pragma"), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+// has reports whether the directive is present.
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the value of a directive holding a number of seconds.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(min(seconds, 1<<31)) * time.Second, true
+}
+
+// isSafeMethod reports whether the method is safe, as defined by RFC 9110.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// isServerError reports whether the status code means that the target
+// failed, so that a stale response can be served instead.
+func isServerError(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// gatewayTimeout returns the response to an only-if-cached request that
+// cannot be served from the cache.
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 " + http.StatusText(http.StatusGatewayTimeout),
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{cacheHeader: {strings.ToUpper(cacheMiss)}},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// drainBody discards the rest of the response body and closes it, so that
+// the connection can be reused.
+func drainBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close() //nolint:errcheck
+}
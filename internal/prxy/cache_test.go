@@ -0,0 +1,488 @@
+package prxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// testOrigin is a fake target that answers with the configured response and
+// records the requests it receives.
+type testOrigin struct {
+	requests []*http.Request
+	respond  func(req *http.Request) (*http.Response, error)
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (o *testOrigin) RoundTrip(req *http.Request) (*http.Response, error) {
+	o.requests = append(o.requests, req)
+	return o.respond(req)
+}
+
+// newTestResponse creates a response with the given status, headers, as
+// name/value pairs, and body.
+func newTestResponse(status int, body string, header ...string) *http.Response {
+	resp := &http.Response{
+		StatusCode:    status,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		resp.Header.Add(header[i], header[i+1])
+	}
+	return resp
+}
+
+// newTestCache creates a memory cacheTransport in front of the origin, with a
+// clock that the test can move.
+func newTestCache(t *testing.T, cfg config.CacheConfig, origin http.RoundTripper) (*cacheTransport, *time.Time) {
+	t.Helper()
+
+	c, err := newCacheTransport(cfg, origin, nil, newTestLogger(t), metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("newCacheTransport() failed: %v", err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	return c, &now
+}
+
+// get sends a GET request with the given headers, as name/value pairs,
+// through the transport, returning the response with its body read.
+func get(t *testing.T, rt http.RoundTripper, path string, header ...string) (*http.Response, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "http://target.example.com"+path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	resp.Body.Close() //nolint:errcheck
+
+	return resp, string(body)
+}
+
+// TestCacheTransport_Freshness checks that fresh responses are served from
+// the cache, and that the ones that must not be stored are not.
+func TestCacheTransport_Freshness(t *testing.T) {
+	cfg := config.Defaults.Cache
+	cfg.Store = config.CacheStoreMemory
+
+	// Test cases
+	tests := []struct {
+		name          string        // Name of the test case
+		header        []string      // Headers of the origin response
+		storeHeader   []string      // Headers of both requests
+		reqHeader     []string      // Headers of the second request
+		wait          time.Duration // Time between both requests
+		expectedCache string        // Expected X-Cache of the second response
+		expectedCalls int           // Expected requests to the origin
+	}{
+		{
+			name:          "max_age_hit",
+			header:        []string{"Cache-Control", "max-age=60"},
+			wait:          30 * time.Second,
+			expectedCache: "HIT",
+			expectedCalls: 1,
+		},
+		{
+			name:          "max_age_expired",
+			header:        []string{"Cache-Control", "max-age=60"},
+			wait:          time.Minute,
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+		{
+			name:          "s_maxage_over_max_age",
+			header:        []string{"Cache-Control", "max-age=0, s-maxage=60"},
+			expectedCache: "HIT",
+			expectedCalls: 1,
+		},
+		{
+			name:          "expires",
+			header:        []string{"Date", "Thu, 01 Jan 2026 00:00:00 GMT", "Expires", "Thu, 01 Jan 2026 00:01:00 GMT"},
+			wait:          30 * time.Second,
+			expectedCache: "HIT",
+			expectedCalls: 1,
+		},
+		{
+			name:          "heuristic_from_last_modified",
+			header:        []string{"Date", "Thu, 01 Jan 2026 00:00:00 GMT", "Last-Modified", "Wed, 31 Dec 2025 14:00:00 GMT"},
+			wait:          59 * time.Minute, // Fresh for 10% of 10 hours
+			expectedCache: "HIT",
+			expectedCalls: 1,
+		},
+		{
+			name:          "no_store",
+			header:        []string{"Cache-Control", "no-store, max-age=60"},
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+		{
+			name:          "private",
+			header:        []string{"Cache-Control", "private, max-age=60"},
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+		{
+			name:          "set_cookie",
+			header:        []string{"Cache-Control", "max-age=60", "Set-Cookie", "session=1"},
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+		{
+			name:          "cookie_heuristic",
+			header:        []string{"Date", "Thu, 01 Jan 2026 00:00:00 GMT", "Last-Modified", "Wed, 31 Dec 2025 14:00:00 GMT"},
+			storeHeader:   []string{"Cookie", "session=1"},
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+		{
+			name:          "authorization_max_age",
+			header:        []string{"Cache-Control", "max-age=60"},
+			storeHeader:   []string{"Authorization", "Bearer token"},
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+		{
+			name:          "authorization_public",
+			header:        []string{"Cache-Control", "public, max-age=60"},
+			storeHeader:   []string{"Authorization", "Bearer token"},
+			expectedCache: "HIT",
+			expectedCalls: 1,
+		},
+		{
+			name:          "request_no_cache",
+			header:        []string{"Cache-Control", "max-age=60"},
+			reqHeader:     []string{"Cache-Control", "no-cache"},
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+		{
+			name:          "request_max_age",
+			header:        []string{"Cache-Control", "max-age=60"},
+			reqHeader:     []string{"Cache-Control", "max-age=10"},
+			wait:          30 * time.Second,
+			expectedCache: "MISS",
+			expectedCalls: 2,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := &testOrigin{respond: func(*http.Request) (*http.Response, error) {
+				return newTestResponse(http.StatusOK, "hello", tt.header...), nil
+			}}
+			c, now := newTestCache(t, cfg, origin)
+
+			get(t, c, "/asset.js", tt.storeHeader...)
+			*now = now.Add(tt.wait)
+			resp, body := get(t, c, "/asset.js", append(tt.storeHeader, tt.reqHeader...)...)
+
+			if got := resp.Header.Get(cacheHeader); got != tt.expectedCache {
+				t.Errorf("GET /asset.js\nExpected %s %q, but got: %q", cacheHeader, tt.expectedCache, got)
+			}
+			if body != "hello" {
+				t.Errorf("GET /asset.js\nExpected body %q, but got: %q", "hello", body)
+			}
+			if len(origin.requests) != tt.expectedCalls {
+				t.Errorf("GET /asset.js\nExpected %d requests to the origin, but got: %d", tt.expectedCalls, len(origin.requests))
+			}
+		})
+	}
+}
+
+// TestCacheTransport_Revalidation checks that stale responses are
+// revalidated with conditional requests.
+func TestCacheTransport_Revalidation(t *testing.T) {
+	cfg := config.Defaults.Cache
+	cfg.Store = config.CacheStoreMemory
+
+	origin := &testOrigin{respond: func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-None-Match") == `"v1"` && req.Header.Get("If-Modified-Since") != "" {
+			return newTestResponse(http.StatusNotModified, "", "Cache-Control", "max-age=60"), nil
+		}
+		return newTestResponse(http.StatusOK, "v1", "ETag", `"v1"`, "Last-Modified", "Wed, 31 Dec 2025 00:00:00 GMT", "Cache-Control", "no-cache"), nil
+	}}
+	c, now := newTestCache(t, cfg, origin)
+
+	get(t, c, "/page")
+	resp, body := get(t, c, "/page")
+	if got := resp.Header.Get(cacheHeader); got != "REVALIDATED" || body != "v1" {
+		t.Errorf("GET /page\nExpected revalidated %q, but got: %s %q", "v1", got, body)
+	}
+
+	// The confirmation refreshed the stored headers.
+	*now = now.Add(30 * time.Second)
+	resp, _ = get(t, c, "/page")
+	if got := resp.Header.Get(cacheHeader); got != "HIT" {
+		t.Errorf("GET /page\nExpected %s HIT after the refresh, but got: %q", cacheHeader, got)
+	}
+	if got := resp.Header.Get("Age"); got != "30" {
+		t.Errorf("GET /page\nExpected Age 30, but got: %q", got)
+	}
+
+	// Conditional requests of the client are answered from the cache.
+	resp, _ = get(t, c, "/page", "If-None-Match", `W/"v1"`)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET /page\nExpected status 304 for a matching ETag, but got: %d", resp.StatusCode)
+	}
+
+	if len(origin.requests) != 2 {
+		t.Errorf("GET /page\nExpected 2 requests to the origin, but got: %d", len(origin.requests))
+	}
+}
+
+// TestCacheTransport_Vary checks that the variants of a response are stored
+// separately.
+func TestCacheTransport_Vary(t *testing.T) {
+	cfg := config.Defaults.Cache
+	cfg.Store = config.CacheStoreMemory
+
+	origin := &testOrigin{respond: func(req *http.Request) (*http.Response, error) {
+		return newTestResponse(http.StatusOK, "lang:"+req.Header.Get("Accept-Language"), "Cache-Control", "max-age=60", "Vary", "Accept-Language"), nil
+	}}
+	c, _ := newTestCache(t, cfg, origin)
+
+	get(t, c, "/", "Accept-Language", "en")
+	get(t, c, "/", "Accept-Language", "es")
+	_, en := get(t, c, "/", "Accept-Language", "en")
+	_, es := get(t, c, "/", "Accept-Language", "es")
+
+	if en != "lang:en" || es != "lang:es" {
+		t.Errorf("GET /\nExpected a variant per language, but got: %q and %q", en, es)
+	}
+	if len(origin.requests) != 2 {
+		t.Errorf("GET /\nExpected 2 requests to the origin, but got: %d", len(origin.requests))
+	}
+}
+
+// TestCacheTransport_StaleIfError checks that stale responses are served when
+// the target fails, only within the allowed staleness.
+func TestCacheTransport_StaleIfError(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name          string        // Name of the test case
+		stale         time.Duration // Configured maximum staleness
+		cacheControl  string        // Cache-Control of the stored response
+		failure       error         // Error of the target, if any
+		status        int           // Status of the target otherwise
+		expectedCache string        // Expected X-Cache, empty if not served from the cache
+	}{
+		{
+			name:          "configured_staleness_on_error",
+			stale:         time.Hour,
+			cacheControl:  "max-age=60",
+			failure:       errors.New("proxyconnect tcp: connection refused"),
+			expectedCache: "STALE",
+		},
+		{
+			name:          "configured_staleness_on_server_error",
+			stale:         time.Hour,
+			cacheControl:  "max-age=60",
+			status:        http.StatusBadGateway,
+			expectedCache: "STALE",
+		},
+		{
+			name:          "directive_staleness",
+			cacheControl:  "max-age=60, stale-if-error=3600",
+			failure:       errors.New("connection refused"),
+			expectedCache: "STALE",
+		},
+		{
+			name:         "too_stale",
+			stale:        time.Minute,
+			cacheControl: "max-age=60",
+			failure:      errors.New("connection refused"),
+		},
+		{
+			name:         "disabled",
+			cacheControl: "max-age=60",
+			failure:      errors.New("connection refused"),
+		},
+		{
+			name:         "must_revalidate",
+			stale:        time.Hour,
+			cacheControl: "max-age=60, must-revalidate",
+			failure:      errors.New("connection refused"),
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults.Cache
+			cfg.Store = config.CacheStoreMemory
+			cfg.Stale = tt.stale
+
+			failing := false
+			origin := &testOrigin{respond: func(*http.Request) (*http.Response, error) {
+				if !failing {
+					return newTestResponse(http.StatusOK, "cached", "Cache-Control", tt.cacheControl), nil
+				}
+				if tt.failure != nil {
+					return nil, tt.failure
+				}
+				return newTestResponse(tt.status, "failed"), nil
+			}}
+			c, now := newTestCache(t, cfg, origin)
+
+			get(t, c, "/")
+			failing = true
+			*now = now.Add(30 * time.Minute)
+
+			req := httptest.NewRequest(http.MethodGet, "http://target.example.com/", nil)
+			resp, err := c.RoundTrip(req)
+			if tt.expectedCache == "" {
+				if err == nil && resp.Header.Get(cacheHeader) == "STALE" {
+					t.Error("GET /\nExpected the failure of the target, but got a stale response")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET /\nExpected a stale response, but got: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if got := resp.Header.Get(cacheHeader); got != tt.expectedCache || string(body) != "cached" {
+				t.Errorf("GET /\nExpected %s %q, but got: %s %q", tt.expectedCache, "cached", got, body)
+			}
+			if got := c.requests.Value(cacheStale); got != 1 {
+				t.Errorf("prxy_cache_requests_total{outcome=\"stale\"}\nExpected 1, but got: %v", got)
+			}
+		})
+	}
+}
+
+// TestCacheTransport_Invalidation checks that unsafe requests invalidate the
+// stored responses of their URL.
+func TestCacheTransport_Invalidation(t *testing.T) {
+	cfg := config.Defaults.Cache
+	cfg.Store = config.CacheStoreMemory
+
+	origin := &testOrigin{respond: func(*http.Request) (*http.Response, error) {
+		return newTestResponse(http.StatusOK, "ok", "Cache-Control", "max-age=60"), nil
+	}}
+	c, _ := newTestCache(t, cfg, origin)
+
+	get(t, c, "/item")
+	resp, err := c.RoundTrip(httptest.NewRequest(http.MethodPut, "http://target.example.com/item", strings.NewReader("new")))
+	if err != nil {
+		t.Fatalf("PUT /item failed: %v", err)
+	}
+	resp.Body.Close() //nolint:errcheck
+
+	if resp, _ := get(t, c, "/item"); resp.Header.Get(cacheHeader) != "MISS" {
+		t.Errorf("GET /item\nExpected %s MISS after a PUT, but got: %q", cacheHeader, resp.Header.Get(cacheHeader))
+	}
+}
+
+// TestCacheTransport_Backends checks that the responses of every backend are
+// stored apart, and that unsafe requests invalidate them all.
+func TestCacheTransport_Backends(t *testing.T) {
+	cfg := config.Defaults.Cache
+	cfg.Store = config.CacheStoreMemory
+
+	lb := newTestBalancer(t, config.Defaults.Balance, 9, 1)
+	stable, canary := lb.backends[0], lb.backends[1]
+	origin := &testOrigin{respond: func(req *http.Request) (*http.Response, error) {
+		return newTestResponse(http.StatusOK, req.Host, "Cache-Control", "max-age=60"), nil
+	}}
+	c, err := newCacheTransport(cfg, origin, lb.backends, newTestLogger(t), metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("newCacheTransport() failed: %v", err)
+	}
+
+	// send sends a request directed to the backend through the transport.
+	send := func(method string, b *backend) (*http.Response, string) {
+		req := httptest.NewRequest(method, "/app.js", nil)
+		req = req.WithContext(context.WithValue(req.Context(), backendContextKey{}, b))
+		lb.direct(req)
+		resp, err := c.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s /app.js failed: %v", method, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close() //nolint:errcheck
+		return resp, string(body)
+	}
+
+	send(http.MethodGet, stable)
+	if resp, body := send(http.MethodGet, canary); resp.Header.Get(cacheHeader) != "MISS" || body != "backend1.example.com" {
+		t.Errorf("GET /app.js\nExpected a MISS from the canary, but got: %s %q", resp.Header.Get(cacheHeader), body)
+	}
+	if resp, body := send(http.MethodGet, stable); resp.Header.Get(cacheHeader) != "HIT" || body != "backend0.example.com" {
+		t.Errorf("GET /app.js\nExpected a HIT from the stable backend, but got: %s %q", resp.Header.Get(cacheHeader), body)
+	}
+
+	send(http.MethodPost, canary)
+	for _, b := range lb.backends {
+		if resp, _ := send(http.MethodGet, b); resp.Header.Get(cacheHeader) != "MISS" {
+			t.Errorf("GET /app.js\nExpected a MISS from %s after a POST, but got: %s", b.label, resp.Header.Get(cacheHeader))
+		}
+	}
+}
+
+// TestCacheTransport_Limits checks that responses larger than the object
+// limit are not stored, and that the least recently used ones are evicted.
+func TestCacheTransport_Limits(t *testing.T) {
+	cfg := config.Defaults.Cache
+	cfg.Store = config.CacheStoreMemory
+	cfg.Object.Max = 10
+
+	origin := &testOrigin{respond: func(req *http.Request) (*http.Response, error) {
+		resp := newTestResponse(http.StatusOK, strings.Repeat("x", len(req.URL.Path)), "Cache-Control", "max-age=60")
+		resp.ContentLength = -1 // Unknown length, checked while reading
+		return resp, nil
+	}}
+	c, _ := newTestCache(t, cfg, origin)
+
+	get(t, c, "/too-large-to-be-cached")
+	get(t, c, "/small")
+	get(t, c, "/too-large-to-be-cached")
+	get(t, c, "/small")
+
+	if len(origin.requests) != 3 {
+		t.Errorf("GET\nExpected 3 requests to the origin, but got: %d", len(origin.requests))
+	}
+	if got := c.entries.Value(); got != 1 {
+		t.Errorf("prxy_cache_entries\nExpected 1, but got: %v", got)
+	}
+}
+
+// TestCacheTransport_Disk checks that responses stored on disk survive a
+// restart.
+func TestCacheTransport_Disk(t *testing.T) {
+	cfg := config.Defaults.Cache
+	cfg.Store = config.CacheStoreDisk
+	cfg.Path = t.TempDir()
+
+	origin := &testOrigin{respond: func(*http.Request) (*http.Response, error) {
+		return newTestResponse(http.StatusOK, "persisted", "Cache-Control", "max-age=60"), nil
+	}}
+	first, _ := newTestCache(t, cfg, origin)
+	get(t, first, "/")
+
+	second, _ := newTestCache(t, cfg, origin)
+	resp, body := get(t, second, "/")
+	if got := resp.Header.Get(cacheHeader); got != "HIT" || body != "persisted" {
+		t.Errorf("GET /\nExpected HIT %q, but got: %s %q", "persisted", got, body)
+	}
+}
@@ -0,0 +1,118 @@
+package prxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// backendChecker probes every backend of a balancer periodically and keeps
+// the ones that fail out of the pool until they pass again.
+type backendChecker struct {
+	logger *logging.Logger
+	cfg    config.BalanceCheck
+	lb     *balancer
+	client *http.Client
+}
+
+// newBackendChecker creates a backendChecker that uses the given transport to
+// probe the backends.
+func newBackendChecker(cfg config.BalanceCheck, lb *balancer, transport http.RoundTripper, logger *logging.Logger) *backendChecker {
+	return &backendChecker{
+		logger: logger,
+		cfg:    cfg,
+		lb:     lb,
+		client: &http.Client{
+			Transport: transport,
+			// Report the probe status as is instead of following redirects.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// run probes the backends right away and then at the configured interval,
+// until done is closed.
+func (bc *backendChecker) run(done <-chan struct{}) {
+	ticker := time.NewTicker(bc.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		bc.checkAll()
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// checkAll probes every backend concurrently and updates their health.
+func (bc *backendChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range bc.lb.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := bc.check(b)
+			healthy := err == nil
+			if b.healthy.Swap(healthy) == healthy {
+				return
+			}
+
+			if healthy {
+				bc.logger.Info("Backend health check recovered", "backend", b.label)
+			} else {
+				bc.logger.Warn("Backend health check failed", "backend", b.label, "error", err)
+			}
+			if b.up(bc.lb.now()) {
+				bc.lb.up.Set(1, b.label)
+			} else {
+				bc.lb.up.Set(0, b.label)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// check ensures the probe path on the backend returns the expected status
+// code.
+func (bc *backendChecker) check(b *backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bc.cfg.Timeout)
+	defer cancel()
+
+	ref, err := url.Parse(bc.cfg.Path)
+	if err != nil {
+		return err
+	}
+	probeURL := b.url.JoinPath(ref.Path)
+	probeURL.RawQuery = ref.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := bc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()               //nolint:errcheck
+	_, _ = io.Copy(io.Discard, resp.Body) // Allow the connection to be reused
+
+	if resp.StatusCode != bc.cfg.Status {
+		return fmt.Errorf("GET %s returned %q, expected %d", ref, resp.Status, bc.cfg.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package prxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// coalescedCall is a round trip shared by identical concurrent requests.
+type coalescedCall struct {
+	done   chan struct{} // Closed once the outcome is known
+	header http.Header   // Headers of the leading request, compared on Vary
+	shared bool          // Whether the outcome can be used by the waiting requests
+	resp   *http.Response
+	body   []byte
+	err    error
+}
+
+// coalesceTransport is an http.RoundTripper that collapses identical
+// concurrent requests into a single round trip to the target.
+//
+// The first request is sent to the target and its response is streamed back
+// as it arrives, while the identical ones that arrive before it completes wait
+// for a copy of the whole response. Responses that are too large to be
+// buffered, streamed, private or varying on headers that differ are not
+// shared, and the waiting requests are sent on their own instead.
+type coalesceTransport struct {
+	next      http.RoundTripper
+	cfg       config.CoalesceConfig
+	logger    *logging.Logger
+	mu        sync.Mutex                // Guards calls
+	calls     map[string]*coalescedCall // Round trips in flight, by key
+	coalesced *metrics.Counter          // Requests served with the response of another one
+	waiting   *metrics.Gauge            // Requests waiting for an identical one
+}
+
+// newCoalesceTransport creates a coalesceTransport that sends the requests to
+// next.
+func newCoalesceTransport(cfg config.CoalesceConfig, next http.RoundTripper, logger *logging.Logger, registry *metrics.Registry) *coalesceTransport {
+	return &coalesceTransport{
+		next:      next,
+		cfg:       cfg,
+		logger:    logger,
+		calls:     make(map[string]*coalescedCall),
+		coalesced: registry.Counter("prxy_coalesced_requests_total", "Total number of requests served with the response of an identical concurrent request."),
+		waiting:   registry.Gauge("prxy_coalesce_waiting_requests", "Number of requests waiting for the response of an identical request."),
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *coalesceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.eligible(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	key := t.key(req)
+
+	t.mu.Lock()
+	if call, ok := t.calls[key]; ok {
+		t.mu.Unlock()
+		return t.wait(req, call)
+	}
+	call := &coalescedCall{done: make(chan struct{}), header: req.Header.Clone()}
+	t.calls[key] = call
+	t.mu.Unlock()
+
+	return t.lead(req, key, call)
+}
+
+// lead sends the request to the target on behalf of the waiting requests. The
+// outcome is recorded in the call once known: right away if the response
+// cannot be shared, or once its body has been read otherwise.
+func (t *coalesceTransport) lead(req *http.Request, key string, call *coalescedCall) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		// The waiting requests must not fail because this client went away.
+		call.err = err
+		t.finish(key, call, req.Context().Err() == nil)
+		return nil, err
+	}
+
+	if !shareable(resp, t.cfg.Buffer) {
+		t.finish(key, call, false)
+		return resp, nil
+	}
+
+	// Keep a copy of the response, since the caller owns and modifies it.
+	call.resp = new(http.Response)
+	*call.resp = *resp
+	call.resp.Header = resp.Header.Clone()
+	if resp.Body == nil || resp.Body == http.NoBody {
+		t.finish(key, call, true)
+		return resp, nil
+	}
+
+	resp.Body = &coalescedBody{
+		ReadCloser: resp.Body,
+		limit:      t.cfg.Buffer,
+		done: func(body []byte, complete bool) {
+			call.body = body
+			call.resp.Trailer = resp.Trailer.Clone()
+			t.finish(key, call, complete)
+		},
+	}
+
+	return resp, nil
+}
+
+// finish records whether the outcome of the call can be shared, releasing the
+// waiting requests. Later requests start a new call.
+func (t *coalesceTransport) finish(key string, call *coalescedCall, shared bool) {
+	t.mu.Lock()
+	delete(t.calls, key)
+	t.mu.Unlock()
+
+	call.shared = shared
+	close(call.done)
+}
+
+// wait waits for the outcome of the call, sending the request on its own if
+// the outcome cannot be shared.
+func (t *coalesceTransport) wait(req *http.Request, call *coalescedCall) (*http.Response, error) {
+	t.waiting.Inc()
+	select {
+	case <-call.done:
+		t.waiting.Dec()
+	case <-req.Context().Done():
+		t.waiting.Dec()
+		return nil, req.Context().Err()
+	}
+
+	if !call.shared || (call.err == nil && !sameVariant(call.resp.Header, call.header, req.Header)) {
+		return t.next.RoundTrip(req)
+	}
+
+	t.coalesced.Inc()
+	t.logger.Debug("Coalesced request", "method", req.Method, "url", req.URL.Redacted())
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	resp := new(http.Response)
+	*resp = *call.resp
+	resp.Header = call.resp.Header.Clone()
+	resp.Trailer = call.resp.Trailer.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(call.body))
+	resp.Request = req
+
+	return resp, nil
+}
+
+// eligible reports whether the request can be coalesced: it is a cacheable
+// request without body, and coalescing is enabled for its route.
+func (t *coalesceTransport) eligible(req *http.Request) bool {
+	enabled := t.cfg.Enabled
+	if route, ok := requestRoute(req); ok && route.Coalesce != nil {
+		enabled = *route.Coalesce
+	}
+	if !enabled {
+		return false
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	if req.Header.Get("Upgrade") != "" {
+		return false
+	}
+
+	return !parseCacheControl(req.Header).has("no-store")
+}
+
+// key returns the key identifying the identical requests: the backend chosen
+// for them, the method, the URI requested by the client and the values of the
+// configured headers. Backends may serve different content, such as the
+// versions of a canary release, so only the requests sent to the same one are
+// identical.
+func (t *coalesceTransport) key(req *http.Request) string {
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+
+	var key strings.Builder
+	if b, ok := req.Context().Value(backendContextKey{}).(*backend); ok {
+		key.WriteString(b.id)
+		key.WriteByte(' ')
+	}
+	key.WriteString(req.Method)
+	key.WriteByte(' ')
+	key.WriteString(uri)
+	for _, name := range t.cfg.Headers {
+		key.WriteByte('\n')
+		key.WriteString(http.CanonicalHeaderKey(name))
+		key.WriteByte(':')
+		key.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return key.String()
+}
+
+// shareable reports whether the response can be shared with other clients:
+// its body fits in the buffer, it is not a stream of events and it is not
+// meant for a single client.
+func shareable(resp *http.Response, limit int64) bool {
+	if resp.ContentLength > limit {
+		return false
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" || resp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	return !cc.has("private") && !cc.has("no-store")
+}
+
+// sameVariant reports whether two requests carry the same values of the
+// headers named in the Vary header of the response.
+func sameVariant(respHeader, a, b http.Header) bool {
+	variant := &cacheEntry{Vary: varyValues(respHeader, a)}
+	return variant.sameVariant(&cacheEntry{Vary: varyValues(respHeader, b)})
+}
+
+// coalescedBody is an io.ReadCloser that streams the response body to the
+// leading request, keeping a copy for the waiting requests. It calls done
+// once, with whether the whole body was read within limit bytes.
+type coalescedBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+	done  func(body []byte, complete bool)
+}
+
+// Read implements the io.Reader interface.
+func (b *coalescedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.done == nil {
+		return n, err
+	}
+
+	if int64(b.buf.Len()+n) > b.limit {
+		b.end(false)
+	} else {
+		b.buf.Write(p[:n])
+		if err == io.EOF {
+			b.end(true)
+		} else if err != nil {
+			b.end(false)
+		}
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface. The waiting requests are sent on
+// their own if the body was not read in full.
+func (b *coalescedBody) Close() error {
+	b.end(false)
+	return b.ReadCloser.Close()
+}
+
+// end reports the outcome of the body, if not done yet.
+func (b *coalescedBody) end(complete bool) {
+	if b.done == nil {
+		return
+	}
+	done := b.done
+	b.done = nil
+	if complete {
+		done(b.buf.Bytes(), true)
+	} else {
+		done(nil, false)
+	}
+}
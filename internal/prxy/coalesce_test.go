@@ -0,0 +1,415 @@
+package prxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// blockingOrigin is a fake target that holds every request until released,
+// counting the requests it receives.
+type blockingOrigin struct {
+	calls   atomic.Int32
+	release chan struct{}
+	respond func(req *http.Request) (*http.Response, error)
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (o *blockingOrigin) RoundTrip(req *http.Request) (*http.Response, error) {
+	o.calls.Add(1)
+	<-o.release
+	return o.respond(req)
+}
+
+// coalesceTestResult is the outcome of a request sent by sendConcurrently.
+type coalesceTestResult struct {
+	body string
+	err  error
+}
+
+// sendConcurrently sends the requests through the transport at the same time,
+// releasing the origin once the expected number of requests are waiting for
+// another one, and all the others have reached the origin.
+func sendConcurrently(t *testing.T, c *coalesceTransport, origin *blockingOrigin, waiting int, reqs ...*http.Request) []coalesceTestResult {
+	t.Helper()
+
+	results := make([]coalesceTestResult, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.RoundTrip(req)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint:errcheck
+			results[i] = coalesceTestResult{body: string(body), err: err}
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.waiting.Value() < float64(waiting) || int(origin.calls.Load())+waiting < len(reqs) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d waiting requests and %d at the origin, but got: %v and %d", waiting, len(reqs)-waiting, c.waiting.Value(), origin.calls.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(origin.release)
+	wg.Wait()
+
+	return results
+}
+
+// newCoalesceRequest creates a GET request with the given headers, as
+// name/value pairs.
+func newCoalesceRequest(path string, header ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://target.example.com"+path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+	return req
+}
+
+// newBackendRequest creates a GET request for the path, as rewritten by the
+// director to the backend with the given host.
+func newBackendRequest(host, path string) *http.Request {
+	req := newCoalesceRequest(path)
+	req = req.WithContext(context.WithValue(req.Context(), backendContextKey{}, &backend{id: host}))
+	req.RequestURI = path
+	req.URL.Host = host
+	req.Host = host
+	return req
+}
+
+// TestCoalesceTransport_RoundTrip checks which concurrent requests share a
+// single round trip.
+func TestCoalesceTransport_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		buffer    int64 // Maximum shared body size
+		reqs      []*http.Request
+		respond   func(req *http.Request) (*http.Response, error)
+		waiting   int   // Requests expected to wait for the first one
+		calls     int32 // Round trips expected to reach the origin
+		coalesced float64
+		err       bool
+	}{
+		{
+			name:      "identical_requests",
+			reqs:      []*http.Request{newCoalesceRequest("/data"), newCoalesceRequest("/data"), newCoalesceRequest("/data")},
+			respond:   func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			waiting:   2,
+			calls:     1,
+			coalesced: 2,
+		},
+		{
+			name:      "different_headers",
+			reqs:      []*http.Request{newCoalesceRequest("/data", "Accept", "text/html"), newCoalesceRequest("/data", "Accept", "application/json")},
+			respond:   func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			waiting:   0,
+			calls:     2,
+			coalesced: 0,
+		},
+		{
+			name:      "different_urls",
+			reqs:      []*http.Request{newCoalesceRequest("/data?page=1"), newCoalesceRequest("/data?page=2")},
+			respond:   func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			waiting:   0,
+			calls:     2,
+			coalesced: 0,
+		},
+		{
+			name:      "same_backend",
+			reqs:      []*http.Request{newBackendRequest("backend-a.example.com", "/data"), newBackendRequest("backend-a.example.com", "/data")},
+			respond:   func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			waiting:   1,
+			calls:     1,
+			coalesced: 1,
+		},
+		{
+			name:      "different_backends",
+			reqs:      []*http.Request{newBackendRequest("backend-a.example.com", "/data"), newBackendRequest("backend-b.example.com", "/data")},
+			respond:   func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			waiting:   0,
+			calls:     2,
+			coalesced: 0,
+		},
+		{
+			name: "different_vary_headers",
+			reqs: []*http.Request{newCoalesceRequest("/data", "X-Tenant", "a"), newCoalesceRequest("/data", "X-Tenant", "b")},
+			respond: func(*http.Request) (*http.Response, error) {
+				return newTestResponse(http.StatusOK, "shared", "Vary", "X-Tenant"), nil
+			},
+			waiting:   1,
+			calls:     2,
+			coalesced: 0,
+		},
+		{
+			name:      "too_large",
+			buffer:    4,
+			reqs:      []*http.Request{newCoalesceRequest("/data"), newCoalesceRequest("/data")},
+			respond:   func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			waiting:   1,
+			calls:     2,
+			coalesced: 0,
+		},
+		{
+			name: "private",
+			reqs: []*http.Request{newCoalesceRequest("/data"), newCoalesceRequest("/data")},
+			respond: func(*http.Request) (*http.Response, error) {
+				return newTestResponse(http.StatusOK, "shared", "Cache-Control", "private"), nil
+			},
+			waiting:   1,
+			calls:     2,
+			coalesced: 0,
+		},
+		{
+			name:      "shared_error",
+			reqs:      []*http.Request{newCoalesceRequest("/data"), newCoalesceRequest("/data")},
+			respond:   func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") },
+			waiting:   1,
+			calls:     1,
+			coalesced: 1,
+			err:       true,
+		},
+		{
+			name:      "no_store",
+			reqs:      []*http.Request{newCoalesceRequest("/data", "Cache-Control", "no-store"), newCoalesceRequest("/data", "Cache-Control", "no-store")},
+			respond:   func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			waiting:   0,
+			calls:     2,
+			coalesced: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults.Coalesce
+			cfg.Enabled = true
+			if tt.buffer > 0 {
+				cfg.Buffer = tt.buffer
+			}
+			origin := &blockingOrigin{release: make(chan struct{}), respond: tt.respond}
+			c := newCoalesceTransport(cfg, origin, newTestLogger(t), metrics.NewRegistry())
+
+			for _, result := range sendConcurrently(t, c, origin, tt.waiting, tt.reqs...) {
+				if (result.err != nil) != tt.err {
+					t.Errorf("RoundTrip()\nExpected error: %v\nGot: %v", tt.err, result.err)
+				}
+				if !tt.err && result.body != "shared" {
+					t.Errorf("RoundTrip()\nExpected body %q, but got: %q", "shared", result.body)
+				}
+			}
+			if got := origin.calls.Load(); got != tt.calls {
+				t.Errorf("Expected %d round trips to the origin, but got: %d", tt.calls, got)
+			}
+			if got := c.coalesced.Value(); got != tt.coalesced {
+				t.Errorf("Expected %v coalesced requests, but got: %v", tt.coalesced, got)
+			}
+		})
+	}
+}
+
+// TestCoalesceTransport_Stream checks that the response is streamed to the
+// first request as it arrives, and shared with the waiting requests once it
+// is complete.
+func TestCoalesceTransport_Stream(t *testing.T) {
+	pr, pw := io.Pipe()
+	origin := &blockingOrigin{
+		release: make(chan struct{}),
+		respond: func(*http.Request) (*http.Response, error) {
+			resp := newTestResponse(http.StatusOK, "")
+			resp.Body, resp.ContentLength = pr, -1
+			return resp, nil
+		},
+	}
+	close(origin.release)
+	cfg := config.Defaults.Coalesce
+	cfg.Enabled = true
+	c := newCoalesceTransport(cfg, origin, newTestLogger(t), metrics.NewRegistry())
+
+	resp, err := c.RoundTrip(newCoalesceRequest("/events"))
+	if err != nil {
+		t.Fatalf("RoundTrip() failed: %v", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	waiter := make(chan coalesceTestResult, 1)
+	go func() {
+		resp, err := c.RoundTrip(newCoalesceRequest("/events"))
+		if err != nil {
+			waiter <- coalesceTestResult{err: err}
+			return
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close() //nolint:errcheck
+		waiter <- coalesceTestResult{body: string(body), err: err}
+	}()
+
+	// The first part must reach the first request while the target is still
+	// writing the rest.
+	go func() { _, _ = io.WriteString(pw, "first") }()
+	buf := make([]byte, len("first"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil || string(buf) != "first" {
+		t.Fatalf("Expected the first part to be streamed, but got: %q (%v)", buf, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.waiting.Value() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected 1 waiting request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, _ = io.WriteString(pw, "-last")
+		pw.Close() //nolint:errcheck
+	}()
+	if rest, err := io.ReadAll(resp.Body); err != nil || string(rest) != "-last" {
+		t.Fatalf("Expected the rest of the body, but got: %q (%v)", rest, err)
+	}
+
+	result := <-waiter
+	if result.err != nil || result.body != "first-last" {
+		t.Errorf("Expected the waiting request to get %q, but got: %q (%v)", "first-last", result.body, result.err)
+	}
+	if got := origin.calls.Load(); got != 1 {
+		t.Errorf("Expected 1 round trip to the origin, but got: %d", got)
+	}
+}
+
+// TestCoalesceTransport_Routes checks that routes override the global
+// setting.
+func TestCoalesceTransport_Routes(t *testing.T) {
+	enabled, disabled := true, false
+	routes := []config.RouteConfig{
+		{Path: "/api", Coalesce: &enabled},
+		{Path: "/api/live", Coalesce: &disabled},
+	}
+
+	tests := []struct {
+		name   string
+		global bool
+		path   string
+		calls  int32
+	}{
+		{name: "enabled_by_route", global: false, path: "/api/users", calls: 1},
+		{name: "disabled_by_longer_route", global: false, path: "/api/live/feed", calls: 2},
+		{name: "global_without_route", global: true, path: "/static/app.js", calls: 1},
+		{name: "disabled_without_route", global: false, path: "/static/app.js", calls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults.Coalesce
+			cfg.Enabled = tt.global
+			origin := &blockingOrigin{
+				release: make(chan struct{}),
+				respond: func(*http.Request) (*http.Response, error) { return newTestResponse(http.StatusOK, "shared"), nil },
+			}
+			c := newCoalesceTransport(cfg, origin, newTestLogger(t), metrics.NewRegistry())
+
+			// Let the router store the route in the context of the requests.
+			var reqs []*http.Request
+			handler := newRouter(routes).wrap(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				reqs = append(reqs, req)
+			}))
+			for range 2 {
+				handler.ServeHTTP(httptest.NewRecorder(), newCoalesceRequest(tt.path))
+			}
+
+			waiting := 0
+			if tt.calls == 1 {
+				waiting = 1
+			}
+			for _, result := range sendConcurrently(t, c, origin, waiting, reqs...) {
+				if result.err != nil || result.body != "shared" {
+					t.Errorf("RoundTrip()\nExpected body %q, but got: %q (%v)", "shared", result.body, result.err)
+				}
+			}
+			if got := origin.calls.Load(); got != tt.calls {
+				t.Errorf("Expected %d round trips to the origin, but got: %d", tt.calls, got)
+			}
+		})
+	}
+}
+
+// TestNew_Coalesce checks that identical concurrent requests through the
+// proxy reach the target once.
+func TestNew_Coalesce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		<-release
+		rw.Write([]byte("dashboard")) //nolint:errcheck
+	}))
+	defer target.Close()
+
+	tp := newTestProxy(t)
+	cfg := newTestConfig(target.URL, tp.URL)
+	cfg.Coalesce.Enabled = true
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	server := httptest.NewServer(prxy.server.Handler)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 3)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL + "/dashboard")
+			if err != nil {
+				t.Errorf("GET failed: %v", err)
+				return
+			}
+			defer resp.Body.Close() //nolint:errcheck
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+		}()
+	}
+
+	// Wait for the first request to reach the target and the others to queue
+	// up behind it.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_prxy/metrics", nil))
+		if strings.Contains(rec.Body.String(), "prxy_coalesce_waiting_requests 2") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected 2 waiting requests")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	for _, body := range bodies {
+		if body != "dashboard" {
+			t.Errorf("Expected body %q, but got: %q", "dashboard", body)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected 1 request to the target, but got: %d", got)
+	}
+}
@@ -0,0 +1,277 @@
+package prxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// errorClass identifies the cause of a request that could not be proxied.
+type errorClass string
+
+// Error classes.
+const (
+	errorClassProxyUnreachable errorClass = "proxy-unreachable" // The outbound proxy cannot be reached
+	errorClassProxyAuth        errorClass = "proxy-auth-failed" // The outbound proxy rejected the credentials
+	errorClassConnectRefused   errorClass = "connect-refused"   // The outbound proxy refused the tunnel
+	errorClassDNS              errorClass = "dns"               // A host name could not be resolved
+	errorClassTLS              errorClass = "tls"               // The TLS handshake with the target failed
+	errorClassTimeout          errorClass = "timeout"           // A timeout expired
+	errorClassCanceled         errorClass = "client-canceled"   // The client went away
+	errorClassCircuitOpen      errorClass = "circuit-open"      // The circuit breaker is open
+	errorClassReplayUnmatched  errorClass = "replay-unmatched"  // No recorded response matches the request
+	errorClassQueueFull        errorClass = "queue-full"        // Too many requests are waiting for their turn
+	errorClassQueueTimeout     errorClass = "queue-timeout"     // The request waited too long for its turn
+	errorClassBodyTooLarge     errorClass = "body-too-large"    // The request body is too large to be buffered
+	errorClassUpstream         errorClass = "upstream"          // Any other failure
+)
+
+// statusClientClosedRequest is the non-standard status, borrowed from nginx,
+// that is logged for the requests canceled by the client.
+const statusClientClosedRequest = 499
+
+// errorResponse describes what clients are told about an error class. It
+// never includes the underlying error, which is only logged, so that internal
+// addresses are not leaked.
+type errorResponse struct {
+	status int    // Status code of the response
+	title  string // Short summary of the problem
+	detail string // Explanation of the problem
+}
+
+// errorResponses maps every error class to its response.
+var errorResponses = map[errorClass]errorResponse{
+	errorClassProxyUnreachable: {http.StatusBadGateway, "Outbound proxy unreachable", "The outbound proxy could not be reached."},
+	errorClassProxyAuth:        {http.StatusBadGateway, "Outbound proxy authentication failed", "The outbound proxy rejected the configured credentials."},
+	errorClassConnectRefused:   {http.StatusBadGateway, "Tunnel refused", "The outbound proxy refused to open a tunnel to the target."},
+	errorClassDNS:              {http.StatusBadGateway, "Host not found", "A host name could not be resolved."},
+	errorClassTLS:              {http.StatusBadGateway, "TLS handshake failed", "A secure connection to the target could not be established."},
+	errorClassTimeout:          {http.StatusGatewayTimeout, "Upstream timeout", "The target did not respond in time."},
+	errorClassCanceled:         {statusClientClosedRequest, "Client closed request", "The client closed the request before the target responded."},
+	errorClassCircuitOpen:      {http.StatusServiceUnavailable, "Target unavailable", "The target is temporarily unavailable, try again later."},
+	errorClassReplayUnmatched:  {http.StatusBadGateway, "No recorded response", "No recorded response matches the request."},
+	errorClassQueueFull:        {http.StatusServiceUnavailable, "Too many requests", "Too many requests are waiting for the target, try again later."},
+	errorClassQueueTimeout:     {http.StatusServiceUnavailable, "Queue timeout", "The request waited too long for its turn to reach the target."},
+	errorClassBodyTooLarge:     {http.StatusRequestEntityTooLarge, "Request body too large", "The request body exceeds the size the proxy accepts."},
+	errorClassUpstream:         {http.StatusBadGateway, "Upstream error", "The request could not be forwarded to the target."},
+}
+
+// proxyStatusError is returned when the outbound proxy answers with an error
+// status instead of forwarding the request, such as a refused CONNECT.
+type proxyStatusError struct {
+	statusCode int    // Status code answered by the proxy
+	status     string // Status line answered by the proxy
+}
+
+// Error implements the error interface.
+func (e *proxyStatusError) Error() string {
+	return fmt.Sprintf("outbound proxy answered %q", e.status)
+}
+
+// classifyError returns the class of an error returned by the reverse proxy.
+func classifyError(req *http.Request, err error) errorClass {
+	var (
+		openErr   *circuitOpenError
+		statusErr *proxyStatusError
+		dnsErr    *net.DNSError
+		opErr     *net.OpError
+		netErr    net.Error
+	)
+
+	switch {
+	case errors.Is(req.Context().Err(), context.Canceled):
+		return errorClassCanceled
+	case errors.As(err, &openErr):
+		return errorClassCircuitOpen
+	case errors.Is(err, errReplayUnmatched):
+		return errorClassReplayUnmatched
+	case errors.Is(err, errQueueFull):
+		return errorClassQueueFull
+	case errors.Is(err, errQueueTimeout):
+		return errorClassQueueTimeout
+	case errors.Is(err, errBodyTooLarge):
+		return errorClassBodyTooLarge
+	case errors.As(err, &statusErr):
+		if statusErr.statusCode == http.StatusProxyAuthRequired {
+			return errorClassProxyAuth
+		}
+		return errorClassConnectRefused
+	case errors.As(err, &dnsErr):
+		return errorClassDNS
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+		// Checked before timeouts, which may come from the proxy dial.
+		return errorClassProxyUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errorClassTimeout
+	case isTLSError(err):
+		return errorClassTLS
+	}
+
+	return errorClassUpstream
+}
+
+// isTLSError reports whether the error comes from a TLS handshake.
+func isTLSError(err error) bool {
+	var (
+		verificationErr *tls.CertificateVerificationError
+		recordErr       tls.RecordHeaderError
+		alertErr        tls.AlertError
+		authorityErr    x509.UnknownAuthorityError
+		hostnameErr     x509.HostnameError
+		invalidErr      x509.CertificateInvalidError
+	)
+
+	return errors.As(err, &verificationErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+// defaultErrorTemplate is the HTML page used when no template is configured.
+const defaultErrorTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Status}} {{.Title}}</title>
+</head>
+<body>
+<h1>{{.Status}} {{.Title}}</h1>
+<p>{{.Detail}}</p>
+</body>
+</html>
+`
+
+// errorPage is the data passed to the HTML error template.
+type errorPage struct {
+	Status     int    // Status code of the response
+	StatusText string // Standard text of the status code
+	Class      string // Error class, such as "timeout"
+	Title      string // Short summary of the problem
+	Detail     string // Explanation of the problem
+}
+
+// problemDetails is an RFC 7807 problem details object.
+type problemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// errorResponder is the error handler of the reverse proxy. It logs the
+// error and answers the client with a response in the configured format.
+type errorResponder struct {
+	logger   *logging.Logger
+	format   config.ErrorFormat
+	template *template.Template // HTML template, nil unless the format is html
+}
+
+// newErrorResponder creates an errorResponder, loading the HTML template if
+// needed.
+func newErrorResponder(cfg config.ErrorConfig, logger *logging.Logger) (*errorResponder, error) {
+	er := &errorResponder{
+		logger: logger,
+		format: cfg.Format,
+	}
+
+	if cfg.Format == config.ErrorFormatHTML {
+		var err error
+		if cfg.Template != "" {
+			er.template, err = template.ParseFiles(cfg.Template)
+		} else {
+			er.template, err = template.New("error").Parse(defaultErrorTemplate)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load error template: %w", err)
+		}
+	}
+
+	return er, nil
+}
+
+// ServeError handles an error returned by the reverse proxy.
+func (er *errorResponder) ServeError(rw http.ResponseWriter, req *http.Request, err error) {
+	class := classifyError(req, err)
+	response := errorResponses[class]
+
+	// Nobody is waiting for the response of a canceled request.
+	if class == errorClassCanceled {
+		er.logger.Info("Client canceled request", "url", req.URL.String(), "status", response.status, "error", err)
+		return
+	}
+
+	er.logger.Error("Reverse proxy error", "url", req.URL.String(), "class", class, "status", response.status, "error", err)
+
+	var openErr *circuitOpenError
+	if errors.As(err, &openErr) {
+		rw.Header().Set("Retry-After", openErr.RetryAfter())
+	}
+
+	// gRPC clients only understand errors reported as a gRPC status.
+	if isGRPC(req) {
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Grpc-Status", strconv.Itoa(grpcStatus(class)))
+		rw.Header().Set("Grpc-Message", response.detail)
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	contentType, body := er.render(class, response)
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(response.status)
+	_, _ = rw.Write(body)
+}
+
+// grpcStatus returns the gRPC status code that reports an error class to
+// gRPC clients.
+func grpcStatus(class errorClass) int {
+	switch class {
+	case errorClassTimeout:
+		return grpcDeadlineExceeded
+	case errorClassQueueFull:
+		return grpcResourceExhausted
+	}
+	return grpcUnavailable
+}
+
+// render returns the content type and body of the response, falling back to
+// plain text if the HTML template fails.
+func (er *errorResponder) render(class errorClass, response errorResponse) (string, []byte) {
+	switch er.format {
+	case config.ErrorFormatProblem:
+		body, err := json.Marshal(problemDetails{
+			Type:   "urn:prxy:error:" + string(class),
+			Title:  response.title,
+			Status: response.status,
+			Detail: response.detail,
+		})
+		if err == nil {
+			return "application/problem+json", append(body, '\n')
+		}
+	case config.ErrorFormatHTML:
+		var buf bytes.Buffer
+		err := er.template.Execute(&buf, errorPage{
+			Status:     response.status,
+			StatusText: http.StatusText(response.status),
+			Class:      string(class),
+			Title:      response.title,
+			Detail:     response.detail,
+		})
+		if err == nil {
+			return "text/html; charset=utf-8", buf.Bytes()
+		}
+		er.logger.Warn("Failed to render error template", "error", err)
+	}
+
+	return "text/plain; charset=utf-8", []byte("Proxy Error: " + response.detail + "\n")
+}
@@ -0,0 +1,305 @@
+package prxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// TestClassifyError checks the classification of the reverse proxy errors.
+func TestClassifyError(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name     string     // Name of the test case
+		err      error      // Error returned by the reverse proxy
+		canceled bool       // Whether the client canceled the request
+		expected errorClass // Expected error class
+	}{
+		{
+			name:     "proxy_unreachable",
+			err:      &net.OpError{Op: "proxyconnect", Net: "tcp", Err: errors.New("connection refused")},
+			expected: errorClassProxyUnreachable,
+		},
+		{
+			name:     "proxy_auth_failed",
+			err:      &proxyStatusError{statusCode: http.StatusProxyAuthRequired, status: "407 Proxy Authentication Required"},
+			expected: errorClassProxyAuth,
+		},
+		{
+			name:     "connect_refused",
+			err:      &proxyStatusError{statusCode: http.StatusForbidden, status: "403 Forbidden"},
+			expected: errorClassConnectRefused,
+		},
+		{
+			name:     "dns",
+			err:      &net.OpError{Op: "proxyconnect", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "proxy.invalid"}},
+			expected: errorClassDNS,
+		},
+		{
+			name:     "tls",
+			err:      fmt.Errorf("handshake: %w", x509.UnknownAuthorityError{}),
+			expected: errorClassTLS,
+		},
+		{
+			name:     "tls_alert",
+			err:      &net.OpError{Op: "remote error", Err: tls.AlertError(40)},
+			expected: errorClassTLS,
+		},
+		{
+			name:     "tls_record_header",
+			err:      tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"},
+			expected: errorClassTLS,
+		},
+		{
+			name:     "tls_like_message",
+			err:      errors.New("tls: looks like TLS, but is not"),
+			expected: errorClassUpstream,
+		},
+		{
+			name:     "proxy_timeout",
+			err:      &net.OpError{Op: "proxyconnect", Net: "tcp", Err: timeoutError{}},
+			expected: errorClassProxyUnreachable,
+		},
+		{
+			name:     "timeout",
+			err:      &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}},
+			expected: errorClassTimeout,
+		},
+		{
+			name:     "deadline_exceeded",
+			err:      context.DeadlineExceeded,
+			expected: errorClassTimeout,
+		},
+		{
+			name:     "client_canceled",
+			err:      context.Canceled,
+			canceled: true,
+			expected: errorClassCanceled,
+		},
+		{
+			name:     "circuit_open",
+			err:      &circuitOpenError{},
+			expected: errorClassCircuitOpen,
+		},
+		{
+			name:     "replay_unmatched",
+			err:      errReplayUnmatched,
+			expected: errorClassReplayUnmatched,
+		},
+		{
+			name:     "queue_full",
+			err:      errQueueFull,
+			expected: errorClassQueueFull,
+		},
+		{
+			name:     "queue_timeout",
+			err:      errQueueTimeout,
+			expected: errorClassQueueTimeout,
+		},
+		{
+			name:     "body_too_large",
+			err:      errBodyTooLarge,
+			expected: errorClassBodyTooLarge,
+		},
+		{
+			name:     "other",
+			err:      errors.New("unexpected EOF"),
+			expected: errorClassUpstream,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.canceled {
+				cancel()
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+			if got := classifyError(req, tt.err); got != tt.expected {
+				t.Errorf("classifyError(%v)\nExpected %q, but got: %q", tt.err, tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestErrorResponder_ServeError checks the error responses in every format,
+// and that the underlying error is not leaked.
+func TestErrorResponder_ServeError(t *testing.T) {
+	templatePath := filepath.Join(t.TempDir(), "error.html")
+	if err := os.WriteFile(templatePath, []byte("<p>{{.Class}}: {{.StatusText}}</p>"), 0o600); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	err := &net.OpError{Op: "proxyconnect", Net: "tcp", Err: errors.New("dial tcp 10.0.0.1:3128: connection refused")}
+
+	// Test cases
+	tests := []struct {
+		name                string             // Name of the test case
+		config              config.ErrorConfig // The Error configuration
+		expectedContentType string             // Expected content type
+		expectedBody        string             // Expected body
+	}{
+		{
+			name:                "text",
+			config:              config.ErrorConfig{Format: config.ErrorFormatText},
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "Proxy Error: The outbound proxy could not be reached.\n",
+		},
+		{
+			name:                "html_template",
+			config:              config.ErrorConfig{Format: config.ErrorFormatHTML, Template: templatePath},
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        "<p>proxy-unreachable: Bad Gateway</p>",
+		},
+		{
+			name:                "problem",
+			config:              config.ErrorConfig{Format: config.ErrorFormatProblem},
+			expectedContentType: "application/problem+json",
+			expectedBody:        `{"type":"urn:prxy:error:proxy-unreachable","title":"Outbound proxy unreachable","status":502,"detail":"The outbound proxy could not be reached."}` + "\n",
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			er, rerr := newErrorResponder(tt.config, newTestLogger(t))
+			if rerr != nil {
+				t.Fatalf("newErrorResponder() failed: %v", rerr)
+			}
+
+			rec := httptest.NewRecorder()
+			er.ServeError(rec, httptest.NewRequest(http.MethodGet, "/", nil), err)
+
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("Expected status %d, but got: %d", http.StatusBadGateway, rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.expectedContentType {
+				t.Errorf("Expected content type %q, but got: %q", tt.expectedContentType, got)
+			}
+			if rec.Body.String() != tt.expectedBody {
+				t.Errorf("Expected body %q, but got: %q", tt.expectedBody, rec.Body)
+			}
+			if strings.Contains(rec.Body.String(), "10.0.0.1") {
+				t.Error("Expected the internal address not to be leaked, but it was")
+			}
+		})
+	}
+}
+
+// TestErrorResponder_DefaultTemplate checks that the default HTML page is a
+// valid template.
+func TestErrorResponder_DefaultTemplate(t *testing.T) {
+	er, err := newErrorResponder(config.ErrorConfig{Format: config.ErrorFormatHTML}, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("newErrorResponder() failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	er.ServeError(rec, httptest.NewRequest(http.MethodGet, "/", nil), &circuitOpenError{})
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, but got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "<h1>503 Target unavailable</h1>") {
+		t.Errorf("Expected the HTML page to contain the title, but got: %q", rec.Body)
+	}
+}
+
+// TestNew_ErrorClasses checks the classification of real failures through
+// the outbound proxy.
+func TestNew_ErrorClasses(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedURL := "http://" + closed.Addr().String()
+	closed.Close() //nolint:errcheck
+
+	authProxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		rw.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	t.Cleanup(authProxy.Close)
+	refusingProxy := newTestProxy(t)
+	refusingProxy.refuse.Store(true)
+
+	// Test cases
+	tests := []struct {
+		name     string     // Name of the test case
+		target   string     // Target URL
+		proxy    string     // Proxy URL
+		expected errorClass // Expected error class
+	}{
+		{
+			name:     "proxy_unreachable",
+			target:   "https://example.com",
+			proxy:    closedURL,
+			expected: errorClassProxyUnreachable,
+		},
+		{
+			name:     "proxy_auth_failed_on_connect",
+			target:   "https://example.com",
+			proxy:    authProxy.URL,
+			expected: errorClassProxyAuth,
+		},
+		{
+			name:     "proxy_auth_failed_on_forward",
+			target:   "http://example.com",
+			proxy:    authProxy.URL,
+			expected: errorClassProxyAuth,
+		},
+		{
+			name:     "connect_refused",
+			target:   "https://example.com",
+			proxy:    refusingProxy.URL,
+			expected: errorClassConnectRefused,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(tt.target, tt.proxy)
+			cfg.Error.Format = config.ErrorFormatProblem
+			prxy, err := New(cfg, newTestLogger(t))
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var problem problemDetails
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("Expected a problem details body, but got: %q", rec.Body)
+			}
+			if expected := "urn:prxy:error:" + string(tt.expected); problem.Type != expected {
+				t.Errorf("Expected problem type %q, but got: %q", expected, problem.Type)
+			}
+			if expected := errorResponses[tt.expected].status; rec.Code != expected {
+				t.Errorf("Expected status %d, but got: %d", expected, rec.Code)
+			}
+		})
+	}
+}
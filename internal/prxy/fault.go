@@ -0,0 +1,235 @@
+package prxy
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// Kinds of injected faults, reported in the logs and in the metrics.
+const (
+	faultDelay    = "delay"
+	faultAbort    = "abort"
+	faultReset    = "reset"
+	faultTruncate = "truncate"
+)
+
+// matchCondition is a condition of a match expression, with its regular
+// expression compiled.
+type matchCondition struct {
+	config.MatchCondition
+	re *regexp.Regexp // Compiled value of the =~ and !~ operators
+}
+
+// matches reports whether the request meets the condition.
+func (c matchCondition) matches(req *http.Request) bool {
+	var value string
+	switch c.Field {
+	case "method":
+		value = req.Method
+	case "host":
+		value = req.Host
+	case "path":
+		value = req.URL.Path
+	case "query":
+		value = req.URL.RawQuery
+	case "header":
+		value = req.Header.Get(c.Name)
+	}
+
+	switch c.Op {
+	case config.MatchEqual:
+		return value == c.Value
+	case config.MatchNotEqual:
+		return value != c.Value
+	case config.MatchRegexp:
+		return c.re.MatchString(value)
+	case config.MatchNotRegexp:
+		return !c.re.MatchString(value)
+	}
+	return false
+}
+
+// faultRule is a fault along with the conditions of its match expression.
+type faultRule struct {
+	cfg        config.FaultConfig
+	conditions []matchCondition
+}
+
+// newFaultRules compiles the given faults.
+func newFaultRules(faults []config.FaultConfig) ([]*faultRule, error) {
+	rules := make([]*faultRule, 0, len(faults))
+	for _, cfg := range faults {
+		conditions, err := config.ParseMatch(cfg.Match)
+		if err != nil {
+			return nil, err
+		}
+		rule := &faultRule{cfg: cfg}
+		for _, condition := range conditions {
+			compiled := matchCondition{MatchCondition: condition}
+			if condition.Op == config.MatchRegexp || condition.Op == config.MatchNotRegexp {
+				compiled.re = regexp.MustCompile(condition.Value)
+			}
+			rule.conditions = append(rule.conditions, compiled)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matches reports whether the request meets all the conditions of the rule.
+func (r *faultRule) matches(req *http.Request) bool {
+	for _, condition := range r.conditions {
+		if !condition.matches(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// faultInjector injects faults into the requests, to test how clients behave
+// when the target misbehaves.
+//
+// The rules of the route of a request are tried first, then the global ones.
+// The first rule that matches the request and is picked by its probability is
+// injected, and the rest are ignored.
+type faultInjector struct {
+	logger   *logging.Logger
+	rules    []*faultRule            // Global rules
+	routes   map[string][]*faultRule // Rules of every route, by path
+	injected *metrics.Counter        // Injected faults, by kind
+}
+
+// newFaultInjector creates a faultInjector for the global and the route
+// faults.
+func newFaultInjector(faults []config.FaultConfig, routes []config.RouteConfig, logger *logging.Logger, registry *metrics.Registry) (*faultInjector, error) {
+	rules, err := newFaultRules(faults)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &faultInjector{
+		logger:   logger,
+		rules:    rules,
+		routes:   make(map[string][]*faultRule),
+		injected: registry.Counter("prxy_faults_injected_total", "Total number of injected faults, by kind.", "kind"),
+	}
+	for _, route := range routes {
+		if f.routes[route.Path], err = newFaultRules(route.Faults); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// wrap returns a handler that injects the fault picked for every request, if
+// any, before or while serving it with next.
+func (f *faultInjector) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rule := f.pick(req)
+		if rule == nil {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		f.inject(rule, next, rw, req)
+	})
+}
+
+// pick returns the rule to inject into the request, or nil if none.
+func (f *faultInjector) pick(req *http.Request) *faultRule {
+	var rules []*faultRule
+	if route, ok := requestRoute(req); ok {
+		rules = append(rules, f.routes[route.Path]...)
+	}
+	rules = append(rules, f.rules...)
+
+	for _, rule := range rules {
+		if rule.matches(req) && rand.Float64() < rule.cfg.EffectiveProbability() {
+			return rule
+		}
+	}
+	return nil
+}
+
+// inject delays the request, if configured, and then aborts it, resets the
+// client connection or serves it with next, truncating the response body.
+func (f *faultInjector) inject(rule *faultRule, next http.Handler, rw http.ResponseWriter, req *http.Request) {
+	if rule.cfg.Delay > 0 || rule.cfg.Jitter > 0 {
+		delay := jittered(rule.cfg.Delay, rule.cfg.Jitter)
+		f.record(req, faultDelay, "delay", delay)
+		if err := sleep(req.Context(), delay); err != nil {
+			return
+		}
+	}
+
+	switch {
+	case rule.cfg.Abort != 0:
+		f.record(req, faultAbort, "status", rule.cfg.Abort)
+		http.Error(rw, http.StatusText(rule.cfg.Abort), rule.cfg.Abort)
+	case rule.cfg.Reset:
+		f.record(req, faultReset)
+		// The server closes the connection without answering.
+		panic(http.ErrAbortHandler)
+	case rule.cfg.Truncate > 0:
+		f.record(req, faultTruncate, "bytes", rule.cfg.Truncate)
+		tw := &truncatingWriter{ResponseWriter: rw, remaining: rule.cfg.Truncate}
+		next.ServeHTTP(tw, req)
+		if tw.truncated {
+			// The reverse proxy already aborts the handler once the body is
+			// closed, but other handlers may ignore the write error.
+			panic(http.ErrAbortHandler)
+		}
+	default:
+		next.ServeHTTP(rw, req)
+	}
+}
+
+// record logs and counts an injected fault.
+func (f *faultInjector) record(req *http.Request, kind string, args ...any) {
+	f.injected.Inc(kind)
+	f.logger.Info("Injected fault", append([]any{"kind", kind, "method", req.Method, "url", req.URL.String()}, args...)...)
+}
+
+// errTruncated is returned by the writes of a truncated response body.
+var errTruncated = errors.New("response body truncated by fault")
+
+// truncatingWriter is an http.ResponseWriter that fails the writes once the
+// given number of body bytes have been written, so that the handler aborts and
+// the client connection is reset. Failing instead of panicking lets the
+// reverse proxy close the response body, which releases the resources held by
+// the transports.
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64 // Bytes left before the writes fail
+	truncated bool  // Whether the body was truncated
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *truncatingWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) <= w.remaining {
+		n, err := w.ResponseWriter.Write(b)
+		w.remaining -= int64(n)
+		return n, err
+	}
+
+	n, err := w.ResponseWriter.Write(b[:w.remaining])
+	w.remaining -= int64(n)
+	w.truncated = true
+	if err != nil {
+		return n, err
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+	return n, errTruncated
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that the reverse
+// proxy can flush it.
+func (w *truncatingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,267 @@
+package prxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// TestFaultRule_Matches checks the evaluation of match expressions.
+func TestFaultRule_Matches(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://api.example.com/api/users?debug=1", nil)
+	req.Header.Set("X-Client", "mobile")
+
+	tests := []struct {
+		match    string // Match expression
+		expected bool   // Whether the request is expected to match
+	}{
+		{match: "", expected: true},
+		{match: "method == POST", expected: true},
+		{match: "method != POST", expected: false},
+		{match: "host == api.example.com", expected: true},
+		{match: `path =~ "^/api/"`, expected: true},
+		{match: `path !~ "^/api/"`, expected: false},
+		{match: "query =~ debug=1", expected: true},
+		{match: "header:X-Client == mobile && method == POST", expected: true},
+		{match: "header:X-Client == mobile && method == GET", expected: false},
+		{match: `header:X-Missing == ""`, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.match, func(t *testing.T) {
+			rules, err := newFaultRules([]config.FaultConfig{{Match: tt.match, Abort: 500}})
+			if err != nil {
+				t.Fatalf("newFaultRules() failed: %v", err)
+			}
+			if got := rules[0].matches(req); got != tt.expected {
+				t.Errorf("matches(%q)\nExpected: %v\nGot: %v", tt.match, tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestFaultInjector_Wrap checks every kind of fault against a real server.
+func TestFaultInjector_Wrap(t *testing.T) {
+	body := strings.Repeat("x", 100)
+
+	tests := []struct {
+		name           string               // Name of the test case
+		faults         []config.FaultConfig // Global faults
+		routes         []config.RouteConfig // Routes, with their faults
+		method         string               // Request method
+		path           string               // Requested path
+		expectedStatus int                  // Expected status code, 0 if the connection is reset
+		expectedBody   string               // Expected body
+		expectedError  bool                 // Whether reading the body is expected to fail
+		minimum        time.Duration        // Minimum time to serve the request
+	}{
+		{
+			name:           "no_match",
+			faults:         []config.FaultConfig{{Match: "method == POST", Abort: http.StatusServiceUnavailable}},
+			method:         http.MethodGet,
+			path:           "/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   body,
+		},
+		{
+			name:           "abort",
+			faults:         []config.FaultConfig{{Match: "method == POST", Abort: http.StatusServiceUnavailable}},
+			method:         http.MethodPost,
+			path:           "/",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "Service Unavailable\n",
+		},
+		{
+			name:           "delay",
+			faults:         []config.FaultConfig{{Delay: 100 * time.Millisecond}},
+			method:         http.MethodGet,
+			path:           "/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   body,
+			minimum:        100 * time.Millisecond,
+		},
+		{
+			name:   "reset",
+			faults: []config.FaultConfig{{Reset: true}},
+			method: http.MethodGet,
+			path:   "/",
+		},
+		{
+			name:           "truncate",
+			faults:         []config.FaultConfig{{Truncate: 10}},
+			method:         http.MethodGet,
+			path:           "/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   body[:10],
+			expectedError:  true,
+		},
+		{
+			name:           "route_first",
+			faults:         []config.FaultConfig{{Abort: http.StatusInternalServerError}},
+			routes:         []config.RouteConfig{{Path: "/api", Faults: []config.FaultConfig{{Abort: http.StatusTeapot}}}},
+			method:         http.MethodGet,
+			path:           "/api/users",
+			expectedStatus: http.StatusTeapot,
+			expectedBody:   "I'm a teapot\n",
+		},
+		{
+			name:           "global_outside_route",
+			faults:         []config.FaultConfig{{Abort: http.StatusInternalServerError}},
+			routes:         []config.RouteConfig{{Path: "/api", Faults: []config.FaultConfig{{Abort: http.StatusTeapot}}}},
+			method:         http.MethodGet,
+			path:           "/other",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   "Internal Server Error\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector, err := newFaultInjector(tt.faults, tt.routes, newTestLogger(t), metrics.NewRegistry())
+			if err != nil {
+				t.Fatalf("newFaultInjector() failed: %v", err)
+			}
+			handler := newRouter(tt.routes).wrap(injector.wrap(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Length", "100")
+				_, _ = io.WriteString(rw, body)
+			})))
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+			start := time.Now()
+			resp, err := http.DefaultClient.Do(req)
+			if tt.expectedStatus == 0 {
+				if err == nil {
+					resp.Body.Close() //nolint:errcheck
+					t.Fatal("Expected the connection to be reset, but got a response")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close() //nolint:errcheck
+
+			got, err := io.ReadAll(resp.Body)
+			if (err != nil) != tt.expectedError {
+				t.Errorf("Expected read error: %v\nGot: %v", tt.expectedError, err)
+			}
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, but got: %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if string(got) != tt.expectedBody {
+				t.Errorf("Expected body %q, but got: %q", tt.expectedBody, got)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minimum {
+				t.Errorf("Expected the request to take at least %v, but took: %v", tt.minimum, elapsed)
+			}
+		})
+	}
+}
+
+// TestFaultInjector_Probability checks that faults are picked in proportion
+// to their probability.
+func TestFaultInjector_Probability(t *testing.T) {
+	quarter := 0.25
+	injector, err := newFaultInjector([]config.FaultConfig{{Probability: &quarter, Abort: 500}}, nil, newTestLogger(t), metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("newFaultInjector() failed: %v", err)
+	}
+
+	picked := 0
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for range 4000 {
+		if injector.pick(req) != nil {
+			picked++
+		}
+	}
+	// Around 1000, far enough from the bounds to never fail in practice.
+	if picked < 800 || picked > 1200 {
+		t.Errorf("Expected around 1000 picked faults, but got: %d", picked)
+	}
+}
+
+// TestNew_Faults checks that the faults are injected into the proxied
+// requests only.
+func TestNew_Faults(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(rw, "target")
+	}))
+	defer target.Close()
+
+	proxy := newTestProxy(t)
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Faults = []config.FaultConfig{{Abort: http.StatusBadGateway}}
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	for path, expected := range map[string]int{"/": http.StatusBadGateway, "/_prxy/healthz": http.StatusOK} {
+		rec := httptest.NewRecorder()
+		prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != expected {
+			t.Errorf("GET %s\nExpected status %d, but got: %d", path, expected, rec.Code)
+		}
+	}
+
+	if proxy.requests.Load() != 0 {
+		t.Errorf("Expected no request to reach the outbound proxy, but got: %d", proxy.requests.Load())
+	}
+}
+
+// TestNew_FaultsTruncate checks that truncating a response larger than the
+// copy buffer of the reverse proxy releases what the transports hold for it,
+// such as the concurrency slot and the coalesced call, so the next requests
+// are served.
+func TestNew_FaultsTruncate(t *testing.T) {
+	body := strings.Repeat("x", 256<<10)
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(rw, body)
+	}))
+	defer target.Close()
+
+	proxy := newTestProxy(t)
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Faults = []config.FaultConfig{{Match: `header:X-Fault == "truncate"`, Truncate: 1024}}
+	cfg.Limit = config.LimitConfig{Concurrency: 1, Timeout: time.Second}
+	cfg.Coalesce.Enabled = true
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	server := httptest.NewServer(prxy.server.Handler)
+	defer server.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/file", nil)
+	req.Header.Set("X-Fault", "truncate")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Truncated request failed: %v", err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close() //nolint:errcheck
+	if err == nil || len(got) != 1024 {
+		t.Fatalf("Expected the body to be truncated after 1024 bytes, but got %d bytes and: %v", len(got), err)
+	}
+
+	resp, err = client.Get(server.URL + "/file")
+	if err != nil {
+		t.Fatalf("Next request failed: %v", err)
+	}
+	got, _ = io.ReadAll(resp.Body)
+	resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK || len(got) != len(body) {
+		t.Errorf("Expected status 200 with the full body, but got: %d with %d bytes", resp.StatusCode, len(got))
+	}
+}
@@ -0,0 +1,134 @@
+package prxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// grpcCodes are the names of the gRPC status codes, indexed by code.
+var grpcCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+	"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// gRPC status codes used by prxy itself.
+const (
+	grpcUnknown           = 2
+	grpcDeadlineExceeded  = 4
+	grpcResourceExhausted = 8
+	grpcUnavailable       = 14
+)
+
+// isGRPC reports whether the request is a gRPC call. gRPC-Web calls are not,
+// since they work over HTTP/1.1 like any other request.
+func isGRPC(req *http.Request) bool {
+	mediaType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return req.Method == http.MethodPost && (mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+"))
+}
+
+// grpcCodeName returns the name of a gRPC status code, or the code itself if
+// it is unknown.
+func grpcCodeName(code string) string {
+	if n, err := strconv.Atoi(code); err == nil && n >= 0 && n < len(grpcCodes) {
+		return grpcCodes[n]
+	}
+	return code
+}
+
+// grpcTransport is an http.RoundTripper that sends gRPC calls over HTTP/2,
+// which they require, and reports the ones that fail.
+//
+// The outcome of a gRPC call is not its HTTP status but the grpc-status
+// trailer, which is only known once the response body is fully read. Calls
+// that fail before sending any message carry it in the headers instead.
+type grpcTransport struct {
+	next   http.RoundTripper // Transport of the requests that are not gRPC calls
+	http2  http.RoundTripper // Transport of the gRPC calls
+	logger *logging.Logger
+	calls  *metrics.Counter // Finished calls, by status
+}
+
+// newGRPCTransport creates a grpcTransport that sends gRPC calls with a copy
+// of next that attempts HTTP/2, unless next already does.
+func newGRPCTransport(next *http.Transport, logger *logging.Logger, registry *metrics.Registry) *grpcTransport {
+	http2 := next
+	if !next.ForceAttemptHTTP2 {
+		http2 = next.Clone()
+		http2.ForceAttemptHTTP2 = true
+	}
+
+	return &grpcTransport{
+		next:   next,
+		http2:  http2,
+		logger: logger,
+		calls:  registry.Counter("prxy_grpc_calls_total", "Total number of proxied gRPC calls, by status.", "status"),
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *grpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isGRPC(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	resp, err := t.http2.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// A trailers-only response has no messages to wait for.
+	if status := resp.Header.Get("Grpc-Status"); status != "" {
+		t.finished(req, status, resp.Header.Get("Grpc-Message"))
+		return resp, nil
+	}
+	resp.Body = &grpcBody{ReadCloser: resp.Body, done: func() {
+		t.finished(req, resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"))
+	}}
+
+	return resp, nil
+}
+
+// finished reports the outcome of a gRPC call. A missing status means that the
+// target answered something that is not a gRPC response.
+func (t *grpcTransport) finished(req *http.Request, status, message string) {
+	if status == "" {
+		status = strconv.Itoa(grpcUnknown)
+		message = "no grpc-status in the response"
+	}
+	name := grpcCodeName(status)
+	t.calls.Inc(name)
+
+	switch name {
+	case "OK":
+		t.logger.Debug("gRPC call succeeded", "url", req.URL.String())
+	case "UNKNOWN", "DEADLINE_EXCEEDED", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS":
+		t.logger.Error("gRPC call failed", "url", req.URL.String(), "grpc_status", name, "grpc_message", message)
+	default:
+		t.logger.Warn("gRPC call failed", "url", req.URL.String(), "grpc_status", name, "grpc_message", message)
+	}
+}
+
+// grpcBody is a response body that calls done once it is fully read, when the
+// trailers are available.
+type grpcBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+// Read implements the io.Reader interface.
+func (b *grpcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
@@ -0,0 +1,224 @@
+package prxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+// newTestGRPCServer starts a gRPC server over TLS with the health and
+// reflection services, returning its URL, the health service and the pool
+// that trusts its certificate.
+func newTestGRPCServer(t *testing.T) (*url.URL, *health.Server, *tls.Config) {
+	t.Helper()
+
+	certPath, keyPath, pool := writeTestCertificate(t)
+	creds, err := credentials.NewServerTLSFromFile(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("Failed to load the certificate: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+
+	server := grpc.NewServer(grpc.Creds(creds))
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	go server.Serve(listener) //nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	targetURL, _ := url.Parse("https://" + listener.Addr().String())
+	return targetURL, healthServer, &tls.Config{RootCAs: pool}
+}
+
+// TestIsGRPC checks the detection of gRPC calls.
+func TestIsGRPC(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string // Name of the test case
+		method      string // Request method
+		contentType string // Request content type
+		expected    bool   // Whether the request is a gRPC call
+	}{
+		{name: "grpc", method: http.MethodPost, contentType: "application/grpc", expected: true},
+		{name: "grpc_proto", method: http.MethodPost, contentType: "application/grpc+proto", expected: true},
+		{name: "grpc_parameters", method: http.MethodPost, contentType: "application/grpc; charset=utf-8", expected: true},
+		{name: "grpc_web", method: http.MethodPost, contentType: "application/grpc-web+proto", expected: false},
+		{name: "get", method: http.MethodGet, contentType: "application/grpc", expected: false},
+		{name: "json", method: http.MethodPost, contentType: "application/json", expected: false},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/test.Echo/Echo", nil)
+			req.Header.Set("Content-Type", tt.contentType)
+			if got := isGRPC(req); got != tt.expected {
+				t.Errorf("isGRPC(%s %s)\nExpected %t, but got: %t", tt.method, tt.contentType, tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestGRPCTransport_Proxy checks that calls of a gRPC client are proxied to a
+// gRPC server over HTTP/2 end to end, accepted over h2c and sent through the
+// outbound proxy, even if HTTP/2 is not enabled for the other requests:
+// messages are streamed both ways without buffering, statuses make it to the
+// client, and the outcome of every call is counted.
+func TestGRPCTransport_Proxy(t *testing.T) {
+	targetURL, healthServer, tlsConfig := newTestGRPCServer(t)
+	proxy := newTestProxy(t)
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	transport := newTransport(config.Defaults.Upstream, proxyURL, true)
+	transport.TLSClientConfig = tlsConfig
+	grpcTransport := newGRPCTransport(transport, newTestLogger(t), metrics.NewRegistry())
+	t.Cleanup(grpcTransport.http2.(*http.Transport).CloseIdleConnections)
+
+	errorHandler, err := newErrorResponder(config.Defaults.Error, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("newErrorResponder() failed: %v", err)
+	}
+	server := httptest.NewUnstartedServer(&httputil.ReverseProxy{
+		Rewrite:      func(pr *httputil.ProxyRequest) { pr.SetURL(targetURL) },
+		Transport:    grpcTransport,
+		ErrorHandler: errorHandler.ServeError,
+	})
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	conn, err := grpc.NewClient(server.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	t.Run("should_proxy_unary_calls", func(t *testing.T) {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("Check() failed: %v", err)
+		}
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Expected SERVING, but got: %v", resp.GetStatus())
+		}
+		if got := grpcTransport.calls.Value("OK"); got != 1 {
+			t.Errorf("Expected 1 OK call, but got: %v", got)
+		}
+		if proxy.connects.Load() == 0 {
+			t.Error("Expected the call to be tunneled through the proxy")
+		}
+	})
+
+	t.Run("should_stream_server_messages", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		healthServer.SetServingStatus("test.Echo", healthpb.HealthCheckResponse_SERVING)
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "test.Echo"})
+		if err != nil {
+			t.Fatalf("Watch() failed: %v", err)
+		}
+
+		// Every update must arrive while the call is still open.
+		for _, expected := range []healthpb.HealthCheckResponse_ServingStatus{healthpb.HealthCheckResponse_SERVING, healthpb.HealthCheckResponse_NOT_SERVING} {
+			resp, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv() failed: %v", err)
+			}
+			if resp.GetStatus() != expected {
+				t.Fatalf("Expected %v, but got: %v", expected, resp.GetStatus())
+			}
+			healthServer.SetServingStatus("test.Echo", healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	})
+
+	t.Run("should_stream_messages_both_ways", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			t.Fatalf("ServerReflectionInfo() failed: %v", err)
+		}
+		for range 2 {
+			if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{ListServices: "*"},
+			}); err != nil {
+				t.Fatalf("Send() failed: %v", err)
+			}
+			resp, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv() failed: %v", err)
+			}
+			if got := len(resp.GetListServicesResponse().GetService()); got != 3 {
+				t.Errorf("Expected 3 services to be listed, but got: %d", got)
+			}
+		}
+		if err := stream.CloseSend(); err != nil {
+			t.Fatalf("CloseSend() failed: %v", err)
+		}
+	})
+
+	t.Run("should_keep_error_statuses", func(t *testing.T) {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "test.Missing"})
+		if got := status.Code(err); got != codes.NotFound {
+			t.Errorf("Expected NOT_FOUND, but got: %v", err)
+		}
+		if got := grpcTransport.calls.Value("NOT_FOUND"); got != 1 {
+			t.Errorf("Expected 1 NOT_FOUND call, but got: %v", got)
+		}
+	})
+
+	t.Run("should_keep_trailers_only_responses", func(t *testing.T) {
+		err := conn.Invoke(ctx, "/test.Echo/Unimplemented", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+		if got := status.Code(err); got != codes.Unimplemented {
+			t.Errorf("Expected UNIMPLEMENTED, but got: %v", err)
+		}
+		if got := grpcTransport.calls.Value("UNIMPLEMENTED"); got != 1 {
+			t.Errorf("Expected 1 UNIMPLEMENTED call, but got: %v", got)
+		}
+	})
+
+	t.Run("should_report_errors_as_grpc_status", func(t *testing.T) {
+		proxy.refuse.Store(true)
+		defer proxy.refuse.Store(false)
+
+		// The tunnel is only closed once the streams of the previous calls
+		// are done.
+		var err error
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			grpcTransport.http2.(*http.Transport).CloseIdleConnections()
+			if _, err = client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+				break
+			}
+		}
+		if got := status.Code(err); got != codes.Unavailable {
+			t.Errorf("Expected UNAVAILABLE, but got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package prxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// healthChecker probes the path from prxy to the target and caches the
+// result, so that frequent readiness requests don't flood the proxy.
+// Concurrent requests share a single probe.
+type healthChecker struct {
+	logger    *logging.Logger
+	cfg       config.HealthConfig
+	targetURL *url.URL
+	proxyURL  *url.URL
+	client    *http.Client
+	offline   bool // Whether the target is never contacted, so prxy is always ready
+
+	mu      sync.Mutex
+	checked time.Time     // When the cached result was obtained
+	err     error         // Cached result of the last check
+	probe   chan struct{} // Closed once the probe in flight completes, nil if none
+}
+
+// newHealthChecker creates a healthChecker that uses the given transport to
+// probe the target.
+func newHealthChecker(cfg config.HealthConfig, targetURL, proxyURL *url.URL, transport http.RoundTripper, logger *logging.Logger) *healthChecker {
+	return &healthChecker{
+		logger:    logger,
+		cfg:       cfg,
+		targetURL: targetURL,
+		proxyURL:  proxyURL,
+		client: &http.Client{
+			Transport: transport,
+			// Report the probe status as is instead of following redirects.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Ready returns nil if the outbound proxy and, if configured, the target are
+// reachable. Results are cached for the configured interval, and the requests
+// that arrive while the target is probed wait for the same result.
+func (hc *healthChecker) Ready() error {
+	if hc.offline {
+		return nil
+	}
+
+	hc.mu.Lock()
+	if !hc.checked.IsZero() && time.Since(hc.checked) < hc.cfg.Interval {
+		defer hc.mu.Unlock()
+		return hc.err
+	}
+	if probe := hc.probe; probe != nil {
+		hc.mu.Unlock()
+		<-probe
+		hc.mu.Lock()
+		defer hc.mu.Unlock()
+		return hc.err
+	}
+	probe := make(chan struct{})
+	hc.probe = probe
+	hc.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), hc.cfg.Timeout)
+	defer cancel()
+	err := hc.check(ctx)
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	switch {
+	case err != nil && (hc.err == nil || hc.checked.IsZero()):
+		hc.logger.Warn("Readiness check failed", "error", err)
+	case err == nil && hc.err != nil:
+		hc.logger.Info("Readiness check recovered")
+	}
+	hc.err = err
+	hc.checked = time.Now()
+	hc.probe = nil
+	close(probe)
+
+	return err
+}
+
+// check runs all the readiness checks.
+func (hc *healthChecker) check(ctx context.Context) error {
+	if err := hc.checkProxy(ctx); err != nil {
+		return fmt.Errorf("proxy check failed: %w", err)
+	}
+
+	if hc.cfg.Path != "" {
+		if err := hc.checkTarget(ctx); err != nil {
+			return fmt.Errorf("target check failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkProxy ensures the outbound proxy accepts a CONNECT to the target. For
+// HTTP targets, whose requests are forwarded instead of tunneled, it only
+// ensures the proxy accepts connections.
+func (hc *healthChecker) checkProxy(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(hc.proxyURL))
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	if hc.proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: hc.proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	if hc.targetURL.Scheme != "https" {
+		return nil
+	}
+
+	targetAddr := hostPort(hc.targetURL)
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: targetAddr},
+		Host:   targetAddr,
+		Header: make(http.Header),
+	}
+	if user := hc.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	// The body of a CONNECT response is the tunnel itself, which is discarded
+	// along with the connection.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT to %s returned %q", targetAddr, resp.Status)
+	}
+
+	return nil
+}
+
+// checkTarget ensures the probe path on the target returns the expected
+// status code.
+func (hc *healthChecker) checkTarget(ctx context.Context) error {
+	ref, err := url.Parse(hc.cfg.Path)
+	if err != nil {
+		return err
+	}
+	probeURL := hc.targetURL.JoinPath(ref.Path)
+	probeURL.RawQuery = ref.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()               //nolint:errcheck
+	_, _ = io.Copy(io.Discard, resp.Body) // Allow the connection to be reused
+
+	if resp.StatusCode != hc.cfg.Status {
+		return fmt.Errorf("GET %s returned %q, expected %d", ref, resp.Status, hc.cfg.Status)
+	}
+
+	return nil
+}
+
+// handleHealthz reports that the process is alive.
+func (hc *healthChecker) handleHealthz(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(rw, "ok\n")
+}
+
+// handleReadyz reports whether the path to the target is usable. The reason
+// of a failure is only logged, since it reveals the addresses of the outbound
+// proxy and the target.
+func (hc *healthChecker) handleReadyz(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := hc.Ready(); err != nil {
+		hc.logger.Debug("Not ready", "error", err)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(rw, "not ready\n")
+		return
+	}
+	_, _ = io.WriteString(rw, "ok\n")
+}
+
+// hostPort returns the host:port of the URL, using the default port of the
+// scheme if the URL doesn't have one.
+func hostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
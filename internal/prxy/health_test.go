@@ -0,0 +1,213 @@
+package prxy
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// TestHealthChecker_Ready checks the readiness checks against the outbound
+// proxy and the target.
+func TestHealthChecker_Ready(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/status" {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.NotFound(rw, req)
+	})
+	// The tunnels of the proxy checks are closed without a TLS handshake.
+	tlsTarget := httptest.NewUnstartedServer(handler)
+	tlsTarget.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsTarget.StartTLS()
+	t.Cleanup(tlsTarget.Close)
+	plainTarget := httptest.NewServer(handler)
+	t.Cleanup(plainTarget.Close)
+
+	// Test cases
+	tests := []struct {
+		name             string // Name of the test case
+		plain            bool   // Whether the target is served over plain HTTP
+		path             string // Probe path on the target
+		status           int    // Expected status code of the probe
+		refuse           bool   // Whether the proxy refuses the requests
+		expectedConnects int64  // Expected CONNECT requests to the proxy
+		errorContains    string // Expected error substring, empty if no error is expected
+	}{
+		{
+			name:             "ready_without_target_probe",
+			status:           http.StatusOK,
+			expectedConnects: 1,
+		},
+		{
+			name:             "ready_with_target_probe",
+			path:             "/status",
+			status:           http.StatusNoContent,
+			expectedConnects: 2,
+		},
+		{
+			name:             "proxy_refuses_connect",
+			status:           http.StatusOK,
+			refuse:           true,
+			expectedConnects: 1,
+			errorContains:    "proxy check failed",
+		},
+		{
+			name:             "target_probe_unexpected_status",
+			path:             "/missing",
+			status:           http.StatusOK,
+			expectedConnects: 2,
+			errorContains:    "target check failed",
+		},
+		{
+			name:             "plain_target_is_not_tunneled",
+			plain:            true,
+			path:             "/status",
+			status:           http.StatusNoContent,
+			expectedConnects: 0,
+		},
+		{
+			name:             "plain_target_only_needs_a_connection",
+			plain:            true,
+			status:           http.StatusOK,
+			refuse:           true,
+			expectedConnects: 0,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t)
+			proxy.refuse.Store(tt.refuse)
+			proxyURL, _ := url.Parse(proxy.URL)
+			target := tlsTarget
+			if tt.plain {
+				target = plainTarget
+			}
+			targetURL, _ := url.Parse(target.URL)
+
+			cfg := config.HealthConfig{Interval: time.Minute, Timeout: time.Second, Path: tt.path, Status: tt.status}
+			transport := target.Client().Transport.(*http.Transport).Clone()
+			transport.Proxy = http.ProxyURL(proxyURL)
+			hc := newHealthChecker(cfg, targetURL, proxyURL, transport, newTestLogger(t))
+
+			err := hc.Ready()
+			if tt.errorContains == "" && err != nil {
+				t.Fatalf("Ready()\nExpected no error, but got: %v", err)
+			}
+			if tt.errorContains != "" && (err == nil || !strings.Contains(err.Error(), tt.errorContains)) {
+				t.Fatalf("Ready()\nExpected error to contain %q, but got: %v", tt.errorContains, err)
+			}
+			if got := proxy.connects.Load(); got != tt.expectedConnects {
+				t.Errorf("Expected %d CONNECT to the proxy, but got: %d", tt.expectedConnects, got)
+			}
+
+			// The reason of the failure must not reach the clients.
+			rec := httptest.NewRecorder()
+			hc.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/_prxy/readyz", nil))
+			if tt.errorContains != "" && (rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "not ready\n") {
+				t.Errorf("GET /_prxy/readyz\nExpected 503 %q, but got: %d %q", "not ready\n", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// TestHealthChecker_ReadyShared checks that concurrent readiness requests
+// share a single probe instead of queuing behind each other.
+func TestHealthChecker_ReadyShared(t *testing.T) {
+	// The proxy holds every CONNECT until released.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() }) //nolint:errcheck
+	var accepted atomic.Int64
+	release := make(chan struct{})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close() //nolint:errcheck
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				<-release
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			}()
+		}
+	}()
+
+	proxyURL, _ := url.Parse("http://" + listener.Addr().String())
+	targetURL, _ := url.Parse("https://target.example.com")
+	cfg := config.HealthConfig{Interval: time.Minute, Timeout: 5 * time.Second, Status: http.StatusOK}
+	hc := newHealthChecker(cfg, targetURL, proxyURL, http.DefaultTransport, newTestLogger(t))
+
+	errs := make(chan error, 5)
+	for range cap(errs) {
+		go func() { errs <- hc.Ready() }()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for accepted.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for range cap(errs) {
+		if err := <-errs; err != nil {
+			t.Errorf("Ready()\nExpected no error, but got: %v", err)
+		}
+	}
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("Expected 1 probe of the proxy, but got: %d", got)
+	}
+}
+
+// TestHealthChecker_ReadyCache checks that readiness results are cached for
+// the configured interval.
+func TestHealthChecker_ReadyCache(t *testing.T) {
+	target := httptest.NewUnstartedServer(http.NotFoundHandler())
+	target.Config.ErrorLog = log.New(io.Discard, "", 0)
+	target.StartTLS()
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+	targetURL, _ := url.Parse(target.URL)
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	cfg := config.HealthConfig{Interval: time.Hour, Timeout: time.Second, Status: http.StatusOK}
+	hc := newHealthChecker(cfg, targetURL, proxyURL, http.DefaultTransport, newTestLogger(t))
+
+	if err := hc.Ready(); err != nil {
+		t.Fatalf("Ready()\nExpected no error, but got: %v", err)
+	}
+
+	// A failure within the interval must not be noticed.
+	proxy.refuse.Store(true)
+	if err := hc.Ready(); err != nil {
+		t.Fatalf("Ready()\nExpected cached result, but got: %v", err)
+	}
+	if proxy.connects.Load() != 1 {
+		t.Errorf("Expected 1 CONNECT to the proxy, but got: %d", proxy.connects.Load())
+	}
+
+	// Once the cached result expires, the failure is reported.
+	hc.checked = time.Now().Add(-2 * time.Hour)
+	if err := hc.Ready(); err == nil {
+		t.Error("Ready()\nExpected error after the cache expired, but got none")
+	}
+}
@@ -0,0 +1,58 @@
+package prxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server creates the server that serves the handler over HTTP/3 with
+// the certificate of the TLS listeners.
+func newHTTP3Server(handler http.Handler, tlsConfig *tls.Config, idleTimeout time.Duration) *http3.Server {
+	return &http3.Server{
+		Handler:     handler,
+		TLSConfig:   tlsConfig,
+		IdleTimeout: idleTimeout,
+	}
+}
+
+// advertiseHTTP3 returns a handler that announces the HTTP/3 server to the
+// clients of the TLS listeners with the Alt-Svc header, so that they switch to
+// it for the next requests.
+func advertiseHTTP3(server *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.TLS != nil {
+			// It only fails while no UDP socket is served yet.
+			_ = server.SetQUICHeaders(rw.Header())
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// listenPackets binds a UDP socket on the address of every TLS listener, for
+// the HTTP/3 server. The other listeners are skipped.
+func listenPackets(listeners []net.Listener) ([]net.PacketConn, error) {
+	var conns []net.PacketConn
+	for _, listener := range listeners {
+		if _, isTLS := listener.(tlsListener); !isTLS {
+			continue
+		}
+		conn, err := net.ListenPacket("udp", listener.Addr().String())
+		if err != nil {
+			closePacketConns(conns)
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// closePacketConns closes every UDP socket, ignoring errors.
+func closePacketConns(conns []net.PacketConn) {
+	for _, conn := range conns {
+		conn.Close() //nolint:errcheck
+	}
+}
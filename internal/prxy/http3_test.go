@@ -0,0 +1,122 @@
+package prxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// TestPrxy_HTTP3 checks that HTTP/3 is served on the UDP port of the TLS
+// listener with the same handler, that it is advertised to the clients of the
+// TLS listener only, and that shutdown covers it.
+func TestPrxy_HTTP3(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Alt-Svc", `h3=":443"`)
+		_, _ = io.WriteString(rw, "target:"+req.URL.Path)
+	}))
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+	certPath, keyPath, pool := writeTestCertificate(t)
+
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Listen = []string{"127.0.0.1:0", "tls://127.0.0.1:0"}
+	cfg.TLS.Cert, cfg.TLS.Key = certPath, keyPath
+	cfg.Server.HTTP3 = true
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := prxy.Listen(); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	errChan := make(chan error, 1)
+	go func() { errChan <- prxy.Run() }()
+
+	addrs := prxy.addrs()
+	tlsAddr := strings.TrimPrefix(addrs[1], "tls://")
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	if len(prxy.packets) != 1 || prxy.packets[0].LocalAddr().String() != tlsAddr {
+		t.Fatalf("Expected a UDP socket on %s, but got: %v", tlsAddr, prxy.packets)
+	}
+
+	// Test cases
+	tests := []struct {
+		name           string            // Name of the test case
+		url            string            // Requested URL
+		transport      http.RoundTripper // Transport that reaches the listener
+		expectedProto  int               // Expected major version of HTTP
+		expectedAltSvc string            // Expected Alt-Svc header
+	}{
+		{
+			name:           "tcp_listener_is_not_advertised",
+			url:            "http://" + addrs[0] + "/path",
+			transport:      &http.Transport{},
+			expectedProto:  1,
+			expectedAltSvc: "",
+		},
+		{
+			name:           "tls_listener_is_advertised",
+			url:            "https://" + tlsAddr + "/path",
+			transport:      &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			expectedProto:  1,
+			expectedAltSvc: `h3=":` + port + `"; ma=2592000`,
+		},
+		{
+			name:           "serves_http3",
+			url:            "https://" + tlsAddr + "/path",
+			transport:      &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			expectedProto:  3,
+			expectedAltSvc: "",
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: tt.transport}
+			resp, err := client.Get(tt.url)
+			if err != nil {
+				t.Fatalf("GET %s failed: %v", tt.url, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint:errcheck
+
+			if string(body) != "target:/path" {
+				t.Errorf("GET %s\nExpected the response of the target, but got: %q", tt.url, body)
+			}
+			if resp.ProtoMajor != tt.expectedProto {
+				t.Errorf("GET %s\nExpected HTTP/%d, but got: %s", tt.url, tt.expectedProto, resp.Proto)
+			}
+			if got := strings.Join(resp.Header.Values("Alt-Svc"), ", "); got != tt.expectedAltSvc {
+				t.Errorf("GET %s\nExpected Alt-Svc %q, but got: %q", tt.url, tt.expectedAltSvc, got)
+			}
+			if closer, ok := tt.transport.(io.Closer); ok {
+				closer.Close() //nolint:errcheck
+			} else {
+				client.CloseIdleConnections()
+			}
+		})
+	}
+
+	if err := prxy.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if err := <-errChan; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Run()\nExpected http.ErrServerClosed, but got: %v", err)
+	}
+	conn, err := net.ListenPacket("udp", tlsAddr)
+	if err != nil {
+		t.Errorf("Expected the UDP socket on %s to be closed on shutdown, but got: %v", tlsAddr, err)
+	} else {
+		conn.Close() //nolint:errcheck
+	}
+}
@@ -0,0 +1,190 @@
+package prxy
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// Errors returned for the requests that never get their turn.
+var (
+	errQueueFull    = errors.New("too many requests waiting for the target")
+	errQueueTimeout = errors.New("timed out waiting for a turn to reach the target")
+)
+
+// limitWaiter is a request waiting in the queue for its turn.
+type limitWaiter struct {
+	ready   chan struct{} // Closed once the request is granted a slot
+	granted bool          // Whether a slot was handed over, guarded by the transport lock
+}
+
+// limitTransport is an http.RoundTripper that caps the requests in flight to
+// the target, so that a slow outbound proxy is not overwhelmed.
+//
+// Requests over the cap wait in a bounded queue and get their turn in arrival
+// order. They are rejected when the queue is full or when they wait for too
+// long. A request holds its slot until the response body is closed, since
+// streamed responses keep the tunnel busy.
+type limitTransport struct {
+	next     http.RoundTripper
+	cfg      config.LimitConfig
+	logger   *logging.Logger
+	mu       sync.Mutex // Guards inFlight and queue
+	inFlight int        // Requests holding a slot
+	queue    *list.List // Waiting requests, oldest first
+
+	inFlightGauge *metrics.Gauge   // Requests holding a slot
+	depth         *metrics.Gauge   // Waiting requests
+	queued        *metrics.Counter // Requests that had to wait
+	waited        *metrics.Counter // Time spent waiting
+	rejected      *metrics.Counter // Rejected requests, by reason
+}
+
+// newLimitTransport creates a limitTransport that sends the requests to next.
+func newLimitTransport(cfg config.LimitConfig, next http.RoundTripper, logger *logging.Logger, registry *metrics.Registry) *limitTransport {
+	return &limitTransport{
+		next:          next,
+		cfg:           cfg,
+		logger:        logger,
+		queue:         list.New(),
+		inFlightGauge: registry.Gauge("prxy_limit_in_flight", "Number of requests in flight to the target."),
+		depth:         registry.Gauge("prxy_limit_queue_depth", "Number of requests waiting for their turn to reach the target."),
+		queued:        registry.Counter("prxy_limit_queued_total", "Total number of requests that waited for their turn to reach the target."),
+		waited:        registry.Counter("prxy_limit_queue_wait_seconds_total", "Total time spent by the requests waiting for their turn, in seconds."),
+		rejected:      registry.Counter("prxy_limit_rejected_total", "Total number of requests rejected by the concurrency limit, by reason.", "reason"),
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.acquire(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+	// Upgraded connections may last for hours, so they don't hold the slot.
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		t.release()
+		return resp, nil
+	}
+	resp.Body = &limitBody{ReadCloser: resp.Body, release: t.release}
+
+	return resp, nil
+}
+
+// acquire takes a slot for the request, waiting for its turn if none is free.
+func (t *limitTransport) acquire(req *http.Request) error {
+	t.mu.Lock()
+	if t.inFlight < t.cfg.Concurrency && t.queue.Len() == 0 {
+		t.inFlight++
+		t.inFlightGauge.Set(float64(t.inFlight))
+		t.mu.Unlock()
+		return nil
+	}
+	if t.queue.Len() >= t.cfg.Queue {
+		depth := t.queue.Len()
+		t.mu.Unlock()
+		t.rejected.Inc("full")
+		t.logger.Warn("Request queue full", "url", req.URL.Redacted(), "depth", depth)
+		return errQueueFull
+	}
+	w := &limitWaiter{ready: make(chan struct{})}
+	elem := t.queue.PushBack(w)
+	t.depth.Set(float64(t.queue.Len()))
+	t.mu.Unlock()
+
+	start := time.Now()
+	t.queued.Inc()
+
+	var timeout <-chan time.Time
+	if t.cfg.Timeout > 0 {
+		timer := time.NewTimer(t.cfg.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+	case <-timeout:
+		err = errQueueTimeout
+	case <-req.Context().Done():
+		err = req.Context().Err()
+	}
+	wait := time.Since(start)
+	t.waited.Add(wait.Seconds())
+
+	if err != nil {
+		t.mu.Lock()
+		granted := w.granted
+		if !granted {
+			t.queue.Remove(elem)
+			t.depth.Set(float64(t.queue.Len()))
+		}
+		t.mu.Unlock()
+		// The slot was handed over just as the request gave up.
+		if granted {
+			t.release()
+		}
+		if err == errQueueTimeout {
+			t.rejected.Inc("timeout")
+			t.logger.Warn("Request timed out in queue", "url", req.URL.Redacted(), "wait", wait)
+		}
+		return err
+	}
+
+	t.logger.Debug("Request dequeued", "url", req.URL.Redacted(), "wait", wait)
+	return nil
+}
+
+// release frees the slot of a request, handing it over to the oldest waiting
+// request, if any.
+func (t *limitTransport) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if front := t.queue.Front(); front != nil {
+		w := t.queue.Remove(front).(*limitWaiter)
+		w.granted = true
+		close(w.ready)
+		t.depth.Set(float64(t.queue.Len()))
+		return
+	}
+
+	t.inFlight--
+	t.inFlightGauge.Set(float64(t.inFlight))
+}
+
+// limitBody is a response body that frees the slot of its request once it is
+// closed or fully read.
+type limitBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Read implements the io.Reader interface.
+func (b *limitBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (b *limitBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
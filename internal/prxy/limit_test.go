@@ -0,0 +1,180 @@
+package prxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// waitForGauge waits until the gauge reaches the value.
+func waitForGauge(t *testing.T, gauge *metrics.Gauge, value float64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for gauge.Value() != value {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected gauge value %v, but got: %v", value, gauge.Value())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newLimitTestTransport creates a limitTransport in front of an origin that
+// answers right away, recording the order of the requests it receives.
+func newLimitTestTransport(t *testing.T, cfg config.LimitConfig) (*limitTransport, func() []string) {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	origin := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, req.URL.Path)
+		if req.URL.Path == "/error" {
+			return nil, errors.New("connection refused")
+		}
+		return newTestResponse(http.StatusOK, "ok"), nil
+	})
+	l := newLimitTransport(cfg, origin, newTestLogger(t), metrics.NewRegistry())
+
+	return l, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), order...)
+	}
+}
+
+// limitRequest sends a GET request for the path through the transport.
+func limitRequest(ctx context.Context, l *limitTransport, path string) (*http.Response, error) {
+	req := httptest.NewRequest(http.MethodGet, "http://target.example.com"+path, nil).WithContext(ctx)
+	return l.RoundTrip(req)
+}
+
+// TestLimitTransport_Queue checks that requests over the limit wait for their
+// turn in arrival order, and are rejected once the queue is full.
+func TestLimitTransport_Queue(t *testing.T) {
+	l, order := newLimitTestTransport(t, config.LimitConfig{Concurrency: 1, Queue: 2})
+
+	// The first request holds the only slot until its body is closed.
+	first, err := limitRequest(context.Background(), l, "/first")
+	if err != nil {
+		t.Fatalf("RoundTrip() failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i, path := range []string{"/second", "/third"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := limitRequest(context.Background(), l, path)
+			if err != nil {
+				t.Errorf("RoundTrip(%s) failed: %v", path, err)
+				return
+			}
+			_, _ = io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint:errcheck
+		}()
+		waitForGauge(t, l.depth, float64(i+1))
+	}
+
+	if _, err := limitRequest(context.Background(), l, "/fourth"); !errors.Is(err, errQueueFull) {
+		t.Errorf("RoundTrip()\nExpected error: %v\nGot: %v", errQueueFull, err)
+	}
+
+	first.Body.Close() //nolint:errcheck
+	wg.Wait()
+
+	expected := []string{"/first", "/second", "/third"}
+	if got := order(); len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] || got[2] != expected[2] {
+		t.Errorf("Expected requests in order %v, but got: %v", expected, got)
+	}
+	if got := l.inFlightGauge.Value(); got != 0 {
+		t.Errorf("Expected no requests in flight, but got: %v", got)
+	}
+	if got := l.depth.Value(); got != 0 {
+		t.Errorf("Expected an empty queue, but got: %v", got)
+	}
+	if got := l.queued.Value(); got != 2 {
+		t.Errorf("Expected 2 queued requests, but got: %v", got)
+	}
+	if got := l.rejected.Value("full"); got != 1 {
+		t.Errorf("Expected 1 rejected request, but got: %v", got)
+	}
+}
+
+// TestLimitTransport_GiveUp checks that requests leave the queue when they
+// time out or are canceled, and that failed requests free their slot.
+func TestLimitTransport_GiveUp(t *testing.T) {
+	l, _ := newLimitTestTransport(t, config.LimitConfig{Concurrency: 1, Queue: 1, Timeout: 10 * time.Millisecond})
+
+	first, err := limitRequest(context.Background(), l, "/first")
+	if err != nil {
+		t.Fatalf("RoundTrip() failed: %v", err)
+	}
+
+	if _, err := limitRequest(context.Background(), l, "/timeout"); !errors.Is(err, errQueueTimeout) {
+		t.Errorf("RoundTrip()\nExpected error: %v\nGot: %v", errQueueTimeout, err)
+	}
+	if got := l.rejected.Value("timeout"); got != 1 {
+		t.Errorf("Expected 1 timed out request, but got: %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limitRequest(ctx, l, "/canceled"); !errors.Is(err, context.Canceled) {
+		t.Errorf("RoundTrip()\nExpected error: %v\nGot: %v", context.Canceled, err)
+	}
+	if got := l.depth.Value(); got != 0 {
+		t.Errorf("Expected an empty queue, but got: %v", got)
+	}
+
+	first.Body.Close() //nolint:errcheck
+	if _, err := limitRequest(context.Background(), l, "/error"); err == nil {
+		t.Error("RoundTrip()\nExpected error, but got: nil")
+	}
+	if got := l.inFlightGauge.Value(); got != 0 {
+		t.Errorf("Expected no requests in flight, but got: %v", got)
+	}
+}
+
+// TestNew_Limit checks that requests over the limit are answered with 503
+// once the queue is full.
+func TestNew_Limit(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		close(entered)
+		<-release
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer target.Close()
+	defer close(release)
+
+	proxy := newTestProxy(t)
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Limit = config.LimitConfig{Concurrency: 1}
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	go prxy.server.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	<-entered
+
+	rec := httptest.NewRecorder()
+	prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rejected", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, but got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
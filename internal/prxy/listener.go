@@ -0,0 +1,176 @@
+package prxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// tlsListener is a net.Listener that serves TLS on a TCP address.
+type tlsListener struct {
+	net.Listener
+}
+
+// listen binds the address. For TLS addresses, connections are served over
+// TLS with the given configuration. For unix sockets, a stale socket left
+// behind by a previous run is removed first, and the configured file mode and
+// owner are applied once bound.
+func listen(addr config.ListenAddress, socket config.SocketConfig, tlsConfig *tls.Config) (net.Listener, error) {
+	if addr.Network != config.ListenNetworkUnix {
+		listener, err := net.Listen(addr.Network, addr.Address)
+		if err != nil || !addr.TLS {
+			return listener, err
+		}
+		return tlsListener{tls.NewListener(listener, tlsConfig)}, nil
+	}
+
+	if err := removeStaleSocket(addr.Address); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen(addr.Network, addr.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setupSocket(addr.Address, socket); err != nil {
+		listener.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// inheritListeners prepares the listeners passed by systemd socket activation
+// to be served like the configured addresses. Passed sockets can't be matched
+// to the addresses, so they are all served over TLS if every address is a TLS
+// one, and as is if none is. Mixing both kinds of addresses is an error.
+func inheritListeners(listeners []net.Listener, addresses []config.ListenAddress, tlsConfig *tls.Config) ([]net.Listener, error) {
+	tlsAddresses := 0
+	for _, address := range addresses {
+		if address.TLS {
+			tlsAddresses++
+		}
+	}
+	if tlsAddresses == 0 {
+		return listeners, nil
+	}
+	if tlsAddresses < len(addresses) {
+		return nil, errors.New("passed sockets can't be served with both TLS and plain listen addresses")
+	}
+
+	inherited := make([]net.Listener, 0, len(listeners))
+	for _, listener := range listeners {
+		if network := listener.Addr().Network(); network != config.ListenNetworkTCP {
+			return nil, fmt.Errorf("can't serve TLS on passed %s socket %s", network, listener.Addr())
+		}
+		inherited = append(inherited, tlsListener{tls.NewListener(listener, tlsConfig)})
+	}
+
+	return inherited, nil
+}
+
+// removeStaleSocket removes the unix socket at path if nothing is listening on
+// it anymore. It fails if the socket is in use or the path is not a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s already exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial(config.ListenNetworkUnix, path); err == nil {
+		conn.Close() //nolint:errcheck
+		return fmt.Errorf("%s is already in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// setupSocket applies the configured file mode and owner to the unix socket.
+func setupSocket(path string, socket config.SocketConfig) error {
+	if socket.Mode != "" {
+		if err := os.Chmod(path, socket.FileMode()); err != nil {
+			return fmt.Errorf("failed to set socket mode: %w", err)
+		}
+	}
+
+	if socket.Owner != "" {
+		uid, gid, err := lookupOwner(socket.Owner)
+		if err != nil {
+			return fmt.Errorf("failed to look up socket owner: %w", err)
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("failed to set socket owner: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// lookupOwner resolves an owner in the form of user[:group] to numeric ids.
+// Both user and group can be names or numeric ids. A missing part resolves
+// to -1, which leaves it unchanged.
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+
+	if userName != "" {
+		id, err := strconv.Atoi(userName)
+		if err != nil {
+			u, lerr := user.Lookup(userName)
+			if lerr != nil {
+				return 0, 0, lerr
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, fmt.Errorf("user %q has a non-numeric id %q", userName, u.Uid)
+			}
+		}
+		uid = id
+	}
+
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, lerr := user.LookupGroup(groupName)
+			if lerr != nil {
+				return 0, 0, lerr
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("group %q has a non-numeric id %q", groupName, g.Gid)
+			}
+		}
+		gid = id
+	}
+
+	return uid, gid, nil
+}
+
+// listenerAddr returns the address of the listener in the form accepted by
+// config.ParseListenAddress.
+func listenerAddr(listener net.Listener) string {
+	addr := listener.Addr()
+	_, isTLS := listener.(tlsListener)
+	return config.ListenAddress{Network: addr.Network(), Address: addr.String(), TLS: isTLS}.String()
+}
+
+// closeListeners closes every listener, ignoring errors.
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close() //nolint:errcheck
+	}
+}
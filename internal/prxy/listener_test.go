@@ -0,0 +1,367 @@
+package prxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// TestListen_UnixSocket checks the setup and cleanup of unix socket listeners.
+func TestListen_UnixSocket(t *testing.T) {
+	t.Run("should_remove_stale_socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "prxy.sock")
+
+		// Leave a socket behind, as a crashed process would.
+		stale, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close() //nolint:errcheck
+
+		listener, err := listen(config.ListenAddress{Network: config.ListenNetworkUnix, Address: path}, config.SocketConfig{Mode: "0600"}, nil)
+		if err != nil {
+			t.Fatalf("listen()\nExpected stale socket to be replaced, but got: %v", err)
+		}
+		defer listener.Close() //nolint:errcheck
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat socket: %v", err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("Socket mode\nExpected %v, but got: %v", fs.FileMode(0o600), info.Mode().Perm())
+		}
+	})
+
+	t.Run("should_fail_when_socket_is_in_use", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "prxy.sock")
+		inUse, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer inUse.Close() //nolint:errcheck
+
+		_, err = listen(config.ListenAddress{Network: config.ListenNetworkUnix, Address: path}, config.SocketConfig{}, nil)
+		if err == nil || !strings.Contains(err.Error(), "already in use") {
+			t.Errorf("listen()\nExpected 'already in use' error, but got: %v", err)
+		}
+	})
+
+	t.Run("should_fail_when_path_is_not_a_socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "prxy.sock")
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		_, err := listen(config.ListenAddress{Network: config.ListenNetworkUnix, Address: path}, config.SocketConfig{}, nil)
+		if err == nil || !strings.Contains(err.Error(), "not a socket") {
+			t.Errorf("listen()\nExpected 'not a socket' error, but got: %v", err)
+		}
+	})
+}
+
+// TestPrxy_UnixSocket checks that requests are served over a unix socket and
+// that the socket is removed on shutdown.
+func TestPrxy_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prxy.sock")
+	cfg := newTestConfig("http://target.invalid", "http://proxy.invalid")
+	cfg.Listen = []string{"unix://" + path}
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := prxy.Listen(); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	if got := prxy.Addr(); got != cfg.Listen[0] {
+		t.Errorf("Addr()\nExpected %q, but got: %q", cfg.Listen[0], got)
+	}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- prxy.Run() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://prxy/_prxy/healthz")
+	if err != nil {
+		t.Fatalf("GET /_prxy/healthz failed: %v", err)
+	}
+	resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /_prxy/healthz\nExpected status 200, but got: %d", resp.StatusCode)
+	}
+
+	if err := prxy.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if err := <-errChan; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Run()\nExpected http.ErrServerClosed, but got: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected socket to be removed on shutdown, but got: %v", err)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key, returning their paths and a pool that trusts the certificate.
+func writeTestCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "prxy"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	certificate, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	return certPath, keyPath, pool
+}
+
+// TestPrxy_MultipleListeners checks that the same handler serves every
+// listener, including TLS ones, and that shutdown covers all of them.
+func TestPrxy_MultipleListeners(t *testing.T) {
+	certPath, keyPath, pool := writeTestCertificate(t)
+	socketPath := filepath.Join(t.TempDir(), "prxy.sock")
+
+	cfg := newTestConfig("http://target.invalid", "http://proxy.invalid")
+	cfg.Listen = []string{"127.0.0.1:0", "tls://127.0.0.1:0", "unix://" + socketPath}
+	cfg.TLS = config.TLSConfig{Cert: certPath, Key: keyPath}
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := prxy.Listen(); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- prxy.Run() }()
+
+	addrs := prxy.addrs()
+	if len(addrs) != len(cfg.Listen) {
+		t.Fatalf("Expected %d listeners, but got: %v", len(cfg.Listen), addrs)
+	}
+
+	// Test cases
+	tests := []struct {
+		name          string       // Name of the test case
+		url           string       // URL of the health endpoint
+		client        *http.Client // Client that reaches the listener
+		expectedProto int          // Expected major version of HTTP
+	}{
+		{
+			name:          "tcp_listener",
+			url:           "http://" + addrs[0] + "/_prxy/healthz",
+			client:        &http.Client{Transport: &http.Transport{}},
+			expectedProto: 1,
+		},
+		{
+			name:          "tls_listener",
+			url:           "https://" + strings.TrimPrefix(addrs[1], "tls://") + "/_prxy/healthz",
+			client:        &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+			expectedProto: 1,
+		},
+		{
+			name:          "tls_listener_http2",
+			url:           "https://" + strings.TrimPrefix(addrs[1], "tls://") + "/_prxy/healthz",
+			client:        &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}},
+			expectedProto: 2,
+		},
+		{
+			name: "unix_listener",
+			url:  "http://prxy/_prxy/healthz",
+			client: &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			}},
+			expectedProto: 1,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.client.Get(tt.url)
+			if err != nil {
+				t.Fatalf("GET %s failed: %v", tt.url, err)
+			}
+			resp.Body.Close() //nolint:errcheck
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET %s\nExpected status 200, but got: %d", tt.url, resp.StatusCode)
+			}
+			if resp.ProtoMajor != tt.expectedProto {
+				t.Errorf("GET %s\nExpected HTTP/%d, but got: %s", tt.url, tt.expectedProto, resp.Proto)
+			}
+			tt.client.CloseIdleConnections()
+		})
+	}
+
+	if err := prxy.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if err := <-errChan; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Run()\nExpected http.ErrServerClosed, but got: %v", err)
+	}
+	for _, addr := range addrs[:2] {
+		if conn, err := net.Dial("tcp", strings.TrimPrefix(addr, "tls://")); err == nil {
+			conn.Close() //nolint:errcheck
+			t.Errorf("Expected %s to be closed on shutdown", addr)
+		}
+	}
+}
+
+// TestPrxy_PassedListeners checks that the listeners passed by systemd socket
+// activation are served over TLS, and HTTP/3, when every configured address is
+// a TLS one.
+func TestPrxy_PassedListeners(t *testing.T) {
+	certPath, keyPath, pool := writeTestCertificate(t)
+
+	// Test cases
+	tests := []struct {
+		name            string   // Name of the test case
+		listen          []string // Configured listen addresses
+		network         string   // Network of the passed listener
+		http3           bool     // Whether HTTP/3 is enabled
+		expectError     bool     // true if an error is expected, false otherwise
+		expectedScheme  string   // Expected scheme of the passed listener
+		expectedPackets int      // Expected number of HTTP/3 sockets
+	}{
+		{
+			name:           "plain",
+			listen:         nil,
+			network:        "tcp",
+			expectedScheme: "http",
+		},
+		{
+			name:            "tls",
+			listen:          []string{"tls://127.0.0.1:0"},
+			network:         "tcp",
+			http3:           true,
+			expectedScheme:  "https",
+			expectedPackets: 1,
+		},
+		{
+			name:        "tls_and_plain",
+			listen:      []string{"127.0.0.1:0", "tls://127.0.0.1:0"},
+			network:     "tcp",
+			expectError: true,
+		},
+		{
+			name:        "tls_on_unix_socket",
+			listen:      []string{"tls://127.0.0.1:0"},
+			network:     "unix",
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig("http://target.invalid", "http://proxy.invalid")
+			cfg.Listen = tt.listen
+			cfg.TLS = config.TLSConfig{Cert: certPath, Key: keyPath}
+			cfg.Server.HTTP3 = tt.http3
+
+			prxy, err := New(cfg, newTestLogger(t))
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			address := "127.0.0.1:0"
+			if tt.network == "unix" {
+				address = filepath.Join(t.TempDir(), "prxy.sock")
+			}
+			listener, err := net.Listen(tt.network, address)
+			if err != nil {
+				t.Fatalf("net.Listen() failed: %v", err)
+			}
+			t.Cleanup(func() { listener.Close() }) //nolint:errcheck
+
+			err = prxy.Listen(listener)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Listen()\nExpected error: %v, but got: %v", tt.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			errChan := make(chan error, 1)
+			go func() { errChan <- prxy.Run() }()
+
+			if len(prxy.packets) != tt.expectedPackets {
+				t.Errorf("Expected %d HTTP/3 sockets, but got: %d", tt.expectedPackets, len(prxy.packets))
+			}
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+			url := tt.expectedScheme + "://" + listener.Addr().String() + "/_prxy/healthz"
+			resp, err := client.Get(url)
+			if err != nil {
+				t.Fatalf("GET %s failed: %v", url, err)
+			}
+			resp.Body.Close() //nolint:errcheck
+			client.CloseIdleConnections()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET %s\nExpected status 200, but got: %d", url, resp.StatusCode)
+			}
+
+			if err := prxy.Shutdown(context.Background()); err != nil {
+				t.Fatalf("Shutdown() failed: %v", err)
+			}
+			if err := <-errChan; !errors.Is(err, http.ErrServerClosed) {
+				t.Errorf("Run()\nExpected http.ErrServerClosed, but got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package prxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// mirrorHopHeaders are the request headers that are not copied to the
+// mirrored requests, since they only apply to the client connection or to
+// the main proxy.
+var mirrorHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// mirror sends a copy of the proxied requests to a secondary target, through
+// its own transport, and discards the responses.
+//
+// Request bodies are copied as the primary request reads them. Requests are
+// queued once the primary response has been served and sent by a pool of
+// workers, so mirroring never slows the primary path. When the queue is full,
+// or the body was not read in full within the buffer, requests are not
+// mirrored.
+type mirror struct {
+	logger   *logging.Logger
+	cfg      config.MirrorConfig
+	director func(*http.Request) // Rewrites a request to the secondary target
+	client   *http.Client
+	jobs     chan *mirrorJob
+	requests *metrics.Counter // Mirrored requests, by outcome
+}
+
+// mirrorJob is a request waiting to be mirrored, along with the result of
+// the primary request to compare with.
+type mirrorJob struct {
+	method         string
+	url            *url.URL
+	header         http.Header
+	body           []byte
+	primaryStatus  int
+	primaryLatency time.Duration
+}
+
+// newMirror creates a mirror that sends requests to the target through the
+// given transport.
+func newMirror(cfg config.MirrorConfig, targetURL *url.URL, transport http.RoundTripper, logger *logging.Logger, registry *metrics.Registry) *mirror {
+	return &mirror{
+		logger:   logger,
+		cfg:      cfg,
+		director: httputil.NewSingleHostReverseProxy(targetURL).Director,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		jobs:     make(chan *mirrorJob, cfg.Queue),
+		requests: registry.Counter("prxy_mirror_requests_total", "Total number of mirrored requests, by outcome.", "outcome"),
+	}
+}
+
+// wrap returns a handler that serves requests with next and queues a copy
+// of them to be mirrored.
+func (m *mirror) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Protocol switches, such as WebSockets, cannot be replayed, and
+		// gRPC calls may stream their messages, so they cannot be buffered.
+		if req.Header.Get("Upgrade") != "" || isGRPC(req) || rand.Float64() >= m.cfg.Sample {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		var body *mirrorBody
+		if req.Body != nil && req.Body != http.NoBody {
+			body = &mirrorBody{ReadCloser: req.Body, limit: m.cfg.Buffer}
+			req.Body = body
+		}
+
+		// Rewrite the URL like the primary target, so that escaped paths are
+		// kept as is.
+		mirrored := &http.Request{URL: new(url.URL), Header: make(http.Header)}
+		*mirrored.URL = *req.URL
+		m.director(mirrored)
+		job := &mirrorJob{
+			method: req.Method,
+			url:    mirrored.URL,
+			header: req.Header.Clone(),
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: rw}
+		next.ServeHTTP(sw, req)
+		job.primaryStatus = sw.status()
+		job.primaryLatency = time.Since(start)
+
+		if body != nil {
+			copied, complete := body.copied()
+			if !complete {
+				m.logger.Debug("Request body not read in full or too large, request not mirrored", "method", job.method, "path", job.url.Path)
+				return
+			}
+			job.body = copied
+		}
+
+		select {
+		case m.jobs <- job:
+		default:
+			m.requests.Inc("dropped")
+			m.logger.Debug("Mirror queue is full, request not mirrored", "method", job.method, "path", job.url.Path)
+		}
+	})
+}
+
+// run starts the workers, which send the queued requests until done is
+// closed.
+func (m *mirror) run(done <-chan struct{}) {
+	var wg sync.WaitGroup
+	for range m.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-m.jobs:
+					m.send(job)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// send mirrors a request and logs how its response compares to the primary
+// one.
+func (m *mirror) send(job *mirrorJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, job.method, job.url.String(), bytes.NewReader(job.body))
+	if err != nil {
+		m.requests.Inc("error")
+		m.logger.Warn("Failed to create mirror request", "method", job.method, "path", job.url.Path, "error", err)
+		return
+	}
+	req.Header = job.header
+	for _, header := range mirrorHopHeaders {
+		req.Header.Del(header)
+	}
+
+	start := time.Now()
+	resp, err := m.client.Do(req)
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close() //nolint:errcheck
+	}
+	latency := time.Since(start)
+
+	if err != nil {
+		m.requests.Inc("error")
+		m.logger.Warn("Mirror request failed", "method", job.method, "path", job.url.Path, "primary_status", job.primaryStatus, "error", err)
+		return
+	}
+
+	attrs := []any{
+		"method", job.method,
+		"path", job.url.Path,
+		"primary_status", job.primaryStatus,
+		"mirror_status", resp.StatusCode,
+		"primary_latency", job.primaryLatency,
+		"mirror_latency", latency,
+		"latency_diff", latency - job.primaryLatency,
+	}
+	if resp.StatusCode != job.primaryStatus {
+		m.requests.Inc("mismatch")
+		m.logger.Warn("Mirror status differs from primary", attrs...)
+		return
+	}
+	m.requests.Inc("match")
+	m.logger.Debug("Mirror status matches primary", attrs...)
+}
+
+// mirrorBody is an io.ReadCloser that keeps a copy of the request body while
+// the primary request reads it, as long as it doesn't exceed limit bytes.
+type mirrorBody struct {
+	io.ReadCloser
+	mu       sync.Mutex // Guards the copy, since the transport may still be reading
+	buf      bytes.Buffer
+	limit    int64
+	complete bool // Whether the whole body was copied
+	overflow bool // Whether the body exceeded the limit
+}
+
+// Read implements the io.Reader interface.
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+			b.complete = err == io.EOF
+		}
+	}
+	return n, err
+}
+
+// copied returns the copy of the body, and whether it is complete.
+func (b *mirrorBody) copied() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes(), b.complete
+}
+
+// statusWriter is an http.ResponseWriter that remembers the status code.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 && code >= 200 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that flushing and
+// hijacking keep working through an http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the status code of the response, 200 if nothing was
+// written.
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
@@ -0,0 +1,246 @@
+package prxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// TestNew_Mirror checks that a copy of the requests is sent to the mirror
+// target through its own proxy, without affecting the primary responses.
+func TestNew_Mirror(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(rw, "primary")
+	}))
+	t.Cleanup(primary.Close)
+
+	mirrored := make(chan string, 10)
+	secondary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mirrored <- req.Method + " " + req.Host + req.URL.RequestURI() + " " + string(body)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(secondary.Close)
+
+	proxy := newTestProxy(t)
+	mirrorProxy := newTestProxy(t)
+
+	cfg := newTestConfig(primary.URL, proxy.URL)
+	cfg.Mirror.Target = secondary.URL + "/v2"
+	cfg.Mirror.Proxy = mirrorProxy.URL
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go prxy.mirror.run(done)
+
+	rec := httptest.NewRecorder()
+	prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/items?id=1", strings.NewReader("payload")))
+	if rec.Body.String() != "primary" {
+		t.Fatalf("Expected the primary response, but got: %d %q", rec.Code, rec.Body)
+	}
+
+	select {
+	case got := <-mirrored:
+		expected := "POST " + strings.TrimPrefix(secondary.URL, "http://") + "/v2/items?id=1 payload"
+		if got != expected {
+			t.Errorf("Expected mirrored request %q, but got: %q", expected, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the request to be mirrored, but it was not")
+	}
+
+	if got := mirrorProxy.requests.Load(); got != 1 {
+		t.Errorf("Expected 1 request through the mirror proxy, but got: %d", got)
+	}
+	if got := proxy.requests.Load(); got != 1 {
+		t.Errorf("Expected 1 request through the primary proxy, but got: %d", got)
+	}
+
+	// The status difference is counted once the mirror response is read.
+	deadline := time.Now().Add(5 * time.Second)
+	for prxy.mirror.requests.Value("mismatch") != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := prxy.mirror.requests.Value("mismatch"); got != 1 {
+		t.Errorf("Expected 1 mismatch, but got: %v", got)
+	}
+}
+
+// TestMirror_Wrap checks the sampling and the bounded queue of the mirror.
+func TestMirror_Wrap(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusAccepted)
+	})
+
+	// Test cases
+	tests := []struct {
+		name            string  // Name of the test case
+		sample          float64 // Fraction of mirrored requests
+		queue           int     // Size of the queue
+		upgrade         bool    // Whether the requests upgrade the connection
+		expectedQueued  int     // Expected number of queued requests
+		expectedDropped float64 // Expected number of dropped requests
+	}{
+		{
+			name:           "queues_every_request",
+			sample:         1,
+			queue:          10,
+			expectedQueued: 3,
+		},
+		{
+			name:            "drops_when_queue_is_full",
+			sample:          1,
+			queue:           1,
+			expectedQueued:  1,
+			expectedDropped: 2,
+		},
+		{
+			name:           "sample_zero_mirrors_nothing",
+			sample:         0,
+			queue:          10,
+			expectedQueued: 0,
+		},
+		{
+			name:           "skips_upgrades",
+			sample:         1,
+			queue:          10,
+			upgrade:        true,
+			expectedQueued: 0,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults.Mirror
+			cfg.Sample = tt.sample
+			cfg.Queue = tt.queue
+			mirrorURL, _ := url.Parse("http://mirror.example.com")
+			m := newMirror(cfg, mirrorURL, http.DefaultTransport, newTestLogger(t), metrics.NewRegistry())
+			handler := m.wrap(next)
+
+			for range 3 {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.upgrade {
+					req.Header.Set("Upgrade", "websocket")
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != http.StatusAccepted {
+					t.Errorf("Expected the primary status %d, but got: %d", http.StatusAccepted, rec.Code)
+				}
+			}
+
+			if got := len(m.jobs); got != tt.expectedQueued {
+				t.Errorf("Expected %d queued requests, but got: %d", tt.expectedQueued, got)
+			}
+			if got := m.requests.Value("dropped"); got != tt.expectedDropped {
+				t.Errorf("Expected %v dropped requests, but got: %v", tt.expectedDropped, got)
+			}
+			if tt.expectedQueued > 0 {
+				if job := <-m.jobs; job.primaryStatus != http.StatusAccepted {
+					t.Errorf("Expected the primary status %d to be queued, but got: %d", http.StatusAccepted, job.primaryStatus)
+				}
+			}
+		})
+	}
+}
+
+// TestMirror_Body checks that request bodies are copied as the primary request
+// reads them, and that escaped paths reach the mirror as is.
+func TestMirror_Body(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name           string // Name of the test case
+		path           string // Requested path, escaped
+		body           string // Request body
+		read           int64  // Bytes of the body read by the primary, -1 for all
+		expectedQueued bool   // Whether the request is mirrored
+		expectedURL    string // Expected URL of the mirrored request
+	}{
+		{
+			name:           "copies_the_body_read_by_the_primary",
+			path:           "/items",
+			body:           "payload",
+			read:           -1,
+			expectedQueued: true,
+			expectedURL:    "http://mirror.example.com/v2/items",
+		},
+		{
+			name:           "keeps_escaped_paths",
+			path:           "/files/a%2Fb?x=1",
+			read:           -1,
+			expectedQueued: true,
+			expectedURL:    "http://mirror.example.com/v2/files/a%2Fb?x=1",
+		},
+		{
+			name:           "skips_partially_read_bodies",
+			path:           "/items",
+			body:           "payload",
+			read:           3,
+			expectedQueued: false,
+		},
+		{
+			name:           "skips_bodies_over_the_buffer",
+			path:           "/items",
+			body:           strings.Repeat("x", 32),
+			read:           -1,
+			expectedQueued: false,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults.Mirror
+			cfg.Sample = 1
+			cfg.Buffer = 16
+			mirrorURL, _ := url.Parse("http://mirror.example.com/v2")
+			m := newMirror(cfg, mirrorURL, http.DefaultTransport, newTestLogger(t), metrics.NewRegistry())
+
+			var received string
+			handler := m.wrap(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				var body []byte
+				if tt.read < 0 {
+					body, _ = io.ReadAll(req.Body)
+				} else {
+					body, _ = io.ReadAll(io.LimitReader(req.Body, tt.read))
+				}
+				received = string(body)
+			}))
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, tt.path, body))
+
+			if tt.read < 0 && received != tt.body {
+				t.Errorf("Expected the primary to read %q, but got: %q", tt.body, received)
+			}
+			if got := len(m.jobs) == 1; got != tt.expectedQueued {
+				t.Fatalf("Expected the request to be mirrored: %t, but got: %t", tt.expectedQueued, got)
+			}
+			if !tt.expectedQueued {
+				return
+			}
+			job := <-m.jobs
+			if got := job.url.String(); got != tt.expectedURL {
+				t.Errorf("Expected mirrored URL %q, but got: %q", tt.expectedURL, got)
+			}
+			if string(job.body) != tt.body {
+				t.Errorf("Expected mirrored body %q, but got: %q", tt.body, job.body)
+			}
+		})
+	}
+}
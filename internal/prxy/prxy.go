@@ -10,87 +10,479 @@ package prxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strconv"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/har"
 	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+	"github.com/Madh93/prxy/internal/systemd"
+	"github.com/Madh93/prxy/internal/version"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 // Prxy holds all the dependencies for the HTTP server.
 type Prxy struct {
-	logger *logging.Logger
-	server *http.Server
+	logger    *logging.Logger
+	server    *http.Server
+	addresses []config.ListenAddress // Configured addresses to listen on
+	socket    config.SocketConfig    // Unix socket listener settings
+	tlsConfig *tls.Config            // TLS settings for TLS listeners, nil if not needed
+	listeners []net.Listener         // Listeners to serve on, empty until Listen is called
+	http3     *http3.Server          // HTTP/3 server, nil if disabled
+	packets   []net.PacketConn       // UDP sockets served over HTTP/3, empty until Listen is called
+	balancer  *balancer              // Spreads the traffic among the targets
+	checker   *backendChecker        // Active health checks of the targets, nil if disabled
+	warmers   []*warmer              // Warm pools of connections to the targets, empty if disabled
+	recorder  *har.Writer            // HAR file the traffic is recorded to, nil if disabled
+	mirror    *mirror                // Mirror of the traffic to a secondary target, nil if disabled
+	upgrades  *upgradeTracker        // Connections upgraded to another protocol
+	ready     config.ReadyConfig     // Ready announcement settings
+	stdout    io.Writer              // Destination of the JSON ready line
+	done      chan struct{}          // Closed on Shutdown to stop background tasks
+	closeOnce sync.Once              // Ensures done is closed only once
 }
 
 // New creates and configures a new Prxy instance.
 func New(cfg *config.Config, logger *logging.Logger) (*Prxy, error) {
 	// 0. Ensure to parse URLs
-	parsedTargetURL, err := url.Parse(cfg.Target)
-	if err != nil {
-		return nil, fmt.Errorf("invalid target URL %q: %w", cfg.Target, err)
-	}
 	parsedProxyURL, err := url.Parse(cfg.Proxy)
 	if err != nil {
 		return nil, fmt.Errorf("invalid proxy URL %q: %w", cfg.Proxy, err)
 	}
 
-	// 1. Creates Reverse Proxy Handler
-	reverseProxyHandler := httputil.NewSingleHostReverseProxy(parsedTargetURL)
+	// 0.1 Registry of the metrics exposed on the admin endpoints.
+	registry := metrics.NewRegistry()
+
+	// 0.2 Spread the traffic among the targets.
+	lb, err := newBalancer(cfg.Balance, cfg.TargetBackends(), logger, registry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL: %w", err)
+	}
+	tunneled := slices.ContainsFunc(lb.backends, func(b *backend) bool { return b.url.Scheme == "https" })
+
+	// 1. Creates Reverse Proxy Handler, which rewrites every request to the
+	// backend chosen for it, including the Host header.
+	reverseProxyHandler := &httputil.ReverseProxy{Director: lb.direct}
+
+	// 1.1 Use the outbound HTTP Proxy for the transport. gRPC calls always
+	// attempt HTTP/2 with the target, since they require it.
+	transport := newTransport(cfg.Upstream, parsedProxyURL, tunneled)
+	reverseProxyHandler.Transport = newGRPCTransport(transport, logger, registry)
+
+	// 1.1.1 Eject the backends that fail consecutive requests, if enabled.
+	if cfg.Balance.Eject.Failures > 0 {
+		reverseProxyHandler.Transport = &ejectTransport{next: reverseProxyHandler.Transport, lb: lb}
+	}
+
+	// 1.1.2 Serve recorded responses instead of contacting the target, if
+	// enabled.
+	if cfg.Replay.Path != "" {
+		replay, err := newReplayTransport(cfg.Replay, reverseProxyHandler.Transport, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load recorded responses: %w", err)
+		}
+		reverseProxyHandler.Transport = replay
+	}
+
+	// 1.2 Retry failed round trips, if enabled.
+	if cfg.Retry.Attempts > 0 {
+		reverseProxyHandler.Transport = newRetryTransport(cfg.Retry, reverseProxyHandler.Transport, lb, logger)
+	}
+
+	// 1.3 Fail fast while the target is down, if enabled. The breaker wraps the
+	// retries, so that a request is counted once however many times it is
+	// retried.
+	if cfg.Breaker.Failures > 0 {
+		reverseProxyHandler.Transport = newBreakerTransport(cfg.Breaker, reverseProxyHandler.Transport, logger, registry)
+	}
+
+	// 1.3.1 Cap the requests in flight to the target, if enabled. The limit
+	// wraps the breaker, so that a request holds a single slot however many
+	// times it is retried, and rejections don't open the circuit.
+	if cfg.Limit.Concurrency > 0 {
+		reverseProxyHandler.Transport = newLimitTransport(cfg.Limit, reverseProxyHandler.Transport, logger, registry)
+	}
+
+	// 1.3.2 Serve cached responses, if enabled. The cache wraps the breaker, so
+	// that stale responses can be served while it is open.
+	if cfg.Cache.Store != config.CacheStoreNone {
+		cacheTransport, err := newCacheTransport(cfg.Cache, reverseProxyHandler.Transport, lb.backends, logger, registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open cache: %w", err)
+		}
+		reverseProxyHandler.Transport = cacheTransport
+	}
+
+	// 1.3.3 Collapse identical concurrent requests into a single round trip,
+	// if enabled globally or for any route. It wraps the cache, so that the
+	// misses of the same URL are fetched once.
+	if cfg.Coalesce.Enabled || slices.ContainsFunc(cfg.Routes, func(route config.RouteConfig) bool {
+		return route.Coalesce != nil && *route.Coalesce
+	}) {
+		reverseProxyHandler.Transport = newCoalesceTransport(cfg.Coalesce, reverseProxyHandler.Transport, logger, registry)
+	}
+
+	// 1.4 Keep connections to every target established, if enabled. When every
+	// response is replayed, the target is never contacted, so neither the
+	// warmers nor the probes below are needed.
+	offline := cfg.Replay.Path != "" && cfg.Replay.Unmatched != config.ReplayUnmatchedPassthrough
+	var connWarmers []*warmer
+	if cfg.Upstream.Warm.Conns > 0 && !offline {
+		for _, b := range lb.backends {
+			connWarmers = append(connWarmers, newWarmer(cfg.Upstream.Warm, b.url, transport, logger))
+		}
+	}
+
+	// 1.5 Probe the backends to keep the unhealthy ones out of the pool, if
+	// enabled.
+	var checker *backendChecker
+	if cfg.Balance.Check.Interval > 0 && !offline {
+		checker = newBackendChecker(cfg.Balance.Check, lb, transport, logger)
+	}
 
-	// 1.1 Use the outbound HTTP Proxy for the transport
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(parsedProxyURL),
+	// 1.6 Classify errors and answer them in the configured format.
+	errorHandler, err := newErrorResponder(cfg.Error, logger)
+	if err != nil {
+		return nil, err
 	}
-	reverseProxyHandler.Transport = transport
+	reverseProxyHandler.ErrorHandler = errorHandler.ServeError
 
-	// 1.2 Ensure the Host header is rewritten to the target's host.
-	originalDirector := reverseProxyHandler.Director
-	reverseProxyHandler.Director = func(req *http.Request) {
-		originalDirector(req)
-		req.Host = parsedTargetURL.Host
+	// 1.7 Report an authentication failure of the outbound proxy as such, since
+	// requests to HTTP targets are forwarded instead of tunneled. The
+	// alternative services of the target are dropped, since they are not
+	// served by prxy.
+	reverseProxyHandler.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Del("Alt-Svc")
+		if resp.StatusCode == http.StatusProxyAuthRequired && resp.Request.URL.Scheme == "http" {
+			return &proxyStatusError{statusCode: resp.StatusCode, status: resp.Status}
+		}
+		return nil
 	}
 
-	// 1.3 Custom error handler for better logging and response.
-	reverseProxyHandler.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-		logger.Error("Reverse proxy error", "url", req.URL.String(), "error", err)
-		http.Error(rw, "Proxy Error: "+err.Error(), http.StatusBadGateway)
+	// 1.8 Send a copy of the traffic to a secondary target, if enabled. The
+	// body settings of every route apply to the reverse proxy itself.
+	var proxyHandler http.Handler = lb.wrap(newBodyHandler(cfg.Body, reverseProxyHandler))
+	var trafficMirror *mirror
+	if cfg.Mirror.Target != "" {
+		mirrorTargetURL, err := url.Parse(cfg.Mirror.Target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror target URL %q: %w", cfg.Mirror.Target, err)
+		}
+		mirrorProxyURL := parsedProxyURL
+		if cfg.Mirror.Proxy != "" {
+			if mirrorProxyURL, err = url.Parse(cfg.Mirror.Proxy); err != nil {
+				return nil, fmt.Errorf("invalid mirror proxy URL %q: %w", cfg.Mirror.Proxy, err)
+			}
+		}
+		mirrorTransport := newTransport(cfg.Upstream, mirrorProxyURL, mirrorTargetURL.Scheme == "https")
+		trafficMirror = newMirror(cfg.Mirror, mirrorTargetURL, mirrorTransport, logger, registry)
+		proxyHandler = trafficMirror.wrap(proxyHandler)
 	}
 
-	// 2. Creates HTTP httpServer
+	// 1.9 Inject faults into the requests, if any is configured.
+	if len(cfg.Faults) > 0 || slices.ContainsFunc(cfg.Routes, func(route config.RouteConfig) bool { return len(route.Faults) > 0 }) {
+		injector, err := newFaultInjector(cfg.Faults, cfg.Routes, logger, registry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault: %w", err)
+		}
+		proxyHandler = injector.wrap(proxyHandler)
+	}
+
+	// 1.10 Look up the route of every request, so that its settings apply.
+	if len(cfg.Routes) > 0 {
+		proxyHandler = newRouter(cfg.Routes).wrap(proxyHandler)
+	}
+
+	// 1.11 Shape the traffic to simulate slow links, if enabled.
+	var trafficThrottler *throttler
+	if cfg.Throttle.Enabled() {
+		trafficThrottler = newThrottler(cfg.Throttle)
+		proxyHandler = trafficThrottler.wrap(proxyHandler)
+	}
+
+	// 1.12 Keep track of the upgraded connections, such as WebSockets, to close
+	// them on shutdown.
+	upgrades := newUpgradeTracker(cfg.Upgrade, logger, registry)
+	proxyHandler = upgrades.wrap(proxyHandler)
+
+	// 2. Creates the admin endpoints served under the reserved prefix. The
+	// readiness checks probe the first target, unless it is never contacted.
+	health := newHealthChecker(cfg.Health, lb.backends[0].url, parsedProxyURL, transport, logger)
+	health.offline = offline
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("GET "+cfg.Admin.Prefix+"/healthz", health.handleHealthz)
+	adminMux.HandleFunc("GET "+cfg.Admin.Prefix+"/readyz", health.handleReadyz)
+	adminMux.Handle("GET "+cfg.Admin.Prefix+"/metrics", registry.Handler())
+
+	// 2.1 Route requests to the admin endpoints or to the target.
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, cfg.Admin.Prefix+"/") {
+			adminMux.ServeHTTP(rw, req)
+			return
+		}
+		proxyHandler.ServeHTTP(rw, req)
+	})
+
+	// 3. Creates HTTP httpServer, shared by every listener.
 	httpServer := &http.Server{
-		Addr:    net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
-		Handler: reverseProxyHandler,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.Timeout.Header,
+		ReadTimeout:       cfg.Server.Timeout.Read,
+		WriteTimeout:      cfg.Server.Timeout.Write,
+		IdleTimeout:       cfg.Server.Timeout.Idle,
+	}
+
+	// 3.1 Serve HTTP/2 on TLS listeners and, with prior knowledge, on the
+	// others, if enabled.
+	httpServer.Protocols = new(http.Protocols)
+	httpServer.Protocols.SetHTTP1(true)
+	httpServer.Protocols.SetHTTP2(cfg.Server.HTTP2)
+	httpServer.Protocols.SetUnencryptedHTTP2(cfg.Server.H2C)
+
+	// 3.2 Keep the rate limits of every client connection, if enabled.
+	if cfg.Throttle.Connection.Download > 0 || cfg.Throttle.Connection.Upload > 0 {
+		httpServer.ConnContext = trafficThrottler.connContext
+	}
+
+	// 3.3 Load the certificate if any listener serves TLS.
+	addresses := cfg.ListenAddresses()
+	var tlsConfig *tls.Config
+	if slices.ContainsFunc(addresses, func(address config.ListenAddress) bool { return address.TLS }) {
+		certificate, err := tls.LoadX509KeyPair(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"http/1.1"},
+		}
+		if cfg.Server.HTTP2 {
+			tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+
+	// 3.4 Serve HTTP/3 on UDP alongside the TLS listeners, with the same
+	// handler and connection rate limits, and advertise it to their clients,
+	// if enabled.
+	var http3Server *http3.Server
+	if cfg.Server.HTTP3 {
+		http3Server = newHTTP3Server(handler, tlsConfig, cfg.Server.Timeout.Idle)
+		httpServer.Handler = advertiseHTTP3(http3Server, handler)
+		if httpServer.ConnContext != nil {
+			http3Server.ConnContext = func(ctx context.Context, _ *quic.Conn) context.Context {
+				return trafficThrottler.connContext(ctx, nil)
+			}
+		}
+	}
+
+	// 4. Record the traffic to a HAR file, if enabled. This is done last so that
+	// the file is not created if any of the previous steps fails.
+	var recorder *har.Writer
+	if cfg.Record.File != "" {
+		recorder, err = har.Create(cfg.Record.File, har.Creator{Name: config.AppName, Version: version.Get().AppVersion})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create record file: %w", err)
+		}
+		reverseProxyHandler.Transport = newRecordTransport(cfg.Record, reverseProxyHandler.Transport, recorder, logger)
 	}
 
 	// Create main Prxy struct.
 	prxy := &Prxy{
-		logger: logger,
-		server: httpServer,
+		logger:    logger,
+		server:    httpServer,
+		addresses: addresses,
+		socket:    cfg.Socket,
+		tlsConfig: tlsConfig,
+		http3:     http3Server,
+		balancer:  lb,
+		checker:   checker,
+		warmers:   connWarmers,
+		recorder:  recorder,
+		mirror:    trafficMirror,
+		upgrades:  upgrades,
+		ready:     cfg.Ready,
+		stdout:    os.Stdout,
+		done:      make(chan struct{}),
 	}
 
 	return prxy, nil
 }
 
-// Run starts the HTTP server and blocks until it exits.
-func (s Prxy) Run() error {
-	// This method always returns a non-nil error. When Shutdown() is called,
-	// it returns http.ErrServerClosed.
-	return s.server.ListenAndServe()
+// Listen prepares the listeners without serving them yet, so that Addr reports
+// the actual addresses even when a random port is requested. The supplied
+// listeners, such as the ones passed by systemd socket activation, are served
+// over TLS if every configured address is a TLS one. Without them, every
+// configured address is bound. The UDP port of every TLS listener is bound too
+// if HTTP/3 is enabled. Once listening, the addresses are announced as
+// configured.
+func (s *Prxy) Listen(listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		for _, address := range s.addresses {
+			listener, err := listen(address, s.socket, s.tlsConfig)
+			if err != nil {
+				closeListeners(listeners)
+				return fmt.Errorf("failed to listen on %s: %w", address, err)
+			}
+			listeners = append(listeners, listener)
+		}
+	} else {
+		inherited, err := inheritListeners(listeners, s.addresses, s.tlsConfig)
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("failed to use the passed sockets: %w", err)
+		}
+		listeners = inherited
+	}
+
+	if s.http3 != nil {
+		packets, err := listenPackets(listeners)
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("failed to listen for HTTP/3: %w", err)
+		}
+		s.packets = packets
+	}
+	s.listeners = listeners
+
+	if err := s.announceReady(); err != nil {
+		closeListeners(listeners)
+		closePacketConns(s.packets)
+		s.listeners, s.packets = nil, nil
+		return fmt.Errorf("failed to announce ready: %w", err)
+	}
+
+	return nil
+}
+
+// Run starts the HTTP server and blocks until it exits. The configured address
+// is bound first if Listen has not been called yet.
+func (s *Prxy) Run() error {
+	if len(s.listeners) == 0 {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+
+	go s.watchdog()
+	if s.checker != nil {
+		go s.checker.run(s.done)
+	}
+	for _, w := range s.warmers {
+		go w.run(s.done)
+	}
+	if s.mirror != nil {
+		go s.mirror.run(s.done)
+	}
+
+	// Serve every listener with the same server. This method always returns a
+	// non-nil error. When Shutdown() is called, it returns http.ErrServerClosed.
+	errChan := make(chan error, len(s.listeners)+len(s.packets))
+	for _, listener := range s.listeners {
+		go func() {
+			errChan <- s.server.Serve(listener)
+		}()
+	}
+	for _, conn := range s.packets {
+		s.logger.Info("Serving HTTP/3", "address", conn.LocalAddr().String())
+		go func() {
+			errChan <- s.http3.Serve(conn)
+		}()
+	}
+
+	return <-errChan
 }
 
 // Shutdown gracefully shuts down the server.
-func (s Prxy) Shutdown(ctx context.Context) error {
+func (s *Prxy) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.notify(systemd.StateStopping)
+
 	s.logger.Debug("Shutting down HTTP server...")
-	return s.server.Shutdown(ctx)
+	// The server doesn't wait for the upgraded connections, since they are
+	// hijacked, so they are closed on their own.
+	upgradesClosed := make(chan struct{})
+	go func() {
+		s.upgrades.shutdown(ctx)
+		close(upgradesClosed)
+	}()
+	// The HTTP/3 server is shut down at the same time, but it leaves the UDP
+	// sockets open.
+	http3Closed := make(chan error, 1)
+	go func() {
+		var err error
+		if s.http3 != nil {
+			err = s.http3.Shutdown(ctx)
+			closePacketConns(s.packets)
+		}
+		http3Closed <- err
+	}()
+	err := s.server.Shutdown(ctx)
+	<-upgradesClosed
+	if herr := <-http3Closed; err == nil {
+		err = herr
+	}
+
+	if s.recorder != nil {
+		if rerr := s.recorder.Close(); rerr != nil {
+			s.logger.Warn("Failed to close record file", "error", rerr)
+		}
+	}
+
+	if s.ready.File != "" {
+		if rerr := os.Remove(s.ready.File); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) {
+			s.logger.Warn("Failed to remove ready file", "path", s.ready.File, "error", rerr)
+		}
+	}
+
+	return err
+}
+
+// Reload applies the given configuration to the running server. Only the
+// weights of the targets can be changed at runtime; any other setting requires
+// a restart.
+func (s *Prxy) Reload(cfg *config.Config) {
+	s.balancer.reload(cfg.TargetBackends())
+	s.logger.Info("Configuration reloaded")
+}
+
+// Targets returns the URLs of the targets, without credentials.
+func (s *Prxy) Targets() []string {
+	return s.balancer.targets()
 }
 
-// Addr returns the network address the server is listening on.
-// Returns an empty string if the server is not running.
-func (s Prxy) Addr() string {
-	return s.server.Addr
+// Addr returns the network addresses the server is listening on, separated by
+// commas. Before Listen is called, it returns the configured addresses instead.
+func (s *Prxy) Addr() string {
+	if len(s.listeners) == 0 {
+		addrs := make([]string, 0, len(s.addresses))
+		for _, address := range s.addresses {
+			addrs = append(addrs, address.String())
+		}
+		return strings.Join(addrs, ", ")
+	}
+	return strings.Join(s.addrs(), ", ")
+}
+
+// addrs returns the network address of every listener.
+func (s *Prxy) addrs() []string {
+	addrs := make([]string, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listenerAddr(listener))
+	}
+	return addrs
 }
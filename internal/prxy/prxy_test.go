@@ -0,0 +1,292 @@
+package prxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// testProxy is an in-process outbound HTTP proxy that tunnels CONNECT requests
+// and forwards absolute-form requests, like wireproxy does. Forwarded responses
+// are flushed as they are read and keep their trailers.
+type testProxy struct {
+	*httptest.Server
+	connects atomic.Int64 // Number of CONNECT requests received
+	requests atomic.Int64 // Number of forwarded requests received
+	refuse   atomic.Bool  // Reject every request with 403 Forbidden
+}
+
+// newTestProxy starts a testProxy that is closed when the test ends.
+func newTestProxy(t *testing.T) *testProxy {
+	t.Helper()
+
+	p := &testProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodConnect {
+			p.connects.Add(1)
+		} else {
+			p.requests.Add(1)
+		}
+
+		if p.refuse.Load() {
+			http.Error(rw, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if req.Method != http.MethodConnect {
+			req.RequestURI = ""
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close() //nolint:errcheck
+			for key, values := range resp.Header {
+				rw.Header()[key] = values
+			}
+			for key := range resp.Trailer {
+				rw.Header().Add("Trailer", key)
+			}
+			rw.WriteHeader(resp.StatusCode)
+
+			// Flush every read, so that streamed responses are not held back.
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := resp.Body.Read(buf)
+				if n > 0 {
+					_, _ = rw.Write(buf[:n])
+					_ = http.NewResponseController(rw).Flush()
+				}
+				if err != nil {
+					break
+				}
+			}
+			for key, values := range resp.Trailer {
+				rw.Header()[key] = values
+			}
+			return
+		}
+
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, buf, err := http.NewResponseController(rw).Hijack()
+		if err != nil {
+			upstream.Close() //nolint:errcheck
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			_, _ = io.Copy(upstream, buf)
+			upstream.Close() //nolint:errcheck
+		}()
+		_, _ = io.Copy(conn, upstream)
+		conn.Close() //nolint:errcheck
+	}))
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// newTestLogger creates a logger that only reports errors.
+func newTestLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+
+	logger, err := logging.New(&config.LoggingConfig{Level: config.LogLevelError, Format: config.LogFormatText, Output: config.LogOutputStderr})
+	if err != nil {
+		t.Fatalf("logging.New() failed: %v", err)
+	}
+
+	return logger
+}
+
+// newTestConfig creates a valid configuration for the given target and proxy.
+func newTestConfig(target, proxy string) *config.Config {
+	cfg := config.Defaults
+	cfg.Target = target
+	cfg.Proxy = proxy
+	cfg.Host = "127.0.0.1"
+	return &cfg
+}
+
+// TestNew_Handler checks that requests are forwarded through the outbound
+// proxy, except for the ones under the admin prefix.
+func TestNew_Handler(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "target:"+req.Host+req.URL.Path)
+	}))
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+
+	prxy, err := New(newTestConfig(target.URL, proxy.URL), newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	// Test cases
+	tests := []struct {
+		name           string // Name of the test case
+		path           string // Requested path
+		expectedStatus int    // Expected status code
+		expectedBody   string // Expected body prefix
+	}{
+		{
+			name:           "forwards_to_target",
+			path:           "/some/path",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "target:" + strings.TrimPrefix(target.URL, "http://") + "/some/path",
+		},
+		{
+			name:           "serves_healthz",
+			path:           "/_prxy/healthz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		{
+			name:           "serves_readyz",
+			path:           "/_prxy/readyz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		{
+			name:           "serves_metrics",
+			path:           "/_prxy/metrics",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unknown_admin_endpoint",
+			path:           "/_prxy/unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "admin_prefix_lookalike_is_forwarded",
+			path:           "/_prxyz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "target:",
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("GET %s\nExpected status %d, but got: %d (%s)", tt.path, tt.expectedStatus, rec.Code, rec.Body)
+			}
+			if !strings.HasPrefix(rec.Body.String(), tt.expectedBody) {
+				t.Errorf("GET %s\nExpected body to start with %q, but got: %q", tt.path, tt.expectedBody, rec.Body)
+			}
+		})
+	}
+
+	if proxy.requests.Load() == 0 {
+		t.Error("Expected requests to go through the outbound proxy, but none did")
+	}
+}
+
+// TestNew_H2C checks that cleartext HTTP/2 is served with prior knowledge only
+// if enabled, and that streamed responses and their trailers make it through.
+func TestNew_H2C(t *testing.T) {
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Trailer", "X-Checksum")
+		_, _ = io.WriteString(rw, "first\n")
+		rw.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-req.Context().Done():
+			return
+		}
+		_, _ = io.WriteString(rw, "second\n")
+		rw.Header().Set("X-Checksum", "abc")
+	}))
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+
+	// Test cases
+	tests := []struct {
+		name        string // Name of the test case
+		h2c         bool   // Whether cleartext HTTP/2 is enabled
+		expectError bool   // Whether the HTTP/2 request is expected to fail
+	}{
+		{
+			name:        "should_refuse_http2_by_default",
+			h2c:         false,
+			expectError: true,
+		},
+		{
+			name:        "should_serve_http2_if_enabled",
+			h2c:         true,
+			expectError: false,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(target.URL, proxy.URL)
+			cfg.Server.H2C = tt.h2c
+
+			prxy, err := New(cfg, newTestLogger(t))
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+			server := httptest.NewUnstartedServer(prxy.server.Handler)
+			server.Config.Protocols = prxy.server.Protocols
+			server.Start()
+			t.Cleanup(server.Close)
+
+			protocols := new(http.Protocols)
+			protocols.SetUnencryptedHTTP2(true)
+			client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+			t.Cleanup(client.CloseIdleConnections)
+
+			resp, err := client.Get(server.URL + "/stream")
+			if tt.expectError {
+				if err == nil {
+					resp.Body.Close() //nolint:errcheck
+					t.Fatalf("Expected HTTP/2 request to fail, but got: %s", resp.Proto)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			defer resp.Body.Close() //nolint:errcheck
+
+			if resp.ProtoMajor != 2 {
+				t.Errorf("Expected HTTP/2, but got: %s", resp.Proto)
+			}
+
+			// The first line must arrive before the target finishes.
+			timer := time.AfterFunc(5*time.Second, func() { resp.Body.Close() }) //nolint:errcheck
+			line := make([]byte, len("first\n"))
+			_, err = io.ReadFull(resp.Body, line)
+			timer.Stop()
+			if err != nil || string(line) != "first\n" {
+				t.Fatalf("Expected the first line to be streamed, but got: %q (%v)", line, err)
+			}
+			close(release)
+
+			rest, _ := io.ReadAll(resp.Body)
+			if string(rest) != "second\n" {
+				t.Errorf("Expected the second line, but got: %q", rest)
+			}
+			if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+				t.Errorf("Expected trailer X-Checksum to be abc, but got: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package prxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Madh93/prxy/internal/systemd"
+)
+
+// readyLine is the machine-readable line printed on stdout once the server is
+// listening.
+type readyLine struct {
+	Status    string   `json:"status"`
+	Address   string   `json:"address"`   // First address, for the common single listener case
+	Addresses []string `json:"addresses"` // Every address the server is listening on
+	PID       int      `json:"pid"`
+}
+
+// announceReady writes the bound addresses to the ready file, prints the JSON
+// ready line, as configured, and notifies systemd that startup is finished.
+func (s *Prxy) announceReady() error {
+	addrs := s.addrs()
+
+	if s.ready.File != "" {
+		if err := writeFileAtomic(s.ready.File, []byte(strings.Join(addrs, "\n")+"\n")); err != nil {
+			return fmt.Errorf("failed to write ready file %q: %w", s.ready.File, err)
+		}
+	}
+
+	if s.ready.JSON {
+		line := readyLine{Status: "ready", Address: addrs[0], Addresses: addrs, PID: os.Getpid()}
+		if err := json.NewEncoder(s.stdout).Encode(line); err != nil {
+			return fmt.Errorf("failed to print ready line: %w", err)
+		}
+	}
+
+	s.notify(systemd.StateReady)
+
+	return nil
+}
+
+// notify sends the state to systemd, if prxy is run as a notify service.
+// Failures are only logged, as they must not stop the server.
+func (s *Prxy) notify(state string) {
+	sent, err := systemd.Notify(state)
+	if err != nil {
+		s.logger.Warn("Failed to notify systemd", "state", state, "error", err)
+		return
+	}
+	if sent {
+		s.logger.Debug("Notified systemd", "state", state)
+	}
+}
+
+// watchdog sends keep-alive notifications to systemd at half the watchdog
+// interval, if enabled, until the server is shut down.
+func (s *Prxy) watchdog() {
+	interval, err := systemd.WatchdogInterval()
+	if err != nil {
+		s.logger.Warn("Failed to read systemd watchdog interval", "error", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.notify(systemd.StateWatchdog)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path, so
+// that readers polling for path never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,129 @@
+package prxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPrxy_Listen checks that the actual bound address is reported and
+// announced when listening on a random port.
+func TestPrxy_Listen(t *testing.T) {
+	readyFile := filepath.Join(t.TempDir(), "prxy.addr")
+	cfg := newTestConfig("http://target.invalid", "http://proxy.invalid")
+	cfg.Ready.File = readyFile
+	cfg.Ready.JSON = true
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	var stdout bytes.Buffer
+	prxy.stdout = &stdout
+
+	if got := prxy.Addr(); got != "127.0.0.1:0" {
+		t.Errorf("Addr() before Listen()\nExpected %q, but got: %q", "127.0.0.1:0", got)
+	}
+
+	if err := prxy.Listen(); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	t.Cleanup(func() { prxy.listeners[0].Close() }) //nolint:errcheck
+
+	addr := prxy.Addr()
+	if _, port, _ := net.SplitHostPort(addr); port == "0" || port == "" {
+		t.Fatalf("Addr() after Listen()\nExpected the bound port, but got: %q", addr)
+	}
+
+	// Ready file
+	data, err := os.ReadFile(readyFile)
+	if err != nil {
+		t.Fatalf("Failed to read ready file: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != addr {
+		t.Errorf("Ready file\nExpected %q, but got: %q", addr, got)
+	}
+
+	// Ready line
+	var line readyLine
+	if err := json.Unmarshal(stdout.Bytes(), &line); err != nil {
+		t.Fatalf("Failed to decode ready line %q: %v", stdout.String(), err)
+	}
+	if line.Status != "ready" || line.Address != addr || line.PID != os.Getpid() {
+		t.Errorf("Ready line\nExpected ready status, address %q and pid %d, but got: %+v", addr, os.Getpid(), line)
+	}
+
+	// The ready file is removed on shutdown.
+	if err := prxy.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if _, err := os.Stat(readyFile); !os.IsNotExist(err) {
+		t.Errorf("Expected ready file to be removed on shutdown, but got: %v", err)
+	}
+}
+
+// TestPrxy_ListenSupplied checks that supplied listeners are served as is and
+// that systemd is notified about the lifecycle.
+func TestPrxy_ListenSupplied(t *testing.T) {
+	notifySocket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "notify.sock"), Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to create notify socket: %v", err)
+	}
+	t.Cleanup(func() { notifySocket.Close() }) //nolint:errcheck
+	t.Setenv("NOTIFY_SOCKET", notifySocket.LocalAddr().String())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	prxy, err := New(newTestConfig("http://target.invalid", "http://proxy.invalid"), newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := prxy.Listen(listener); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	if got := prxy.Addr(); got != listener.Addr().String() {
+		t.Errorf("Addr()\nExpected %q, but got: %q", listener.Addr(), got)
+	}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- prxy.Run() }()
+
+	resp, err := http.Get("http://" + prxy.Addr() + "/_prxy/healthz")
+	if err != nil {
+		t.Fatalf("GET /_prxy/healthz failed: %v", err)
+	}
+	resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /_prxy/healthz\nExpected status 200, but got: %d", resp.StatusCode)
+	}
+
+	if err := prxy.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if err := <-errChan; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Run()\nExpected http.ErrServerClosed, but got: %v", err)
+	}
+
+	for _, expected := range []string{"READY=1", "STOPPING=1"} {
+		buf := make([]byte, 64)
+		_ = notifySocket.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := notifySocket.Read(buf)
+		if err != nil {
+			t.Fatalf("Failed to read notification: %v", err)
+		}
+		if got := string(buf[:n]); got != expected {
+			t.Errorf("Notification\nExpected %q, but got: %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package prxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/har"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// recordTransport is an http.RoundTripper that records every request and
+// response pair to a HAR file. Entries are written once the response body has
+// been fully proxied, or as soon as the round trip fails.
+type recordTransport struct {
+	next   http.RoundTripper
+	cfg    config.RecordConfig
+	writer *har.Writer
+	logger *logging.Logger
+}
+
+// newRecordTransport wraps the next transport with a recorder that writes to
+// the given HAR writer.
+func newRecordTransport(cfg config.RecordConfig, next http.RoundTripper, writer *har.Writer, logger *logging.Logger) *recordTransport {
+	return &recordTransport{
+		next:   next,
+		cfg:    cfg,
+		writer: writer,
+		logger: logger,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (rt *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &recording{start: time.Now()}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), rec.timings.trace()))
+	if req.Body != nil && req.Body != http.NoBody {
+		rec.reqBody.max = rt.cfg.Body.Max
+		req.Body = &captureBody{ReadCloser: req.Body, capture: &rec.reqBody}
+	}
+
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		rt.add(rec.entry(req, nil, err, rt.cfg.Redact))
+		return nil, err
+	}
+
+	// The body of a protocol switch is the connection itself, which is not
+	// recorded.
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		rt.add(rec.entry(req, resp, nil, rt.cfg.Redact))
+		return resp, nil
+	}
+
+	rec.respBody.max = rt.cfg.Body.Max
+	resp.Body = &recordedBody{
+		captureBody: captureBody{ReadCloser: resp.Body, capture: &rec.respBody},
+		done:        func() { rt.add(rec.entry(req, resp, nil, rt.cfg.Redact)) },
+	}
+
+	return resp, nil
+}
+
+// add writes an entry, logging any failure instead of failing the request.
+func (rt *recordTransport) add(entry har.Entry) {
+	if err := rt.writer.Add(entry); err != nil {
+		rt.logger.Warn("Failed to record request", "url", entry.Request.URL, "error", err)
+	}
+}
+
+// recording holds what is captured of a single request and response pair.
+type recording struct {
+	start    time.Time
+	timings  traceTimings
+	reqBody  capture
+	respBody capture
+}
+
+// entry builds the HAR entry of the recording. Either resp or err is nil.
+func (rec *recording) entry(req *http.Request, resp *http.Response, err error, redact []string) har.Entry {
+	end := time.Now()
+
+	headers := har.Headers(req.Header, redact)
+	if req.Host != "" {
+		headers = append([]har.NameValue{{Name: "Host", Value: req.Host}}, headers...)
+	}
+
+	entry := har.Entry{
+		StartedDateTime: rec.start,
+		Time:            har.Milliseconds(end.Sub(rec.start)),
+		Request: har.Request{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     []har.Cookie{},
+			Headers:     headers,
+			QueryString: har.QueryString(req.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    rec.reqBody.total(),
+		},
+		Response: har.Response{
+			Cookies:     []har.Cookie{},
+			Headers:     []har.NameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: rec.timings.compute(rec.start, end),
+	}
+
+	if data, size, truncated := rec.reqBody.snapshot(); size > 0 {
+		text, encoding := har.EncodeBody(data)
+		entry.Request.PostData = &har.PostData{
+			MimeType:  req.Header.Get("Content-Type"),
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: truncated,
+		}
+	}
+
+	if err != nil {
+		entry.Response.Error = err.Error()
+		return entry
+	}
+
+	data, size, truncated := rec.respBody.snapshot()
+	text, encoding := har.EncodeBody(data)
+	entry.Response.Status = resp.StatusCode
+	entry.Response.StatusText = http.StatusText(resp.StatusCode)
+	entry.Response.HTTPVersion = resp.Proto
+	entry.Response.Headers = har.Headers(resp.Header, redact)
+	entry.Response.RedirectURL = resp.Header.Get("Location")
+	entry.Response.BodySize = size
+	entry.Response.Content = har.Content{
+		Size:      size,
+		MimeType:  resp.Header.Get("Content-Type"),
+		Text:      text,
+		Encoding:  encoding,
+		Truncated: truncated,
+	}
+
+	return entry
+}
+
+// capture keeps the first bytes of a body, up to a maximum, and counts the
+// total size. It is safe for concurrent use, since the transport may still be
+// sending the request body while the response is being read.
+type capture struct {
+	mu        sync.Mutex
+	max       int64
+	data      []byte
+	size      int64
+	truncated bool
+}
+
+// Write implements the io.Writer interface.
+func (c *capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size += int64(len(p))
+	if room := c.max - int64(len(c.data)); room > 0 {
+		c.data = append(c.data, p[:min(int64(len(p)), room)]...)
+	}
+	if int64(len(c.data)) < c.size {
+		c.truncated = true
+	}
+
+	return len(p), nil
+}
+
+// total returns the number of bytes captured so far.
+func (c *capture) total() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// snapshot returns the kept bytes, the total size and whether the bytes were
+// truncated.
+func (c *capture) snapshot() ([]byte, int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.data, c.size, c.truncated
+}
+
+// captureBody is a body that copies what is read from it to a capture.
+type captureBody struct {
+	io.ReadCloser
+	capture *capture
+}
+
+// Read implements the io.Reader interface.
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	_, _ = b.capture.Write(p[:n])
+	return n, err
+}
+
+// recordedBody is a response body that calls done once it is closed.
+type recordedBody struct {
+	captureBody
+	once sync.Once
+	done func()
+}
+
+// Close implements the io.Closer interface.
+func (b *recordedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// traceTimings records when each phase of a request happened. The times are
+// updated by every attempt, so that the last one is reported.
+type traceTimings struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+// trace returns the client trace that fills the timings.
+func (tt *traceTimings) trace() *httptrace.ClientTrace {
+	set := func(field *time.Time) {
+		tt.mu.Lock()
+		*field = time.Now()
+		tt.mu.Unlock()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { set(&tt.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { set(&tt.dnsDone) },
+		ConnectStart:         func(string, string) { set(&tt.connectStart) },
+		TLSHandshakeStart:    func() { set(&tt.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { set(&tt.tlsDone) },
+		GotConn:              func(httptrace.GotConnInfo) { set(&tt.gotConn) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&tt.wroteRequest) },
+		GotFirstResponseByte: func() { set(&tt.firstByte) },
+	}
+}
+
+// compute converts the timings to HAR timings. The connect phase covers the
+// connection to the proxy, the CONNECT tunnel and the TLS handshake, which is
+// also reported on its own, as the HAR format specifies.
+func (tt *traceTimings) compute(start, end time.Time) har.Timings {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	between := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return -1
+		}
+		return har.Milliseconds(to.Sub(from))
+	}
+
+	timings := har.Timings{
+		DNS:     between(tt.dnsStart, tt.dnsDone),
+		Connect: between(tt.connectStart, tt.gotConn),
+		SSL:     between(tt.tlsStart, tt.tlsDone),
+		Send:    max(0, between(tt.gotConn, tt.wroteRequest)),
+		Wait:    max(0, between(tt.wroteRequest, tt.firstByte)),
+		Receive: max(0, between(tt.firstByte, end)),
+	}
+
+	// Time spent before the connection was started, such as waiting for a
+	// free connection in the pool.
+	firstActivity := tt.gotConn
+	for _, t := range []time.Time{tt.dnsStart, tt.connectStart} {
+		if !t.IsZero() && (firstActivity.IsZero() || t.Before(firstActivity)) {
+			firstActivity = t
+		}
+	}
+	timings.Blocked = between(start, firstActivity)
+
+	return timings
+}
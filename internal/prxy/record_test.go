@@ -0,0 +1,123 @@
+package prxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Madh93/prxy/internal/har"
+)
+
+// TestNew_Record checks that the proxied traffic is recorded to a HAR file,
+// with capped bodies and redacted headers.
+func TestNew_Record(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Header().Set("Set-Cookie", "session=secret")
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(rw, "echo:"+string(body))
+	}))
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Record.File = path
+	cfg.Record.Body.Max = 8
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = prxy.Shutdown(context.Background()) })
+
+	req := httptest.NewRequest(http.MethodPost, "/items?b=2&a=1", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	prxy.server.Handler.ServeHTTP(rec, req)
+	if rec.Body.String() != "echo:hello" {
+		t.Fatalf("Expected the response to be proxied, but got: %d %q", rec.Code, rec.Body)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read record file: %v", err)
+	}
+	var doc har.HAR
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Expected a valid HAR document, but got: %v\n%s", err, data)
+	}
+	if len(doc.Log.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, but got: %d", len(doc.Log.Entries))
+	}
+	entry := doc.Log.Entries[0]
+
+	if entry.Request.Method != http.MethodPost || entry.Request.URL != target.URL+"/items?b=2&a=1" {
+		t.Errorf("Expected request POST %s/items?b=2&a=1, but got: %s %s", target.URL, entry.Request.Method, entry.Request.URL)
+	}
+	if len(entry.Request.QueryString) != 2 || entry.Request.QueryString[0].Name != "a" {
+		t.Errorf("Expected the query string to be recorded, but got: %v", entry.Request.QueryString)
+	}
+	if entry.Request.PostData == nil || entry.Request.PostData.Text != "hello" || entry.Request.BodySize != 5 {
+		t.Errorf("Expected request body %q of 5 bytes, but got: %+v (%d bytes)", "hello", entry.Request.PostData, entry.Request.BodySize)
+	}
+	if entry.Response.Status != http.StatusCreated {
+		t.Errorf("Expected response status %d, but got: %d", http.StatusCreated, entry.Response.Status)
+	}
+	if content := entry.Response.Content; content.Text != "echo:hel" || content.Size != 10 || !content.Truncated {
+		t.Errorf("Expected response body truncated to %q out of 10 bytes, but got: %+v", "echo:hel", content)
+	}
+	if entry.Timings.Wait < 0 || entry.Time <= 0 {
+		t.Errorf("Expected timings to be recorded, but got: %+v (total %v)", entry.Timings, entry.Time)
+	}
+
+	for _, headers := range [][]har.NameValue{entry.Request.Headers, entry.Response.Headers} {
+		for _, header := range headers {
+			if strings.Contains(header.Value, "secret") {
+				t.Errorf("Expected header %s to be redacted, but got: %q", header.Name, header.Value)
+			}
+		}
+	}
+}
+
+// TestNew_RecordError checks that failed requests are recorded without a
+// response.
+func TestNew_RecordError(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.refuse.Store(true)
+
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	cfg := newTestConfig("https://example.com", proxy.URL)
+	cfg.Record.File = path
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = prxy.Shutdown(context.Background()) })
+
+	prxy.server.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read record file: %v", err)
+	}
+	var doc har.HAR
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Expected a valid HAR document, but got: %v\n%s", err, data)
+	}
+	if len(doc.Log.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, but got: %d", len(doc.Log.Entries))
+	}
+	if response := doc.Log.Entries[0].Response; response.Status != 0 || response.Error == "" {
+		t.Errorf("Expected a failed response, but got: %+v", response)
+	}
+}
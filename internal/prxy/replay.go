@@ -0,0 +1,248 @@
+package prxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/har"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// errReplayUnmatched is returned for the requests without a recorded response
+// when the unmatched strategy is error.
+var errReplayUnmatched = errors.New("no recorded response matches the request")
+
+// replayTransport is an http.RoundTripper that serves recorded responses
+// instead of contacting the target.
+//
+// Requests are matched by method, path, query and, optionally, body. When a
+// request was recorded several times, its responses are served in the
+// recorded order, and the last one is repeated once they are exhausted.
+type replayTransport struct {
+	next   http.RoundTripper
+	cfg    config.ReplayConfig
+	logger *logging.Logger
+
+	mu        sync.Mutex
+	responses map[string][]har.Response // Recorded responses by request key
+	served    map[string]int            // Number of responses served by request key
+}
+
+// newReplayTransport loads the recorded responses and wraps the next
+// transport, which is only used for unmatched requests when the unmatched
+// strategy is passthrough.
+func newReplayTransport(cfg config.ReplayConfig, next http.RoundTripper, logger *logging.Logger) (*replayTransport, error) {
+	rt := &replayTransport{
+		next:      next,
+		cfg:       cfg,
+		logger:    logger,
+		responses: make(map[string][]har.Response),
+		served:    make(map[string]int),
+	}
+
+	paths, err := harFiles(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := 0
+	for _, path := range paths {
+		doc, err := har.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range doc.Log.Entries {
+			if err := rt.load(entry); err != nil {
+				return nil, fmt.Errorf("invalid entry in %s: %w", path, err)
+			}
+			entries++
+		}
+	}
+	logger.Info("Loaded recorded responses", "path", cfg.Path, "files", len(paths), "entries", entries)
+
+	return rt, nil
+}
+
+// harFiles returns the HAR file at path or, if path is a directory, the HAR
+// files in it.
+func harFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay path: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	// Glob returns the matches sorted by name.
+	paths, err := filepath.Glob(filepath.Join(path, "*.har"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no HAR files found in %s", path)
+	}
+
+	return paths, nil
+}
+
+// load indexes the response of a recorded entry. Entries of failed requests,
+// which have no response, are skipped.
+func (rt *replayTransport) load(entry har.Entry) error {
+	if entry.Response.Status == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if postData := entry.Request.PostData; postData != nil {
+		if body, err = har.DecodeBody(postData.Text, postData.Encoding); err != nil {
+			return err
+		}
+	}
+
+	key := rt.key(entry.Request.Method, u, body)
+	rt.responses[key] = append(rt.responses[key], entry.Response)
+
+	return nil
+}
+
+// key returns the key that identifies the recorded responses of a request.
+func (rt *replayTransport) key(method string, u *url.URL, body []byte) string {
+	// Encoding the query sorts it by key.
+	key := method + " " + u.EscapedPath() + "?" + u.Query().Encode()
+	if rt.cfg.Match.Body {
+		sum := sha256.Sum256(body)
+		key += " " + hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// nextResponse returns the next recorded response for the key, if any.
+func (rt *replayTransport) nextResponse(key string) (har.Response, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	responses := rt.responses[key]
+	if len(responses) == 0 {
+		return har.Response{}, false
+	}
+
+	i := min(rt.served[key], len(responses)-1)
+	rt.served[key]++
+
+	return responses[i], true
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (rt *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if rt.cfg.Match.Body && req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close() //nolint:errcheck
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	recorded, ok := rt.nextResponse(rt.key(req.Method, req.URL, body))
+	if ok && recorded.Content.Truncated {
+		// Serving part of the body as if it were complete would mislead the
+		// client.
+		rt.logger.Warn("Recorded response body was truncated", "method", req.Method, "url", req.URL.String(), "status", recorded.Status)
+		return textResponse(req, http.StatusBadGateway, "Recorded body truncated.\n"), nil
+	}
+	if ok {
+		rt.logger.Debug("Replaying recorded response", "method", req.Method, "url", req.URL.String(), "status", recorded.Status)
+		return replayResponse(req, recorded)
+	}
+
+	rt.logger.Warn("No recorded response matches the request", "method", req.Method, "url", req.URL.String(), "strategy", rt.cfg.Unmatched)
+
+	switch rt.cfg.Unmatched {
+	case config.ReplayUnmatchedPassthrough:
+		return rt.next.RoundTrip(req)
+	case config.ReplayUnmatchedError:
+		return nil, errReplayUnmatched
+	}
+
+	return textResponse(req, http.StatusNotFound, "No recorded response matches the request.\n"), nil
+}
+
+// textResponse builds a plain text response to the request.
+func textResponse(req *http.Request, status int, text string) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(text)),
+		ContentLength: int64(len(text)),
+		Request:       req,
+	}
+}
+
+// replayResponse builds a response from a recorded one.
+func replayResponse(req *http.Request, recorded har.Response) (*http.Response, error) {
+	body, err := har.DecodeBody(recorded.Content.Text, recorded.Content.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded body: %w", err)
+	}
+
+	header := make(http.Header, len(recorded.Headers))
+	for _, h := range recorded.Headers {
+		// Redacted values are useless, and the length is set from the body.
+		if h.Value == har.Redacted || isHopHeader(h.Name) {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+
+	// Browsers record bodies decompressed, unlike prxy, so the encoding only
+	// applies if the body is actually compressed.
+	if strings.EqualFold(header.Get("Content-Encoding"), "gzip") && !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		header.Del("Content-Encoding")
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(recorded.Status) + " " + http.StatusText(recorded.Status),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// isHopHeader reports whether a recorded header is about the recorded
+// connection rather than the response.
+func isHopHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Content-Length", "Transfer-Encoding", "Connection", "Keep-Alive":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package prxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/har"
+)
+
+// writeTestHAR writes a HAR file with the given entries.
+func writeTestHAR(t *testing.T, path string, entries ...har.Entry) {
+	t.Helper()
+
+	w, err := har.Create(path, har.Creator{Name: "test", Version: "test"})
+	if err != nil {
+		t.Fatalf("har.Create() failed: %v", err)
+	}
+	for _, entry := range entries {
+		if err := w.Add(entry); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+}
+
+// testEntry creates a recorded entry with a text response.
+func testEntry(method, url, reqBody string, status int, respBody string, headers ...har.NameValue) har.Entry {
+	entry := har.Entry{
+		Request: har.Request{Method: method, URL: url},
+		Response: har.Response{
+			Status:  status,
+			Headers: append([]har.NameValue{{Name: "Content-Type", Value: "text/plain"}}, headers...),
+			Content: har.Content{Text: respBody},
+		},
+	}
+	if reqBody != "" {
+		entry.Request.PostData = &har.PostData{Text: reqBody}
+	}
+	return entry
+}
+
+// TestNew_Replay checks that recorded responses are served without contacting
+// the target or the outbound proxy.
+func TestNew_Replay(t *testing.T) {
+	dir := t.TempDir()
+	writeTestHAR(t, filepath.Join(dir, "a.har"),
+		testEntry(http.MethodGet, "https://api.example.com/base/items?b=2&a=1", "", http.StatusOK, "first"),
+		testEntry(http.MethodGet, "https://api.example.com/base/items?a=1&b=2", "", http.StatusOK, "second"),
+		testEntry(http.MethodPost, "https://api.example.com/base/items", `{"name":"x"}`, http.StatusCreated, "created x",
+			har.NameValue{Name: "Set-Cookie", Value: har.Redacted}, har.NameValue{Name: "Content-Length", Value: "999"}),
+	)
+	truncated := testEntry(http.MethodGet, "https://api.example.com/base/large", "", http.StatusOK, "part")
+	truncated.Response.Content.Truncated = true
+	writeTestHAR(t, filepath.Join(dir, "b.har"),
+		testEntry(http.MethodPost, "https://api.example.com/base/items", `{"name":"y"}`, http.StatusCreated, "created y"),
+		testEntry(http.MethodGet, "https://api.example.com/base/gzip", "", http.StatusOK, "plain", har.NameValue{Name: "Content-Encoding", Value: "gzip"}),
+		truncated,
+	)
+
+	// Test cases
+	tests := []struct {
+		name         string                 // Name of the test case
+		path         string                 // HAR file or directory
+		matchBody    bool                   // Whether the body must match
+		unmatched    config.ReplayUnmatched // Strategy for unmatched requests
+		requests     []string               // Requests as "METHOD path [body]"
+		expectations []string               // Expected responses as "status body"
+	}{
+		{
+			name:      "serves_in_recorded_order_then_repeats_last",
+			path:      dir,
+			unmatched: config.ReplayUnmatchedNotFound,
+			requests:  []string{"GET /items?a=1&b=2", "GET /items?b=2&a=1", "GET /items?a=1&b=2"},
+			expectations: []string{
+				"200 first",
+				"200 second",
+				"200 second",
+			},
+		},
+		{
+			name:         "matches_body",
+			path:         dir,
+			matchBody:    true,
+			unmatched:    config.ReplayUnmatchedNotFound,
+			requests:     []string{`POST /items {"name":"y"}`, `POST /items {"name":"x"}`, `POST /items {"name":"z"}`},
+			expectations: []string{"201 created y", "201 created x", "404 No recorded response matches the request."},
+		},
+		{
+			name:         "ignores_body_by_default",
+			path:         filepath.Join(dir, "b.har"),
+			unmatched:    config.ReplayUnmatchedNotFound,
+			requests:     []string{`POST /items {"name":"z"}`},
+			expectations: []string{"201 created y"},
+		},
+		{
+			name:         "drops_encoding_of_decompressed_bodies",
+			path:         dir,
+			unmatched:    config.ReplayUnmatchedNotFound,
+			requests:     []string{"GET /gzip"},
+			expectations: []string{"200 plain"},
+		},
+		{
+			name:         "fails_truncated_bodies",
+			path:         dir,
+			unmatched:    config.ReplayUnmatchedNotFound,
+			requests:     []string{"GET /large"},
+			expectations: []string{"502 Recorded body truncated."},
+		},
+		{
+			name:         "unmatched_error",
+			path:         dir,
+			unmatched:    config.ReplayUnmatchedError,
+			requests:     []string{"GET /missing"},
+			expectations: []string{"502 Proxy Error: No recorded response matches the request."},
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig("https://api.example.com/base", "http://127.0.0.1:1")
+			cfg.Replay = config.ReplayConfig{Path: tt.path, Match: config.ReplayMatch{Body: tt.matchBody}, Unmatched: tt.unmatched}
+			prxy, err := New(cfg, newTestLogger(t))
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			for i, request := range tt.requests {
+				method, rest, _ := strings.Cut(request, " ")
+				path, body, _ := strings.Cut(rest, " ")
+
+				rec := httptest.NewRecorder()
+				prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+				got := fmt.Sprintf("%d %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+				if got != tt.expectations[i] {
+					t.Errorf("%s\nExpected %q, but got: %q", request, tt.expectations[i], got)
+				}
+				if rec.Header().Get("Set-Cookie") != "" || rec.Header().Get("Content-Encoding") != "" {
+					t.Errorf("%s\nExpected redacted and stale headers to be dropped, but got: %v", request, rec.Header())
+				}
+			}
+		})
+	}
+}
+
+// TestNew_ReplayPassthrough checks that unmatched requests are forwarded to
+// the target with the passthrough strategy.
+func TestNew_ReplayPassthrough(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(rw, "live")
+	}))
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	writeTestHAR(t, path, testEntry(http.MethodGet, target.URL+"/recorded", "", http.StatusOK, "recorded"))
+
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Replay = config.ReplayConfig{Path: path, Unmatched: config.ReplayUnmatchedPassthrough}
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	for path, expected := range map[string]string{"/recorded": "recorded", "/other": "live"} {
+		rec := httptest.NewRecorder()
+		prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Body.String() != expected {
+			t.Errorf("GET %s\nExpected body %q, but got: %q", path, expected, rec.Body)
+		}
+	}
+	if got := proxy.requests.Load(); got != 1 {
+		t.Errorf("Expected 1 request through the proxy, but got: %d", got)
+	}
+}
+
+// TestNew_ReplayOffline checks that the target is never probed when every
+// response is replayed, and that prxy reports ready anyway.
+func TestNew_ReplayOffline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	writeTestHAR(t, path, testEntry(http.MethodGet, "https://api.example.com/recorded", "", http.StatusOK, "recorded"))
+
+	cfg := newTestConfig("https://api.example.com", "http://127.0.0.1:1")
+	cfg.Replay = config.ReplayConfig{Path: path, Unmatched: config.ReplayUnmatchedNotFound}
+	cfg.Upstream.Warm.Conns = 1
+	cfg.Balance.Check.Interval = time.Second
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if prxy.checker != nil || len(prxy.warmers) != 0 {
+		t.Errorf("Expected no health checks nor warmers, but got: %v, %v", prxy.checker, prxy.warmers)
+	}
+
+	rec := httptest.NewRecorder()
+	prxy.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_prxy/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /_prxy/readyz\nExpected status 200, but got: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestNew_ReplayInvalidPath checks that a missing recording fails early.
+func TestNew_ReplayInvalidPath(t *testing.T) {
+	for _, path := range []string{filepath.Join(t.TempDir(), "missing.har"), t.TempDir()} {
+		cfg := newTestConfig("https://api.example.com", "http://127.0.0.1:1")
+		cfg.Replay.Path = path
+		if _, err := New(cfg, newTestLogger(t)); err == nil {
+			t.Errorf("New() with replay path %s\nExpected error, but got none", path)
+		}
+	}
+}
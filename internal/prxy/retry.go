@@ -0,0 +1,183 @@
+package prxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// retryTransport is an http.RoundTripper that retries the upstream round trips
+// that failed with a connection error or a retryable status, such as the ones
+// caused by brief tunnel blips.
+//
+// Only requests with a retryable method are retried, as long as their body
+// fits in the buffer, so it can be replayed. Retries are sent to another
+// backend than the ones that failed, if any, and spaced by an exponential
+// backoff with jitter. No retry starts after the deadline, and the ones in
+// flight are canceled at the deadline.
+type retryTransport struct {
+	next   http.RoundTripper
+	cfg    config.RetryConfig
+	lb     *balancer // Chooses the backend of the retries, nil to keep the same one
+	logger *logging.Logger
+}
+
+// newRetryTransport wraps the next transport with the retry policy.
+func newRetryTransport(cfg config.RetryConfig, next http.RoundTripper, lb *balancer, logger *logging.Logger) *retryTransport {
+	return &retryTransport{
+		next:   next,
+		cfg:    cfg,
+		lb:     lb,
+		logger: logger,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// gRPC calls may stream their messages, so they cannot be buffered.
+	if !t.cfg.Retries(req.Method) || isGRPC(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	body, replayable, err := bufferBody(req, t.cfg.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		return t.next.RoundTrip(req)
+	}
+
+	deadline := time.Now().Add(t.cfg.Deadline)
+	var failed []*backend
+	target := req
+	for attempt := 0; ; attempt++ {
+		attemptReq, cancel := target, context.CancelFunc(nil)
+		if attempt > 0 {
+			// Retries must end by the deadline, not only start before it.
+			var ctx context.Context
+			ctx, cancel = context.WithDeadline(target.Context(), deadline)
+			attemptReq = target.Clone(ctx)
+		} else if body != nil {
+			attemptReq = req.Clone(req.Context())
+		}
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		if cancel != nil {
+			if err != nil {
+				cancel()
+			} else {
+				resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			}
+		}
+
+		reason := t.retryReason(req, resp, err)
+		if reason == "" || attempt >= t.cfg.Attempts {
+			return resp, err
+		}
+		wait := t.backoff(attempt)
+		if time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+
+		// Discard the failed response before trying again.
+		if resp != nil {
+			drainBody(resp)
+		}
+
+		if t.lb != nil {
+			if b, ok := attemptReq.Context().Value(backendContextKey{}).(*backend); ok {
+				failed = append(failed, b)
+			}
+			target = t.lb.retarget(req, failed)
+		}
+
+		t.logger.Warn("Retrying upstream request", "method", req.Method, "url", target.URL.String(), "attempt", attempt+1, "wait", wait, "reason", reason)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryReason returns why the round trip should be retried, or an empty
+// string if it should not.
+func (t *retryTransport) retryReason(req *http.Request, resp *http.Response, err error) string {
+	if err != nil {
+		// Errors caused by the client going away are not worth retrying.
+		if req.Context().Err() != nil {
+			return ""
+		}
+		return err.Error()
+	}
+
+	if slices.Contains(t.cfg.Statuses, resp.StatusCode) {
+		return resp.Status
+	}
+
+	return ""
+}
+
+// backoff returns the wait before the given retry attempt: an exponentially
+// growing duration, capped by the maximum wait, of which the second half is
+// randomized to spread the retries of concurrent requests.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	wait := t.cfg.Wait.Max
+	if attempt < 32 && t.cfg.Wait.Min<<attempt < t.cfg.Wait.Max {
+		wait = t.cfg.Wait.Min << attempt
+	}
+
+	half := wait / 2
+	return half + rand.N(wait-half+1)
+}
+
+// cancelBody is an io.ReadCloser that cancels the context of its request once
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements the io.Closer interface.
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// bufferBody reads the request body into memory, so that it can be replayed,
+// as long as it doesn't exceed the limit. If it does, the body is restored so
+// the request can still be sent once, and replayable is false.
+func bufferBody(req *http.Request, limit int64) (body []byte, replayable bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+
+	body, err = io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(body)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false, nil
+	}
+
+	return body, true, req.Body.Close()
+}
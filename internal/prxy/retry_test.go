@@ -0,0 +1,259 @@
+package prxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// TestRetryTransport_RoundTrip checks which failed round trips are retried,
+// and that request bodies are replayed on every attempt.
+func TestRetryTransport_RoundTrip(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name             string // Name of the test case
+		method           string // Request method
+		body             string // Request body
+		failures         int64  // Number of initial requests that fail
+		drop             bool   // Fail by dropping the connection instead of returning 503
+		expectedStatus   int    // Expected status code, 0 if an error is expected
+		expectedRequests int64  // Expected number of requests received by the target
+	}{
+		{
+			name:             "no_failures",
+			method:           http.MethodGet,
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 1,
+		},
+		{
+			name:             "retries_status",
+			method:           http.MethodGet,
+			failures:         2,
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 3,
+		},
+		{
+			name:             "retries_dropped_connection",
+			method:           http.MethodGet,
+			failures:         2,
+			drop:             true,
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 3,
+		},
+		{
+			name:             "replays_body",
+			method:           http.MethodPut,
+			body:             "payload",
+			failures:         1,
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 2,
+		},
+		{
+			name:             "body_larger_than_buffer_is_not_retried",
+			method:           http.MethodPut,
+			body:             strings.Repeat("x", 64),
+			failures:         1,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 1,
+		},
+		{
+			name:             "non_idempotent_method_is_not_retried",
+			method:           http.MethodPost,
+			body:             "payload",
+			failures:         1,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 1,
+		},
+		{
+			name:             "attempts_exhausted_returns_last_status",
+			method:           http.MethodGet,
+			failures:         5,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 4,
+		},
+		{
+			name:             "attempts_exhausted_returns_last_error",
+			method:           http.MethodGet,
+			failures:         5,
+			drop:             true,
+			expectedRequests: 4,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				n := requests.Add(1)
+				body, _ := io.ReadAll(req.Body)
+				if string(body) != tt.body {
+					t.Errorf("Attempt %d\nExpected body %q, but got: %q", n, tt.body, body)
+				}
+				if n <= tt.failures {
+					if tt.drop {
+						conn, _, _ := http.NewResponseController(rw).Hijack()
+						conn.Close() //nolint:errcheck
+						return
+					}
+					rw.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				_, _ = io.WriteString(rw, "ok")
+			}))
+			t.Cleanup(target.Close)
+
+			cfg := config.Defaults.Retry
+			cfg.Attempts = 3
+			cfg.Statuses = []int{http.StatusServiceUnavailable}
+			cfg.Buffer = 32
+			cfg.Wait = config.RetryWait{Min: time.Millisecond, Max: 5 * time.Millisecond}
+			rt := newRetryTransport(cfg, &http.Transport{}, nil, newTestLogger(t))
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(tt.method, target.URL, body)
+			req.RequestURI = ""
+
+			resp, err := rt.RoundTrip(req)
+			if tt.expectedStatus == 0 {
+				if err == nil {
+					resp.Body.Close() //nolint:errcheck
+					t.Fatalf("RoundTrip()\nExpected error, but got status: %d", resp.StatusCode)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("RoundTrip()\nExpected status %d, but got error: %v", tt.expectedStatus, err)
+				}
+				resp.Body.Close() //nolint:errcheck
+				if resp.StatusCode != tt.expectedStatus {
+					t.Errorf("RoundTrip()\nExpected status %d, but got: %d", tt.expectedStatus, resp.StatusCode)
+				}
+			}
+
+			if got := requests.Load(); got != tt.expectedRequests {
+				t.Errorf("Expected %d requests to the target, but got: %d", tt.expectedRequests, got)
+			}
+		})
+	}
+}
+
+// TestRetryTransport_Deadline checks that no retry starts after the deadline.
+func TestRetryTransport_Deadline(t *testing.T) {
+	var requests atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(target.Close)
+
+	cfg := config.Defaults.Retry
+	cfg.Attempts = 10
+	cfg.Statuses = []int{http.StatusBadGateway}
+	cfg.Deadline = 50 * time.Millisecond
+	cfg.Wait = config.RetryWait{Min: 40 * time.Millisecond, Max: 40 * time.Millisecond}
+	rt := newRetryTransport(cfg, &http.Transport{}, nil, newTestLogger(t))
+
+	req := httptest.NewRequest(http.MethodGet, target.URL, nil)
+	req.RequestURI = ""
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip()\nExpected no error, but got: %v", err)
+	}
+	resp.Body.Close() //nolint:errcheck
+
+	if got := requests.Load(); got < 2 || got > 3 {
+		t.Errorf("Expected the deadline to stop retries after 2 or 3 requests, but got: %d", got)
+	}
+}
+
+// TestRetryTransport_Backends checks that retries are sent to another backend
+// than the one that failed.
+func TestRetryTransport_Backends(t *testing.T) {
+	var failures atomic.Int64
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		failures.Add(1)
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(failing.Close)
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok:"+req.URL.RequestURI())
+	}))
+	t.Cleanup(healthy.Close)
+
+	lb, err := newBalancer(config.Defaults.Balance, []config.BackendConfig{{URL: failing.URL + "/base"}, {URL: healthy.URL + "/base"}}, newTestLogger(t), metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("newBalancer() failed: %v", err)
+	}
+	cfg := config.Defaults.Retry
+	cfg.Attempts = 3
+	cfg.Statuses = []int{http.StatusBadGateway}
+	cfg.Wait = config.RetryWait{Min: time.Millisecond, Max: 5 * time.Millisecond}
+	rt := newRetryTransport(cfg, &http.Transport{}, lb, newTestLogger(t))
+
+	// Direct the request to the failing backend, like the reverse proxy does.
+	req := httptest.NewRequest(http.MethodGet, "/path%2Fescaped?x=1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), backendContextKey{}, lb.backends[0]))
+	lb.direct(req)
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip()\nExpected no error, but got: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close() //nolint:errcheck
+
+	if expected := "ok:/base/path%2Fescaped?x=1"; string(body) != expected {
+		t.Errorf("RoundTrip()\nExpected body %q, but got: %q", expected, body)
+	}
+	if got := failures.Load(); got != 1 {
+		t.Errorf("Expected 1 request to the failing backend, but got: %d", got)
+	}
+}
+
+// TestRetryTransport_DeadlineInFlight checks that a retry still in flight at
+// the deadline is canceled.
+func TestRetryTransport_DeadlineInFlight(t *testing.T) {
+	var requests atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if requests.Add(1) == 1 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		<-req.Context().Done()
+	}))
+	t.Cleanup(target.Close)
+
+	cfg := config.Defaults.Retry
+	cfg.Attempts = 3
+	cfg.Statuses = []int{http.StatusBadGateway}
+	cfg.Deadline = 100 * time.Millisecond
+	cfg.Wait = config.RetryWait{Min: time.Millisecond, Max: time.Millisecond}
+	rt := newRetryTransport(cfg, &http.Transport{}, nil, newTestLogger(t))
+
+	req := httptest.NewRequest(http.MethodGet, target.URL, nil)
+	req.RequestURI = ""
+	start := time.Now()
+	resp, err := rt.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close() //nolint:errcheck
+		t.Fatalf("RoundTrip()\nExpected error, but got status: %d", resp.StatusCode)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RoundTrip()\nExpected the deadline to be exceeded, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the retry to be canceled at the deadline, but it took: %v", elapsed)
+	}
+}
@@ -0,0 +1,51 @@
+package prxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// routeContextKey is the context key of the route matched by a request.
+type routeContextKey struct{}
+
+// router matches every request against the configured routes, so that the
+// settings of its route can be looked up further down the chain.
+type router struct {
+	routes []config.RouteConfig
+}
+
+// newRouter creates a router for the given routes.
+func newRouter(routes []config.RouteConfig) *router {
+	return &router{routes: routes}
+}
+
+// wrap returns a handler that stores the route of every request in its
+// context before serving it with next.
+func (r *router) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if route, ok := r.match(req.URL.Path); ok {
+			req = req.WithContext(context.WithValue(req.Context(), routeContextKey{}, route))
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// match returns the route with the longest path matching the request path.
+func (r *router) match(path string) (config.RouteConfig, bool) {
+	var matched config.RouteConfig
+	found := false
+	for _, route := range r.routes {
+		if route.Match(path) && (!found || len(route.Path) > len(matched.Path)) {
+			matched, found = route, true
+		}
+	}
+	return matched, found
+}
+
+// requestRoute returns the route matched by the request, if any.
+func requestRoute(req *http.Request) (config.RouteConfig, bool) {
+	route, ok := req.Context().Value(routeContextKey{}).(config.RouteConfig)
+	return route, ok
+}
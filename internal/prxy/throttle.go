@@ -0,0 +1,282 @@
+package prxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// maxThrottleChunk is the maximum number of bytes sent at once by a throttled
+// body, so that the traffic flows steadily instead of in bursts.
+const maxThrottleChunk = 32 << 10 // 32 KiB
+
+// rateLimiter paces a flow of bytes to a maximum rate, shared by all the
+// bodies it throttles.
+type rateLimiter struct {
+	rate int64            // Bytes per second
+	now  func() time.Time // Current time, replaceable in tests
+	mu   sync.Mutex       // Guards next
+	next time.Time        // When the next bytes may be sent
+}
+
+// newRateLimiter creates a rateLimiter for the given rate, or nil if the rate
+// is not limited.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, now: time.Now}
+}
+
+// reserve books the sending of n bytes, returning how long to wait before
+// sending them.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+
+	return wait
+}
+
+// rateLimiters are the limiters a body is throttled by, such as the ones of
+// the service and of the client connection.
+type rateLimiters []*rateLimiter
+
+// chunk returns the number of bytes to send at once, about a tenth of a
+// second worth of the slowest rate.
+func (ls rateLimiters) chunk() int {
+	chunk := int64(maxThrottleChunk)
+	for _, l := range ls {
+		chunk = min(chunk, max(1, l.rate/10))
+	}
+	return int(chunk)
+}
+
+// wait waits until all the limiters allow sending n bytes, or the context is
+// done.
+func (ls rateLimiters) wait(ctx context.Context, n int) error {
+	var wait time.Duration
+	for _, l := range ls {
+		wait = max(wait, l.reserve(n))
+	}
+	return sleep(ctx, wait)
+}
+
+// write writes b to w no faster than the limiters allow, in chunks, unless
+// there are no limiters.
+func (ls rateLimiters) write(ctx context.Context, w io.Writer, b []byte) (int, error) {
+	if len(ls) == 0 {
+		return w.Write(b)
+	}
+
+	chunk := ls.chunk()
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), chunk)
+		if err := ls.wait(ctx, n); err != nil {
+			return written, err
+		}
+		n, err := w.Write(b[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// connThrottle holds the rate limiters of a client connection.
+type connThrottle struct {
+	download *rateLimiter
+	upload   *rateLimiter
+}
+
+// connThrottleContextKey is the context key of the rate limiters of a client
+// connection.
+type connThrottleContextKey struct{}
+
+// throttler shapes the traffic between the clients and the target: it limits
+// the rate of the request and response bodies, for all the clients and for
+// every connection, and delays the requests.
+type throttler struct {
+	cfg      config.ThrottleConfig
+	download *rateLimiter // Shared by all the responses, nil if not limited
+	upload   *rateLimiter // Shared by all the requests, nil if not limited
+}
+
+// newThrottler creates a throttler for the given configuration.
+func newThrottler(cfg config.ThrottleConfig) *throttler {
+	return &throttler{
+		cfg:      cfg,
+		download: newRateLimiter(cfg.Download),
+		upload:   newRateLimiter(cfg.Upload),
+	}
+}
+
+// connContext returns a context holding the rate limiters of a new client
+// connection. It is meant to be used as the http.Server ConnContext, and for
+// the HTTP/3 connections, which are not a net.Conn.
+func (t *throttler) connContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connThrottleContextKey{}, &connThrottle{
+		download: newRateLimiter(t.cfg.Connection.Download),
+		upload:   newRateLimiter(t.cfg.Connection.Upload),
+	})
+}
+
+// wrap returns a handler that delays every request and throttles its bodies
+// before serving it with next.
+func (t *throttler) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := sleep(req.Context(), t.latency()); err != nil {
+			return
+		}
+
+		download, upload := t.limiters(req.Context())
+		if len(upload) > 0 && req.Body != nil && req.Body != http.NoBody {
+			req.Body = &throttledReader{ReadCloser: req.Body, ctx: req.Context(), limiters: upload}
+		}
+		if len(download) > 0 || len(upload) > 0 {
+			rw = &throttledWriter{ResponseWriter: rw, ctx: req.Context(), download: download, upload: upload}
+		}
+
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// latency returns the delay of a request, varied at random by the jitter.
+func (t *throttler) latency() time.Duration {
+	return jittered(t.cfg.Latency, t.cfg.Jitter)
+}
+
+// limiters returns the rate limiters of the responses and the requests of a
+// client connection.
+func (t *throttler) limiters(ctx context.Context) (download, upload rateLimiters) {
+	if t.download != nil {
+		download = append(download, t.download)
+	}
+	if t.upload != nil {
+		upload = append(upload, t.upload)
+	}
+	if conn, ok := ctx.Value(connThrottleContextKey{}).(*connThrottle); ok {
+		if conn.download != nil {
+			download = append(download, conn.download)
+		}
+		if conn.upload != nil {
+			upload = append(upload, conn.upload)
+		}
+	}
+	return download, upload
+}
+
+// throttledReader is a request body read no faster than its limiters allow.
+type throttledReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters rateLimiters
+}
+
+// Read implements the io.Reader interface.
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiters.chunk() {
+		p = p[:r.limiters.chunk()]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.limiters.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter is an http.ResponseWriter that writes the body no faster
+// than its limiters allow. If the connection is hijacked, it is throttled in
+// both directions.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	download rateLimiters
+	upload   rateLimiters
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	return w.download.write(w.ctx, w.ResponseWriter, b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that the reverse
+// proxy can flush it.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack implements the http.Hijacker interface, so that upgraded connections,
+// such as WebSockets, are throttled like the bodies.
+func (w *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &throttledConn{Conn: conn, ctx: w.ctx, download: w.download, upload: w.upload}, brw, nil
+}
+
+// throttledConn is an upgraded client connection that sends and receives no
+// faster than its limiters allow.
+type throttledConn struct {
+	net.Conn
+	ctx      context.Context
+	download rateLimiters // Limiters of the data sent to the client
+	upload   rateLimiters // Limiters of the data received from the client
+}
+
+// Read implements the net.Conn interface.
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if len(c.upload) == 0 {
+		return c.Conn.Read(b)
+	}
+	return (&throttledReader{ReadCloser: c.Conn, ctx: c.ctx, limiters: c.upload}).Read(b)
+}
+
+// Write implements the net.Conn interface.
+func (c *throttledConn) Write(b []byte) (int, error) {
+	return c.download.write(c.ctx, c.Conn, b)
+}
+
+// jittered returns the duration varied at random by up to jitter in both
+// directions, and never negative.
+func jittered(d, jitter time.Duration) time.Duration {
+	if jitter > 0 {
+		d += rand.N(2*jitter+1) - jitter
+	}
+	return max(0, d)
+}
+
+// sleep waits for the duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
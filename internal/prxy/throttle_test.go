@@ -0,0 +1,267 @@
+package prxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// TestRateLimiter_Reserve checks that bytes are paced to the rate.
+func TestRateLimiter_Reserve(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(1000)
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance  time.Duration // Time passed before the reservation
+		bytes    int           // Reserved bytes
+		expected time.Duration // Expected wait
+	}{
+		{advance: 0, bytes: 100, expected: 0},
+		{advance: 0, bytes: 100, expected: 100 * time.Millisecond},
+		{advance: 50 * time.Millisecond, bytes: 500, expected: 150 * time.Millisecond},
+		{advance: time.Second, bytes: 100, expected: 0},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		if got := l.reserve(step.bytes); got != step.expected {
+			t.Errorf("Step %d: reserve(%d)\nExpected: %v\nGot: %v", i, step.bytes, step.expected, got)
+		}
+	}
+
+	if newRateLimiter(0) != nil {
+		t.Error("newRateLimiter(0)\nExpected nil for an unlimited rate")
+	}
+}
+
+// TestThrottler_Wrap checks that the bodies are throttled and the requests
+// delayed.
+func TestThrottler_Wrap(t *testing.T) {
+	body := strings.Repeat("x", 2000)
+
+	tests := []struct {
+		name    string
+		cfg     config.ThrottleConfig
+		minimum time.Duration // Minimum time to serve the request
+	}{
+		// 4 chunks of 500 bytes at 5000 B/s, the first one sent right away.
+		{name: "download", cfg: config.ThrottleConfig{Download: 5000}, minimum: 300 * time.Millisecond},
+		{name: "upload", cfg: config.ThrottleConfig{Upload: 5000}, minimum: 300 * time.Millisecond},
+		{name: "connection_download", cfg: config.ThrottleConfig{Connection: config.ThrottleConnection{Download: 5000}}, minimum: 300 * time.Millisecond},
+		{name: "latency", cfg: config.ThrottleConfig{Latency: 200 * time.Millisecond}, minimum: 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newThrottler(tt.cfg)
+			handler := th.wrap(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				received, _ := io.ReadAll(req.Body)
+				if len(received) != len(body) {
+					t.Errorf("Expected request body of %d bytes, but got: %d", len(body), len(received))
+				}
+				_, _ = io.WriteString(rw, body)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body))
+			req = req.WithContext(th.connContext(req.Context(), nil))
+			rec := httptest.NewRecorder()
+
+			start := time.Now()
+			handler.ServeHTTP(rec, req)
+			if elapsed := time.Since(start); elapsed < tt.minimum {
+				t.Errorf("Expected the request to take at least %v, but took: %v", tt.minimum, elapsed)
+			}
+			if rec.Body.String() != body {
+				t.Errorf("Expected response body of %d bytes, but got: %d", len(body), rec.Body.Len())
+			}
+		})
+	}
+}
+
+// TestThrottler_ConnectionLimiters checks that every connection gets its own
+// rate limiters besides the shared ones.
+func TestThrottler_ConnectionLimiters(t *testing.T) {
+	th := newThrottler(config.ThrottleConfig{Download: 1000, Connection: config.ThrottleConnection{Download: 100, Upload: 10}})
+
+	first := th.connContext(context.Background(), nil)
+	second := th.connContext(context.Background(), nil)
+
+	download, upload := th.limiters(first)
+	if len(download) != 2 || len(upload) != 1 {
+		t.Fatalf("Expected 2 download and 1 upload limiters, but got: %d and %d", len(download), len(upload))
+	}
+	if download[0] != th.download {
+		t.Error("Expected the shared download limiter first")
+	}
+
+	other, _ := th.limiters(second)
+	if other[0] != download[0] || other[1] == download[1] {
+		t.Error("Expected the connections to share the service limiter only")
+	}
+	if got := download.chunk(); got != 10 {
+		t.Errorf("Expected chunks of 10 bytes, but got: %d", got)
+	}
+}
+
+// TestThrottler_Hijack checks that upgraded connections, such as WebSockets,
+// are throttled in both directions.
+func TestThrottler_Hijack(t *testing.T) {
+	th := newThrottler(config.ThrottleConfig{Connection: config.ThrottleConnection{Download: 1000, Upload: 1000}})
+	received := make(chan int, 1)
+	server := httptest.NewUnstartedServer(th.wrap(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		conn, brw, err := http.NewResponseController(rw).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() failed: %v", err)
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		_ = brw.Flush()
+		_, _ = conn.Write([]byte(strings.Repeat("x", 300)))
+		n, _ := io.ReadFull(conn, make([]byte, 300))
+		received <- n
+	})))
+	server.Config.ConnContext = th.connContext
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	start := time.Now()
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, but got: %d", resp.StatusCode)
+	}
+	downloaded, _ := io.ReadFull(br, make([]byte, 300))
+	downloadTime := time.Since(start)
+	_, _ = io.WriteString(conn, strings.Repeat("y", 300))
+	uploaded := <-received
+
+	if downloaded != 300 || uploaded != 300 {
+		t.Fatalf("Expected 300 bytes in both directions, but got: %d and %d", downloaded, uploaded)
+	}
+	// 300 bytes are sent in chunks of 100 at 1000 bytes per second.
+	if downloadTime < 150*time.Millisecond {
+		t.Errorf("Expected the download to take at least 150ms, but took: %v", downloadTime)
+	}
+	if uploadTime := time.Since(start) - downloadTime; uploadTime < 150*time.Millisecond {
+		t.Errorf("Expected the upload to take at least 150ms, but took: %v", uploadTime)
+	}
+}
+
+// TestThrottler_Jitter checks that the latency varies within the jitter.
+func TestThrottler_Jitter(t *testing.T) {
+	th := newThrottler(config.ThrottleConfig{Latency: 100 * time.Millisecond, Jitter: 150 * time.Millisecond})
+
+	for range 1000 {
+		if got := th.latency(); got < 0 || got > 250*time.Millisecond {
+			t.Fatalf("Expected latency between 0s and 250ms, but got: %v", got)
+		}
+	}
+}
+
+// TestNew_Throttle checks that the connections to the proxy are throttled
+// when connection rates are configured.
+func TestNew_Throttle(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(rw, strings.Repeat("x", 2000))
+	}))
+	defer target.Close()
+
+	proxy := newTestProxy(t)
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Throttle.Connection.Download = 5000
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	server := httptest.NewUnstartedServer(prxy.server.Handler)
+	server.Config.ConnContext = prxy.server.ConnContext
+	server.Start()
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/file")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	body, _ := io.ReadAll(resp.Body)
+
+	if len(body) != 2000 {
+		t.Errorf("Expected body of 2000 bytes, but got: %d", len(body))
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Expected the request to take at least 300ms, but took: %v", elapsed)
+	}
+}
+
+// TestNew_ThrottleHTTP3 checks that the HTTP/3 connections to the proxy are
+// throttled too when connection rates are configured.
+func TestNew_ThrottleHTTP3(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(rw, strings.Repeat("x", 2000))
+	}))
+	defer target.Close()
+	proxy := newTestProxy(t)
+	certPath, keyPath, pool := writeTestCertificate(t)
+
+	cfg := newTestConfig(target.URL, proxy.URL)
+	cfg.Listen = []string{"tls://127.0.0.1:0"}
+	cfg.TLS.Cert, cfg.TLS.Key = certPath, keyPath
+	cfg.Server.HTTP3 = true
+	cfg.Throttle.Connection.Download = 5000
+
+	prxy, err := New(cfg, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := prxy.Listen(); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	go prxy.Run()                             //nolint:errcheck
+	defer prxy.Shutdown(context.Background()) //nolint:errcheck
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer transport.Close() //nolint:errcheck
+	client := &http.Client{Transport: transport}
+
+	start := time.Now()
+	resp, err := client.Get("https://" + strings.TrimPrefix(prxy.addrs()[0], "tls://") + "/file")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.ProtoMajor != 3 {
+		t.Fatalf("Expected HTTP/3, but got: %s", resp.Proto)
+	}
+	if len(body) != 2000 {
+		t.Errorf("Expected body of 2000 bytes, but got: %d", len(body))
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Expected the request to take at least 300ms, but took: %v", elapsed)
+	}
+}
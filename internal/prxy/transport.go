@@ -0,0 +1,175 @@
+package prxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// newTransport creates the transport that connects to the targets through the
+// outbound proxy, bounded by the configured timeouts and pool limits. Tunneled
+// must be true if any target is reached with a CONNECT request, that is, over
+// HTTPS.
+func newTransport(cfg config.UpstreamConfig, proxyURL *url.URL, tunneled bool) *http.Transport {
+	var transport *http.Transport
+	dialer := &net.Dialer{
+		Timeout:   cfg.Timeout.Dial,
+		KeepAlive: 30 * time.Second,
+	}
+
+	// Requests to HTTPS targets are tunneled with a CONNECT request over the
+	// connection to the proxy, which is bounded by the connect timeout.
+	// Connections that forward requests to HTTP targets are dialed the same
+	// way, so the deadline is only kept for CONNECT requests, which are told
+	// apart by their first bytes.
+	var dialContext, dialTLSContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	dialContext = dialer.DialContext
+	if tunneled && cfg.Timeout.Connect > 0 {
+		dialDeadline := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := conn.SetDeadline(time.Now().Add(cfg.Timeout.Connect)); err != nil {
+				conn.Close() //nolint:errcheck
+				return nil, err
+			}
+			return conn, nil
+		}
+		dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialDeadline(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &connectDeadlineConn{Conn: conn}, nil
+		}
+
+		// With an HTTPS proxy, the first bytes written to the dialed
+		// connection are the TLS handshake, so the handshake is done here and
+		// the first bytes written through it are looked at instead.
+		if proxyURL != nil && proxyURL.Scheme == "https" {
+			dialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dialDeadline(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				// The TLS configuration may be set once the transport is
+				// created, so it is read on every dial.
+				tlsConn, err := handshakeProxy(ctx, conn, addr, transport.TLSClientConfig, cfg.Timeout.TLS)
+				if err != nil {
+					conn.Close() //nolint:errcheck
+					return nil, err
+				}
+				return &connectDeadlineConn{Conn: tlsConn}, nil
+			}
+		}
+	}
+
+	transport = &http.Transport{
+		Proxy:                  http.ProxyURL(proxyURL),
+		DialContext:            dialContext,
+		DialTLSContext:         dialTLSContext,
+		TLSHandshakeTimeout:    cfg.Timeout.TLS,
+		ResponseHeaderTimeout:  cfg.Timeout.Response,
+		IdleConnTimeout:        cfg.Timeout.Idle,
+		ExpectContinueTimeout:  1 * time.Second,
+		MaxIdleConns:           cfg.Idle.Conns,
+		MaxIdleConnsPerHost:    cfg.Host.Idle.Conns,
+		MaxConnsPerHost:        cfg.Host.Conns,
+		MaxResponseHeaderBytes: 1 << 20,
+		// A custom dialer disables HTTP/2 unless it is forced.
+		ForceAttemptHTTP2: cfg.HTTP2,
+		OnProxyConnectResponse: func(_ context.Context, _ *url.URL, _ *http.Request, resp *http.Response) error {
+			if resp.StatusCode != http.StatusOK {
+				return &proxyStatusError{statusCode: resp.StatusCode, status: resp.Status}
+			}
+			return nil
+		},
+	}
+
+	return transport
+}
+
+// handshakeProxy runs the TLS handshake with an HTTPS proxy over the dialed
+// connection, with the TLS configuration of the transport, as the transport
+// would do without a custom TLS dialer.
+func handshakeProxy(ctx context.Context, conn net.Conn, addr string, cfg *tls.Config, timeout time.Duration) (*tls.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	// Requests to the proxy are sent over HTTP/1.1.
+	cfg.NextProtos = nil
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// connectDeadlineConn is a net.Conn whose deadline, set on dial, is cleared as
+// soon as the proxy starts answering the CONNECT request. From then on, the
+// tunnel is only bounded by the transport timeouts. If the connection is not
+// used for a CONNECT request, but to forward requests to an HTTP target, the
+// deadline is cleared before the first request is sent.
+type connectDeadlineConn struct {
+	net.Conn
+	written atomic.Bool // Whether something has been written yet
+	cleared sync.Once   // Clears the deadline once
+}
+
+// Read implements the io.Reader interface, clearing the deadline on the first
+// successful read.
+func (c *connectDeadlineConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if derr := c.clearDeadline(); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return n, err
+}
+
+// Write implements the io.Writer interface, clearing the deadline before the
+// first write unless it is a CONNECT request.
+func (c *connectDeadlineConn) Write(b []byte) (int, error) {
+	if !c.written.Swap(true) && !bytes.HasPrefix(b, []byte(http.MethodConnect+" ")) {
+		if err := c.clearDeadline(); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+// clearDeadline clears the deadline of the connection, only the first time it
+// is called.
+func (c *connectDeadlineConn) clearDeadline() (err error) {
+	c.cleared.Do(func() {
+		err = c.Conn.SetDeadline(time.Time{})
+	})
+	return err
+}
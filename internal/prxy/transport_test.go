@@ -0,0 +1,246 @@
+package prxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// TestNewTransport_ConnectTimeout checks that a proxy that never answers the
+// CONNECT request doesn't hang requests, while tunnels that were established
+// are not affected by the timeout.
+func TestNewTransport_ConnectTimeout(t *testing.T) {
+	cfg := config.Defaults.Upstream
+	cfg.Timeout.Connect = 200 * time.Millisecond
+
+	t.Run("should_fail_when_proxy_does_not_answer", func(t *testing.T) {
+		// A proxy that accepts connections but never answers.
+		silent, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { silent.Close() }) //nolint:errcheck
+		go func() {
+			for {
+				conn, err := silent.Accept()
+				if err != nil {
+					return
+				}
+				t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+			}
+		}()
+
+		targetURL, _ := url.Parse("https://target.invalid")
+		proxyURL, _ := url.Parse("http://" + silent.Addr().String())
+		client := &http.Client{Transport: newTransport(cfg, proxyURL, true)}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, targetURL.String(), nil)
+
+		start := time.Now()
+		if _, err := client.Do(req); err == nil {
+			t.Fatal("Expected request to fail, but it succeeded")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected request to fail after the connect timeout, but it took: %v", elapsed)
+		}
+	})
+
+	t.Run("should_fail_when_https_proxy_does_not_answer", func(t *testing.T) {
+		// An HTTPS proxy that completes the TLS handshake but never answers.
+		certPath, keyPath, pool := writeTestCertificate(t)
+		certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			t.Fatalf("Failed to load certificate: %v", err)
+		}
+		silent, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { silent.Close() }) //nolint:errcheck
+		go func() {
+			for {
+				conn, err := silent.Accept()
+				if err != nil {
+					return
+				}
+				t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+				go func() { _ = conn.(*tls.Conn).Handshake() }()
+			}
+		}()
+
+		proxyURL, _ := url.Parse("https://" + silent.Addr().String())
+		transport := newTransport(cfg, proxyURL, true)
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client := &http.Client{Transport: transport}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://target.invalid", nil)
+
+		start := time.Now()
+		if _, err := client.Do(req); err == nil {
+			t.Fatal("Expected request to fail, but it succeeded")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected request to fail after the connect timeout, but it took: %v", elapsed)
+		}
+	})
+
+	t.Run("should_keep_established_tunnels", func(t *testing.T) {
+		target := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(rw, "ok")
+		}))
+		t.Cleanup(target.Close)
+		proxy := newTestProxy(t)
+
+		proxyURL, _ := url.Parse(proxy.URL)
+		transport := newTransport(cfg, proxyURL, true)
+		transport.TLSClientConfig = target.Client().Transport.(*http.Transport).TLSClientConfig
+		client := &http.Client{Transport: transport}
+
+		for i := range 2 {
+			resp, err := client.Get(target.URL)
+			if err != nil {
+				t.Fatalf("Request %d failed: %v", i, err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close() //nolint:errcheck
+
+			// Outlive the connect timeout before reusing the tunnel.
+			time.Sleep(2 * cfg.Timeout.Connect)
+		}
+
+		if got := proxy.connects.Load(); got != 1 {
+			t.Errorf("Expected the tunnel to be reused, but got %d CONNECT requests", got)
+		}
+	})
+
+	t.Run("should_use_https_proxy", func(t *testing.T) {
+		// Targets whose first response is slower than the timeout.
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			time.Sleep(2 * cfg.Timeout.Connect)
+			_, _ = io.WriteString(rw, "ok")
+		})
+		tlsTarget := httptest.NewTLSServer(handler)
+		t.Cleanup(tlsTarget.Close)
+		plainTarget := httptest.NewServer(handler)
+		t.Cleanup(plainTarget.Close)
+
+		// Every test TLS server shares the same certificate.
+		proxy := httptest.NewUnstartedServer(newTestProxy(t).Config.Handler)
+		proxy.StartTLS()
+		t.Cleanup(proxy.Close)
+
+		proxyURL, _ := url.Parse(proxy.URL)
+		transport := newTransport(cfg, proxyURL, true)
+		transport.TLSClientConfig = tlsTarget.Client().Transport.(*http.Transport).TLSClientConfig
+		client := &http.Client{Transport: transport}
+
+		for _, targetURL := range []string{tlsTarget.URL, plainTarget.URL} {
+			resp, err := client.Get(targetURL)
+			if err != nil {
+				t.Fatalf("GET %s\nExpected request to succeed, but got: %v", targetURL, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint:errcheck
+			if string(body) != "ok" {
+				t.Errorf("GET %s\nExpected body %q, but got: %q", targetURL, "ok", body)
+			}
+		}
+	})
+
+	t.Run("should_not_bound_forwarded_requests", func(t *testing.T) {
+		// An HTTP target whose first response is slower than the timeout.
+		target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			time.Sleep(2 * cfg.Timeout.Connect)
+			_, _ = io.WriteString(rw, "ok")
+		}))
+		t.Cleanup(target.Close)
+		proxy := newTestProxy(t)
+
+		proxyURL, _ := url.Parse(proxy.URL)
+		client := &http.Client{Transport: newTransport(cfg, proxyURL, true)}
+
+		resp, err := client.Get(target.URL)
+		if err != nil {
+			t.Fatalf("Expected request to succeed, but got: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close() //nolint:errcheck
+
+		if string(body) != "ok" {
+			t.Errorf("Expected body %q, but got: %q", "ok", body)
+		}
+		if got := proxy.requests.Load(); got != 1 {
+			t.Errorf("Expected the request to be forwarded, but got %d forwarded requests", got)
+		}
+	})
+}
+
+// TestNewTransport_HTTP2 checks that HTTP/2 is negotiated with the target
+// through the tunnel only if enabled, and that trailers make it through.
+func TestNewTransport_HTTP2(t *testing.T) {
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Trailer", "X-Checksum")
+		_, _ = io.WriteString(rw, req.Proto)
+		rw.Header().Set("X-Checksum", "abc")
+	}))
+	target.EnableHTTP2 = true
+	target.StartTLS()
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	// Test cases
+	tests := []struct {
+		name          string // Name of the test case
+		http2         bool   // Whether HTTP/2 is attempted
+		expectedProto string // Expected protocol seen by the target
+	}{
+		{
+			name:          "should_use_http1_by_default",
+			http2:         false,
+			expectedProto: "HTTP/1.1",
+		},
+		{
+			name:          "should_use_http2_if_enabled",
+			http2:         true,
+			expectedProto: "HTTP/2.0",
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults.Upstream
+			cfg.HTTP2 = tt.http2
+			transport := newTransport(cfg, proxyURL, true)
+			transport.TLSClientConfig = target.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+			t.Cleanup(transport.CloseIdleConnections)
+
+			resp, err := (&http.Client{Transport: transport}).Get(target.URL)
+			if err != nil {
+				t.Fatalf("GET %s failed: %v", target.URL, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint:errcheck
+
+			if string(body) != tt.expectedProto || resp.Proto != tt.expectedProto {
+				t.Errorf("Expected %s, but got: %s (target saw %s)", tt.expectedProto, resp.Proto, body)
+			}
+			if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+				t.Errorf("Expected trailer X-Checksum to be abc, but got: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package prxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// wsGoingAway is a WebSocket close frame with the 1001 (going away) status
+// code, sent by a server to its clients when it shuts down.
+var wsGoingAway = []byte{0x88, 0x02, 0x03, 0xE9}
+
+// upgradeTracker keeps track of the client connections upgraded to another
+// protocol, such as WebSockets, which the HTTP server forgets about once they
+// are hijacked by the reverse proxy.
+//
+// Connections without traffic for too long are closed, which also closes
+// their tunnel to the target. On shutdown, WebSocket clients are sent a close
+// frame, and the connections still open after the grace period are dropped.
+type upgradeTracker struct {
+	cfg      config.UpgradeConfig
+	logger   *logging.Logger
+	mu       sync.Mutex                 // Guards conns
+	conns    map[*upgradedConn]struct{} // Open connections
+	closed   chan struct{}              // Signaled every time a connection is closed
+	open     *metrics.Gauge             // Open connections, by protocol
+	total    *metrics.Counter           // Upgraded connections, by protocol
+	duration *metrics.Counter           // Time the connections were open, by protocol
+}
+
+// newUpgradeTracker creates an upgradeTracker with the given configuration.
+func newUpgradeTracker(cfg config.UpgradeConfig, logger *logging.Logger, registry *metrics.Registry) *upgradeTracker {
+	return &upgradeTracker{
+		cfg:      cfg,
+		logger:   logger,
+		conns:    make(map[*upgradedConn]struct{}),
+		closed:   make(chan struct{}, 1),
+		open:     registry.Gauge("prxy_upgraded_connections", "Number of open upgraded connections, by protocol.", "protocol"),
+		total:    registry.Counter("prxy_upgraded_connections_total", "Total number of upgraded connections, by protocol.", "protocol"),
+		duration: registry.Counter("prxy_upgraded_connection_seconds_total", "Total time the upgraded connections were open, in seconds, by protocol.", "protocol"),
+	}
+}
+
+// wrap returns a handler that tracks the connections of the upgrade requests
+// once they are hijacked by next.
+func (t *upgradeTracker) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		protocol := upgradeProtocol(req)
+		if protocol == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		next.ServeHTTP(&upgradeWriter{ResponseWriter: rw, tracker: t, req: req, protocol: protocol}, req)
+	})
+}
+
+// track starts tracking a hijacked connection.
+func (t *upgradeTracker) track(conn net.Conn, req *http.Request, protocol string) *upgradedConn {
+	c := &upgradedConn{
+		Conn:     conn,
+		tracker:  t,
+		protocol: protocol,
+		url:      req.URL.String(),
+		start:    time.Now(),
+	}
+	c.touch()
+	if t.cfg.Idle > 0 {
+		c.idleTimer = time.AfterFunc(t.cfg.Idle, c.checkIdle)
+	}
+
+	t.mu.Lock()
+	t.conns[c] = struct{}{}
+	t.mu.Unlock()
+
+	t.total.Inc(protocol)
+	t.open.Inc(protocol)
+	t.logger.Info("Upgraded connection opened", "protocol", protocol, "url", c.url)
+
+	return c
+}
+
+// untrack stops tracking a closed connection.
+func (t *upgradeTracker) untrack(c *upgradedConn) {
+	t.mu.Lock()
+	delete(t.conns, c)
+	t.mu.Unlock()
+
+	select {
+	case t.closed <- struct{}{}:
+	default:
+	}
+
+	duration := time.Since(c.start)
+	t.open.Dec(c.protocol)
+	t.duration.Add(duration.Seconds(), c.protocol)
+	t.logger.Info("Upgraded connection closed", "protocol", c.protocol, "url", c.url, "duration", duration)
+}
+
+// count returns the number of open connections.
+func (t *upgradeTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
+
+// snapshot returns the open connections.
+func (t *upgradeTracker) snapshot() []*upgradedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := make([]*upgradedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// shutdown asks the WebSocket clients to close their connections, waiting
+// for all the connections to close until the grace period ends or the context
+// is done. The connections still open are dropped then.
+func (t *upgradeTracker) shutdown(ctx context.Context) {
+	conns := t.snapshot()
+	if len(conns) == 0 {
+		return
+	}
+
+	t.logger.Info("Closing upgraded connections", "count", len(conns), "grace", t.cfg.Grace)
+	for _, c := range conns {
+		c.goAway()
+	}
+
+	grace := time.NewTimer(t.cfg.Grace)
+	defer grace.Stop()
+
+	for t.count() > 0 {
+		select {
+		case <-t.closed:
+		case <-grace.C:
+			t.drop()
+			return
+		case <-ctx.Done():
+			t.drop()
+			return
+		}
+	}
+}
+
+// drop closes all the open connections right away.
+func (t *upgradeTracker) drop() {
+	conns := t.snapshot()
+	if len(conns) > 0 {
+		t.logger.Warn("Dropping upgraded connections", "count", len(conns))
+	}
+	for _, c := range conns {
+		_ = c.Close()
+	}
+}
+
+// upgradeProtocol returns the protocol an upgrade request asks for, in lower
+// case, or an empty string if it's not an upgrade request.
+func upgradeProtocol(req *http.Request) string {
+	if !headerHasToken(req.Header, "Connection", "upgrade") {
+		return ""
+	}
+	protocol, _, _ := strings.Cut(req.Header.Get("Upgrade"), ",")
+	return strings.ToLower(strings.TrimSpace(protocol))
+}
+
+// headerHasToken reports whether the comma-separated values of the header
+// contain the token, ignoring case.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWriter is an http.ResponseWriter that tracks the connection once it
+// is hijacked.
+type upgradeWriter struct {
+	http.ResponseWriter
+	tracker  *upgradeTracker
+	req      *http.Request
+	protocol string
+}
+
+// Hijack implements the http.Hijacker interface.
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.tracker.track(conn, w.req, w.protocol), brw, nil
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// upgradedConn is a tracked client connection upgraded to another protocol.
+type upgradedConn struct {
+	net.Conn
+	tracker    *upgradeTracker
+	protocol   string
+	url        string
+	start      time.Time
+	lastActive atomic.Int64 // Unix nanoseconds of the last traffic
+	idleTimer  *time.Timer  // Closes the connection once idle, nil if disabled
+	closeOnce  sync.Once
+
+	mu        sync.Mutex // Serializes the writes
+	frames    wsFrames   // Position in the WebSocket frames sent to the client
+	goingAway bool       // Whether a close frame must be sent
+	closeSent bool       // Whether a close frame was sent
+}
+
+// Read implements the net.Conn interface.
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// Write implements the net.Conn interface. Once a close frame is sent, the
+// data of the target is discarded, as WebSocket endpoints must not send
+// anything after it.
+func (c *upgradedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closeSent {
+		return len(b), nil
+	}
+
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.touch()
+		c.frames.feed(b[:n])
+	}
+	if err == nil && c.goingAway && c.frames.boundary() {
+		c.sendClose()
+	}
+	return n, err
+}
+
+// Close implements the net.Conn interface.
+func (c *upgradedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+		c.tracker.untrack(c)
+	})
+	return err
+}
+
+// goAway sends a WebSocket close frame to the client as soon as no other
+// frame is being sent. Other protocols are left alone.
+func (c *upgradedConn) goAway() {
+	if c.protocol != "websocket" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.goingAway = true
+	if !c.closeSent && c.frames.boundary() {
+		c.sendClose()
+	}
+}
+
+// sendClose sends a WebSocket close frame to the client. It must be called
+// with the lock held.
+func (c *upgradedConn) sendClose() {
+	c.closeSent = true
+	if _, err := c.Conn.Write(wsGoingAway); err != nil {
+		c.tracker.logger.Debug("Failed to send WebSocket close frame", "url", c.url, "error", err)
+	}
+}
+
+// touch records traffic on the connection.
+func (c *upgradedConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// checkIdle closes the connection if it has been idle for too long, checking
+// again later otherwise.
+func (c *upgradedConn) checkIdle() {
+	idle := time.Since(time.Unix(0, c.lastActive.Load()))
+	if idle < c.tracker.cfg.Idle {
+		c.idleTimer.Reset(c.tracker.cfg.Idle - idle)
+		return
+	}
+
+	c.tracker.logger.Info("Closing idle upgraded connection", "protocol", c.protocol, "url", c.url, "idle", idle)
+	_ = c.Close()
+}
+
+// wsFrames follows the boundaries of a stream of WebSocket frames, as defined
+// by RFC 6455, so that a frame can be sent without corrupting another one.
+type wsFrames struct {
+	header    [14]byte // Header of the current frame, up to its maximum size
+	read      int      // Header bytes seen so far
+	remaining uint64   // Payload bytes left in the current frame
+}
+
+// feed advances the position past the given bytes of the stream.
+func (f *wsFrames) feed(b []byte) {
+	for len(b) > 0 {
+		if f.remaining > 0 {
+			n := min(uint64(len(b)), f.remaining)
+			f.remaining -= n
+			b = b[n:]
+			continue
+		}
+
+		f.header[f.read] = b[0]
+		f.read++
+		b = b[1:]
+
+		if size, ok := f.headerSize(); ok && f.read == size {
+			f.remaining = f.payloadLength()
+			f.read = 0
+		}
+	}
+}
+
+// boundary reports whether the position is between two frames.
+func (f *wsFrames) boundary() bool {
+	return f.read == 0 && f.remaining == 0
+}
+
+// headerSize returns the size of the header of the current frame, once its
+// first two bytes are known.
+func (f *wsFrames) headerSize() (int, bool) {
+	if f.read < 2 {
+		return 0, false
+	}
+	size := 2
+	switch f.header[1] & 0x7F {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if f.header[1]&0x80 != 0 {
+		size += 4 // Masking key
+	}
+	return size, true
+}
+
+// payloadLength returns the payload length of the current frame, once its
+// header is complete.
+func (f *wsFrames) payloadLength() uint64 {
+	switch length := f.header[1] & 0x7F; length {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(f.header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(f.header[2:10])
+	default:
+		return uint64(length)
+	}
+}
@@ -0,0 +1,223 @@
+package prxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/metrics"
+)
+
+// newUpgradeTestServer starts a reverse proxy to a target that accepts any
+// upgrade and echoes back everything it receives, tracking the upgraded
+// connections with a tracker for the given configuration.
+func newUpgradeTestServer(t *testing.T, cfg config.UpgradeConfig) (*httptest.Server, *upgradeTracker) {
+	t.Helper()
+
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, brw, err := http.NewResponseController(rw).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() failed: %v", err)
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: "+req.Header.Get("Upgrade")+"\r\n\r\n")
+		_, _ = io.Copy(conn, brw)
+	}))
+	t.Cleanup(target.Close)
+
+	targetURL, _ := url.Parse(target.URL)
+	tracker := newUpgradeTracker(cfg, newTestLogger(t), metrics.NewRegistry())
+	server := httptest.NewServer(tracker.wrap(httputil.NewSingleHostReverseProxy(targetURL)))
+	t.Cleanup(server.Close)
+
+	return server, tracker
+}
+
+// dialUpgrade opens a connection upgraded to the protocol through the server.
+func dialUpgrade(t *testing.T, server *httptest.Server, protocol string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+	_, _ = io.WriteString(conn, "GET /socket HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: "+protocol+"\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status %d, but got: %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+
+	return conn, reader
+}
+
+// TestUpgradeTracker_Shutdown checks that WebSocket clients are sent a close
+// frame on shutdown, and that other connections are dropped after the grace
+// period.
+func TestUpgradeTracker_Shutdown(t *testing.T) {
+	t.Run("websocket_close_frame", func(t *testing.T) {
+		server, tracker := newUpgradeTestServer(t, config.UpgradeConfig{Grace: 5 * time.Second})
+		conn, reader := dialUpgrade(t, server, "websocket")
+
+		// A text frame with "hello", echoed back by the target.
+		frame := []byte{0x81, 0x05, 'h', 'e', 'l', 'l', 'o'}
+		_, _ = conn.Write(frame)
+		echo := make([]byte, len(frame))
+		if _, err := io.ReadFull(reader, echo); err != nil || !bytes.Equal(echo, frame) {
+			t.Fatalf("Expected the frame echoed back, but got: %v (%v)", echo, err)
+		}
+		if got := tracker.open.Value("websocket"); got != 1 {
+			t.Errorf("Expected 1 open connection, but got: %v", got)
+		}
+
+		done := make(chan struct{})
+		start := time.Now()
+		go func() {
+			tracker.shutdown(context.Background())
+			close(done)
+		}()
+
+		closeFrame := make([]byte, len(wsGoingAway))
+		if _, err := io.ReadFull(reader, closeFrame); err != nil || !bytes.Equal(closeFrame, wsGoingAway) {
+			t.Fatalf("Expected a close frame, but got: %v (%v)", closeFrame, err)
+		}
+		conn.Close() //nolint:errcheck
+
+		<-done
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected shutdown as soon as the client closed, but took: %v", elapsed)
+		}
+		if got := tracker.open.Value("websocket"); got != 0 {
+			t.Errorf("Expected no open connections, but got: %v", got)
+		}
+		if got := tracker.total.Value("websocket"); got != 1 {
+			t.Errorf("Expected 1 upgraded connection, but got: %v", got)
+		}
+	})
+
+	t.Run("drop_after_grace", func(t *testing.T) {
+		server, tracker := newUpgradeTestServer(t, config.UpgradeConfig{Grace: 50 * time.Millisecond})
+		_, reader := dialUpgrade(t, server, "custom")
+
+		start := time.Now()
+		tracker.shutdown(context.Background())
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("Expected shutdown to wait for the grace period, but took: %v", elapsed)
+		}
+
+		if _, err := reader.ReadByte(); err == nil {
+			t.Error("Expected the connection to be dropped")
+		}
+		if got := tracker.count(); got != 0 {
+			t.Errorf("Expected no open connections, but got: %d", got)
+		}
+	})
+}
+
+// TestUpgradeTracker_Idle checks that idle connections are closed, along
+// with their tunnel to the target.
+func TestUpgradeTracker_Idle(t *testing.T) {
+	server, tracker := newUpgradeTestServer(t, config.UpgradeConfig{Idle: 100 * time.Millisecond, Grace: time.Second})
+	conn, reader := dialUpgrade(t, server, "websocket")
+
+	// Traffic keeps the connection open.
+	frame := []byte{0x81, 0x01, 'x'}
+	for range 3 {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = conn.Write(frame)
+		if _, err := io.ReadFull(reader, make([]byte, len(frame))); err != nil {
+			t.Fatalf("Expected the connection to stay open, but got: %v", err)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := reader.ReadByte(); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("Expected the idle connection to be closed, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the connection to be closed after the idle timeout, but took: %v", elapsed)
+	}
+	if got := tracker.count(); got != 0 {
+		t.Errorf("Expected no open connections, but got: %d", got)
+	}
+}
+
+// captureConn is a net.Conn that records what is written to it.
+type captureConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+// Write implements the net.Conn interface.
+func (c *captureConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+// TestUpgradedConn_GoAway checks that the close frame is not sent in the
+// middle of another frame.
+func TestUpgradedConn_GoAway(t *testing.T) {
+	capture := &captureConn{}
+	c := &upgradedConn{Conn: capture, protocol: "websocket"}
+
+	frame := []byte{0x82, 0x04, 1, 2, 3, 4}
+	_, _ = c.Write(frame[:3])
+	c.goAway()
+	if capture.written.Len() != 3 {
+		t.Fatalf("Expected no close frame in the middle of a frame, but got: %v", capture.written.Bytes())
+	}
+
+	_, _ = c.Write(frame[3:])
+	_, _ = c.Write([]byte{0x81, 0x01, 'x'})
+
+	expected := append(append([]byte(nil), frame...), wsGoingAway...)
+	if !bytes.Equal(capture.written.Bytes(), expected) {
+		t.Errorf("Expected the close frame after the pending one and nothing else\nExpected: %v\nGot: %v", expected, capture.written.Bytes())
+	}
+}
+
+// TestWsFrames checks that frame boundaries are followed across writes of any
+// size.
+func TestWsFrames(t *testing.T) {
+	var stream []byte
+	boundaries := map[int]bool{}
+
+	for _, frame := range [][]byte{
+		{0x81, 0x02, 'h', 'i'}, // Short payload
+		append([]byte{0x82, 0x7E, 0x01, 0x00}, make([]byte, 256)...),               // 16-bit length
+		append([]byte{0x82, 0x7F, 0, 0, 0, 0, 0, 1, 0, 0}, make([]byte, 65536)...), // 64-bit length
+		{0x81, 0x83, 1, 2, 3, 4, 'a', 'b', 'c'},                                    // Masked
+		{0x89, 0x00},                                                               // Empty ping
+	} {
+		stream = append(stream, frame...)
+		boundaries[len(stream)] = true
+	}
+
+	for _, size := range []int{1, 3, 7, 1000, len(stream)} {
+		var f wsFrames
+		for fed := 0; fed < len(stream); {
+			n := min(size, len(stream)-fed)
+			f.feed(stream[fed : fed+n])
+			fed += n
+			if got := f.boundary(); got != boundaries[fed] {
+				t.Fatalf("Writes of %d bytes: boundary() after %d bytes\nExpected: %v\nGot: %v", size, fed, boundaries[fed], got)
+			}
+		}
+	}
+}
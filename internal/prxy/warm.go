@@ -0,0 +1,106 @@
+package prxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+	"github.com/Madh93/prxy/internal/logging"
+)
+
+// warmer keeps a number of connections to the target established through the
+// proxy, so that requests after idle periods reuse them instead of paying for
+// a fresh CONNECT and TLS handshake.
+//
+// It does so by sending concurrent HEAD requests to the target, which makes
+// the transport dial any missing connection and keeps the existing ones busy
+// before they are closed for being idle.
+type warmer struct {
+	logger    *logging.Logger
+	cfg       config.UpstreamWarm
+	targetURL *url.URL
+	client    *http.Client
+}
+
+// newWarmer creates a warmer that keeps connections established in the pool
+// of the given transport.
+func newWarmer(cfg config.UpstreamWarm, targetURL *url.URL, transport http.RoundTripper, logger *logging.Logger) *warmer {
+	return &warmer{
+		logger:    logger,
+		cfg:       cfg,
+		targetURL: targetURL,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// run warms up the connections right away and then refreshes them at the
+// configured interval, until done is closed.
+func (w *warmer) run(done <-chan struct{}) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.warm()
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// warm establishes or refreshes the configured number of connections.
+func (w *warmer) warm() {
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.Interval)
+	defer cancel()
+
+	// All requests must be in flight at the same time, so that each of them
+	// holds its own connection.
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	for range w.cfg.Conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.ping(ctx); err != nil {
+				failed.Add(1)
+				w.logger.Debug("Failed to warm up connection", "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		w.logger.Warn("Some connections could not be warmed up", "failed", n, "total", w.cfg.Conns)
+		return
+	}
+	w.logger.Debug("Connections warmed up", "total", w.cfg.Conns)
+}
+
+// ping sends a HEAD request to the target. The status code doesn't matter, as
+// long as the connection is returned to the pool.
+func (w *warmer) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, w.targetURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.Body.Close()
+}
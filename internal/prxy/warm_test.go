@@ -0,0 +1,52 @@
+package prxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Madh93/prxy/internal/config"
+)
+
+// TestWarmer_Warm checks that the warm pool establishes the configured number
+// of tunnels, which are then reused by the requests.
+func TestWarmer_Warm(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	t.Cleanup(target.Close)
+	proxy := newTestProxy(t)
+
+	upstream := config.Defaults.Upstream
+	upstream.Warm = config.UpstreamWarm{Conns: 3, Interval: time.Second}
+	targetURL, _ := url.Parse(target.URL)
+	proxyURL, _ := url.Parse(proxy.URL)
+	transport := newTransport(upstream, proxyURL, true)
+	transport.TLSClientConfig = target.Client().Transport.(*http.Transport).TLSClientConfig
+
+	w := newWarmer(upstream.Warm, targetURL, transport, newTestLogger(t))
+	w.warm()
+	if got := proxy.connects.Load(); got != 3 {
+		t.Fatalf("warm()\nExpected 3 tunnels to be established, but got: %d", got)
+	}
+
+	// Refreshing the pool reuses the established tunnels.
+	w.warm()
+	if got := proxy.connects.Load(); got != 3 {
+		t.Errorf("warm()\nExpected the 3 tunnels to be reused, but got %d CONNECT requests", got)
+	}
+
+	// Requests reuse the warm tunnels.
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(target.URL)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", target.URL, err)
+	}
+	resp.Body.Close() //nolint:errcheck
+	if got := proxy.connects.Load(); got != 3 {
+		t.Errorf("Expected the request to reuse a warm tunnel, but got %d CONNECT requests", got)
+	}
+}
@@ -0,0 +1,138 @@
+// Package systemd implements the parts of the systemd service protocol that
+// prxy supports, without linking against libsystemd.
+//
+// It provides:
+//
+//   - Listeners: Returns the sockets passed by systemd socket activation
+//     through the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES environment
+//     variables, so that a service can be started on demand.
+//
+//   - Notify: Sends a state change, such as READY=1 or STOPPING=1, to the
+//     service manager through the socket in NOTIFY_SOCKET.
+//
+//   - WatchdogInterval: Returns the interval at which the service manager
+//     expects WATCHDOG=1 keep-alive notifications, if enabled.
+//
+// All functions are no-ops when prxy is not started by systemd.
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification states understood by the service manager.
+const (
+	StateReady    = "READY=1"    // Startup is finished
+	StateStopping = "STOPPING=1" // Shutdown has started
+	StateWatchdog = "WATCHDOG=1" // Keep-alive ping for the watchdog
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation.
+const listenFdsStart = 3
+
+// Listeners returns the listeners passed by systemd socket activation, or nil
+// if the process was not socket activated. The environment variables are
+// unset, so that child processes don't inherit them.
+func Listeners() ([]net.Listener, error) {
+	return listeners(listenFdsStart)
+}
+
+// listeners implements Listeners, with the passed descriptors starting at fd.
+func listeners(start int) ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")     //nolint:errcheck
+		os.Unsetenv("LISTEN_FDS")     //nolint:errcheck
+		os.Unsetenv("LISTEN_FDNAMES") //nolint:errcheck
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil // Not meant for this process
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := range count {
+		name := "LISTEN_FD_" + strconv.Itoa(start+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// FileListener duplicates the descriptor, so the original one is
+		// closed right away.
+		file := os.NewFile(uintptr(start+i), name)
+		listener, err := net.FileListener(file)
+		file.Close() //nolint:errcheck
+		if err != nil {
+			for _, l := range listeners {
+				l.Close() //nolint:errcheck
+			}
+			return nil, fmt.Errorf("invalid socket %q: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// Notify sends the state to the service manager. It returns false without
+// error if NOTIFY_SOCKET is not set.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// A leading '@' refers to the Linux abstract namespace.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// WatchdogInterval returns the interval at which the service manager expects
+// keep-alive notifications, or 0 if the watchdog is disabled. Notifications
+// should be sent at least twice per interval.
+func WatchdogInterval() (time.Duration, error) {
+	usecs := os.Getenv("WATCHDOG_USEC")
+	if usecs == "" {
+		return 0, nil
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil // Not meant for this process
+	}
+
+	usec, err := strconv.ParseInt(usecs, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, errors.New("invalid WATCHDOG_USEC " + strconv.Quote(usecs))
+	}
+
+	return time.Duration(usec) * time.Microsecond, nil
+}
@@ -0,0 +1,136 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestListeners checks the sockets passed by socket activation.
+func TestListeners(t *testing.T) {
+	t.Run("should_return_nil_when_not_activated", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", "")
+		got, err := Listeners()
+		if err != nil || got != nil {
+			t.Errorf("Listeners()\nExpected no listeners and no error, but got: %v, %v", got, err)
+		}
+	})
+
+	t.Run("should_return_nil_when_meant_for_another_process", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+		t.Setenv("LISTEN_FDS", "1")
+		got, err := Listeners()
+		if err != nil || got != nil {
+			t.Errorf("Listeners()\nExpected no listeners and no error, but got: %v, %v", got, err)
+		}
+		if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+			t.Error("Expected LISTEN_FDS to be unset")
+		}
+	})
+
+	t.Run("should_fail_with_invalid_count", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "none")
+		if _, err := Listeners(); err == nil {
+			t.Error("Listeners()\nExpected error, but got none")
+		}
+	})
+
+}
+
+// TestNotify checks the notifications sent to a stand-in of the service
+// manager socket.
+func TestNotify(t *testing.T) {
+	t.Run("should_do_nothing_without_socket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		sent, err := Notify(StateReady)
+		if sent || err != nil {
+			t.Errorf("Notify()\nExpected nothing sent and no error, but got: %v, %v", sent, err)
+		}
+	})
+
+	t.Run("should_send_state_to_socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			t.Fatalf("Failed to create notify socket: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+		t.Setenv("NOTIFY_SOCKET", path)
+
+		for _, state := range []string{StateReady, StateWatchdog, StateStopping} {
+			sent, err := Notify(state)
+			if !sent || err != nil {
+				t.Fatalf("Notify(%q)\nExpected to be sent without error, but got: %v, %v", state, sent, err)
+			}
+
+			buf := make([]byte, 64)
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("Failed to read notification: %v", err)
+			}
+			if got := string(buf[:n]); got != state {
+				t.Errorf("Notify(%q)\nExpected %q to be received, but got: %q", state, state, got)
+			}
+		}
+	})
+}
+
+// TestWatchdogInterval checks the parsing of the watchdog settings.
+func TestWatchdogInterval(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name        string        // Name of the test case
+		usec        string        // Value of WATCHDOG_USEC
+		pid         string        // Value of WATCHDOG_PID
+		expected    time.Duration // Expected interval
+		expectError bool          // true if an error is expected, false otherwise
+	}{
+		{
+			name:     "disabled",
+			expected: 0,
+		},
+		{
+			name:     "enabled",
+			usec:     "30000000",
+			expected: 30 * time.Second,
+		},
+		{
+			name:     "enabled_for_this_process",
+			usec:     "1000",
+			pid:      strconv.Itoa(os.Getpid()),
+			expected: time.Millisecond,
+		},
+		{
+			name:     "enabled_for_another_process",
+			usec:     "1000",
+			pid:      strconv.Itoa(os.Getpid() + 1),
+			expected: 0,
+		},
+		{
+			name:        "invalid_value",
+			usec:        "soon",
+			expectError: true,
+		},
+	}
+
+	// Run tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+
+			got, err := WatchdogInterval()
+			if (err != nil) != tt.expectError {
+				t.Fatalf("WatchdogInterval()\nExpected error: %v, but got: %v", tt.expectError, err)
+			}
+			if got != tt.expected {
+				t.Errorf("WatchdogInterval()\nExpected %v, but got: %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// TestListeners_Passed checks the sockets passed by socket activation.
+func TestListeners_Passed(t *testing.T) {
+	// Stand-in for the sockets that systemd would pass at fd 3 onwards. The
+	// descriptors must be consecutive, so they are duplicated in order.
+	var originals []net.Listener
+	first := -1
+	for i := range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { listener.Close() }) //nolint:errcheck
+		originals = append(originals, listener)
+
+		rawConn, err := listener.(*net.TCPListener).SyscallConn()
+		if err != nil {
+			t.Fatalf("Failed to get raw listener: %v", err)
+		}
+		var fd int
+		var dupErr error
+		_ = rawConn.Control(func(orig uintptr) { fd, dupErr = syscall.Dup(int(orig)) })
+		if dupErr != nil {
+			t.Fatalf("Failed to duplicate listener: %v", dupErr)
+		}
+		if i == 0 {
+			first = fd
+		} else if fd != first+i {
+			syscall.Close(fd)    //nolint:errcheck
+			syscall.Close(first) //nolint:errcheck
+			t.Skip("Duplicated descriptors are not consecutive")
+		}
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_FDNAMES", "http:http")
+
+	passed, err := listeners(first)
+	if err != nil {
+		t.Fatalf("Listeners()\nExpected no error, but got: %v", err)
+	}
+	if len(passed) != len(originals) {
+		t.Fatalf("Listeners()\nExpected %d listeners, but got: %d", len(originals), len(passed))
+	}
+	for i, listener := range passed {
+		if got, expected := listener.Addr().String(), originals[i].Addr().String(); got != expected {
+			t.Errorf("Listener %d\nExpected address %s, but got: %s", i, expected, got)
+		}
+		listener.Close() //nolint:errcheck
+	}
+
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("Expected %s to be unset", name)
+		}
+	}
+}
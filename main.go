@@ -1,9 +1,9 @@
 // Package main is the entry point for the prxy application.
 //
 // It defines the command-line interface (CLI) using the urfave/cli library,
-// handles configuration loading from flags and environment variables, sets up
-// structured logging, and manages the lifecycle of the proxy server, including
-// graceful shutdown.
+// handles configuration loading from flags, environment variables and a
+// configuration file, sets up structured logging, and manages the lifecycle of
+// the proxy server, including configuration reloads and graceful shutdown.
 
 package main
 
@@ -19,6 +19,7 @@ import (
 	"github.com/Madh93/prxy/internal/config"
 	"github.com/Madh93/prxy/internal/logging"
 	"github.com/Madh93/prxy/internal/prxy"
+	"github.com/Madh93/prxy/internal/systemd"
 	"github.com/Madh93/prxy/internal/version"
 	"github.com/urfave/cli/v3"
 )
@@ -42,13 +43,103 @@ func main() {
 		Suggest:               true,
 		EnableShellCompletion: true,
 		Flags: []cli.Flag{
-			&cli.StringFlag{Name: "target", Required: true, Usage: "target service URL", Sources: cli.EnvVars("PRXY_TARGET"), Aliases: []string{"t"}},
-			&cli.StringFlag{Name: "proxy", Required: true, Usage: "outbound HTTP Proxy URL", Sources: cli.EnvVars("PRXY_PROXY"), Aliases: []string{"x"}},
+			&cli.StringFlag{Name: "config", Usage: "path to a YAML configuration file, reloaded on SIGHUP", Sources: cli.EnvVars("PRXY_CONFIG"), Aliases: []string{"c"}, TakesFile: true},
+			&cli.StringFlag{Name: "target", Usage: "target service URL (required unless backends are set)", Sources: cli.EnvVars("PRXY_TARGET"), Aliases: []string{"t"}},
+			&cli.StringFlag{Name: "proxy", Usage: "outbound HTTP Proxy URL (required)", Sources: cli.EnvVars("PRXY_PROXY"), Aliases: []string{"x"}},
+			&cli.StringSliceFlag{Name: "backends", Usage: "target service URL sharing the traffic, as URL or URL=WEIGHT. Can be repeated (instead of target)", Sources: cli.EnvVars("PRXY_BACKENDS"), Aliases: []string{"b"}},
+			&cli.StringFlag{Name: "balance-strategy", Value: string(config.Defaults.Balance.Strategy), Usage: fmt.Sprintf("how a backend is chosen for a request. Available options: %s", config.ValidBalanceStrategies), Sources: cli.EnvVars("PRXY_BALANCE_STRATEGY")},
+			&cli.StringFlag{Name: "balance-hash", Value: config.Defaults.Balance.Hash, Usage: "request attribute hashed by the hash strategy: ip, path, header:<name> or cookie:<name>", Sources: cli.EnvVars("PRXY_BALANCE_HASH")},
+			&cli.StringFlag{Name: "balance-sticky", Value: string(config.Defaults.Balance.Sticky), Usage: fmt.Sprintf("keep clients on the same backend. Available options: %s", config.ValidBalanceSticky), Sources: cli.EnvVars("PRXY_BALANCE_STICKY")},
+			&cli.StringFlag{Name: "balance-cookie", Value: config.Defaults.Balance.Cookie, Usage: "name of the cookie used by the cookie sticky mode", Sources: cli.EnvVars("PRXY_BALANCE_COOKIE")},
+			&cli.DurationFlag{Name: "balance-check-interval", Usage: "how often the backends are probed (0 disables it)", Sources: cli.EnvVars("PRXY_BALANCE_CHECK_INTERVAL")},
+			&cli.DurationFlag{Name: "balance-check-timeout", Value: config.Defaults.Balance.Check.Timeout, Usage: "maximum duration of a backend probe", Sources: cli.EnvVars("PRXY_BALANCE_CHECK_TIMEOUT")},
+			&cli.StringFlag{Name: "balance-check-path", Value: config.Defaults.Balance.Check.Path, Usage: "path on the backends to probe", Sources: cli.EnvVars("PRXY_BALANCE_CHECK_PATH")},
+			&cli.IntFlag{Name: "balance-check-status", Value: config.Defaults.Balance.Check.Status, Usage: "expected status code of the backend probe", Sources: cli.EnvVars("PRXY_BALANCE_CHECK_STATUS")},
+			&cli.IntFlag{Name: "balance-eject-failures", Usage: "consecutive failures that take a backend out of the pool (0 disables it)", Sources: cli.EnvVars("PRXY_BALANCE_EJECT_FAILURES")},
+			&cli.DurationFlag{Name: "balance-eject-duration", Value: config.Defaults.Balance.Eject.Duration, Usage: "how long a failing backend stays out of the pool", Sources: cli.EnvVars("PRXY_BALANCE_EJECT_DURATION")},
 			&cli.StringFlag{Name: "host", Value: config.Defaults.Host, Usage: "host to listen on", Sources: cli.EnvVars("PRXY_HOST"), Aliases: []string{"H"}},
 			&cli.IntFlag{Name: "port", Value: config.Defaults.Port, Usage: "port to listen on", DefaultText: "random", Sources: cli.EnvVars("PRXY_PORT"), Aliases: []string{"P"}},
+			&cli.StringSliceFlag{Name: "listen", Usage: "address to listen on, as host:port, tls://host:port or unix:///path/to/socket. Can be repeated (overrides host and port)", Sources: cli.EnvVars("PRXY_LISTEN"), Aliases: []string{"L"}},
+			&cli.StringFlag{Name: "socket-mode", Usage: "file mode of the unix socket, e.g. 0660", Sources: cli.EnvVars("PRXY_SOCKET_MODE")},
+			&cli.StringFlag{Name: "socket-owner", Usage: "owner of the unix socket, as user[:group]", Sources: cli.EnvVars("PRXY_SOCKET_OWNER")},
+			&cli.StringFlag{Name: "tls-cert", Usage: "path to the PEM encoded certificate for TLS listeners", Sources: cli.EnvVars("PRXY_TLS_CERT"), TakesFile: true},
+			&cli.StringFlag{Name: "tls-key", Usage: "path to the PEM encoded private key for TLS listeners", Sources: cli.EnvVars("PRXY_TLS_KEY"), TakesFile: true},
+			&cli.DurationFlag{Name: "server-timeout-header", Value: config.Defaults.Server.Timeout.Header, Usage: "maximum duration to read the request headers (0 disables it)", Sources: cli.EnvVars("PRXY_SERVER_TIMEOUT_HEADER")},
+			&cli.DurationFlag{Name: "server-timeout-read", Value: config.Defaults.Server.Timeout.Read, Usage: "maximum duration to read the entire request (0 disables it)", Sources: cli.EnvVars("PRXY_SERVER_TIMEOUT_READ")},
+			&cli.DurationFlag{Name: "server-timeout-write", Value: config.Defaults.Server.Timeout.Write, Usage: "maximum duration to write the response (0 disables it)", Sources: cli.EnvVars("PRXY_SERVER_TIMEOUT_WRITE")},
+			&cli.DurationFlag{Name: "server-timeout-idle", Value: config.Defaults.Server.Timeout.Idle, Usage: "maximum duration to wait for the next request on keep-alive connections (0 disables it)", Sources: cli.EnvVars("PRXY_SERVER_TIMEOUT_IDLE")},
+			&cli.BoolFlag{Name: "server-http2", Value: config.Defaults.Server.HTTP2, Usage: "serve HTTP/2 on TLS listeners", Sources: cli.EnvVars("PRXY_SERVER_HTTP2")},
+			&cli.BoolFlag{Name: "server-h2c", Value: config.Defaults.Server.H2C, Usage: "serve cleartext HTTP/2 (h2c) with prior knowledge on the non-TLS listeners", Sources: cli.EnvVars("PRXY_SERVER_H2C")},
+			&cli.BoolFlag{Name: "server-http3", Value: config.Defaults.Server.HTTP3, Usage: "serve HTTP/3 (QUIC) on UDP alongside every TLS listener", Sources: cli.EnvVars("PRXY_SERVER_HTTP3")},
+			&cli.DurationFlag{Name: "upstream-timeout-dial", Value: config.Defaults.Upstream.Timeout.Dial, Usage: "maximum duration to connect to the proxy (0 disables it)", Sources: cli.EnvVars("PRXY_UPSTREAM_TIMEOUT_DIAL")},
+			&cli.DurationFlag{Name: "upstream-timeout-tls", Value: config.Defaults.Upstream.Timeout.TLS, Usage: "maximum duration of the TLS handshake with the target (0 disables it)", Sources: cli.EnvVars("PRXY_UPSTREAM_TIMEOUT_TLS")},
+			&cli.DurationFlag{Name: "upstream-timeout-connect", Value: config.Defaults.Upstream.Timeout.Connect, Usage: "maximum duration for the proxy to answer a CONNECT request (0 disables it)", Sources: cli.EnvVars("PRXY_UPSTREAM_TIMEOUT_CONNECT")},
+			&cli.DurationFlag{Name: "upstream-timeout-response", Value: config.Defaults.Upstream.Timeout.Response, Usage: "maximum duration to wait for the response headers of the target (0 disables it)", Sources: cli.EnvVars("PRXY_UPSTREAM_TIMEOUT_RESPONSE")},
+			&cli.DurationFlag{Name: "upstream-timeout-idle", Value: config.Defaults.Upstream.Timeout.Idle, Usage: "maximum duration an idle connection to the target is kept open (0 disables it)", Sources: cli.EnvVars("PRXY_UPSTREAM_TIMEOUT_IDLE")},
+			&cli.IntFlag{Name: "upstream-idle-conns", Value: config.Defaults.Upstream.Idle.Conns, Usage: "maximum number of idle connections to the target (0 means no limit)", Sources: cli.EnvVars("PRXY_UPSTREAM_IDLE_CONNS")},
+			&cli.IntFlag{Name: "upstream-host-idle-conns", Value: config.Defaults.Upstream.Host.Idle.Conns, Usage: "maximum number of idle connections per target host", Sources: cli.EnvVars("PRXY_UPSTREAM_HOST_IDLE_CONNS")},
+			&cli.IntFlag{Name: "upstream-host-conns", Value: config.Defaults.Upstream.Host.Conns, Usage: "maximum number of connections per target host (0 means no limit)", Sources: cli.EnvVars("PRXY_UPSTREAM_HOST_CONNS")},
+			&cli.IntFlag{Name: "upstream-warm-conns", Value: config.Defaults.Upstream.Warm.Conns, Usage: "number of connections to the target to keep established (0 disables it)", Sources: cli.EnvVars("PRXY_UPSTREAM_WARM_CONNS")},
+			&cli.DurationFlag{Name: "upstream-warm-interval", Value: config.Defaults.Upstream.Warm.Interval, Usage: "how often the warm connections are refreshed", Sources: cli.EnvVars("PRXY_UPSTREAM_WARM_INTERVAL")},
+			&cli.BoolFlag{Name: "upstream-http2", Value: config.Defaults.Upstream.HTTP2, Usage: "attempt HTTP/2 with HTTPS targets through the CONNECT tunnels", Sources: cli.EnvVars("PRXY_UPSTREAM_HTTP2")},
+			&cli.IntFlag{Name: "retry-attempts", Value: config.Defaults.Retry.Attempts, Usage: "maximum number of retries of a failed upstream request (0 disables them)", Sources: cli.EnvVars("PRXY_RETRY_ATTEMPTS")},
+			&cli.IntSliceFlag{Name: "retry-statuses", Usage: "response status codes that are retried, besides connection errors", Sources: cli.EnvVars("PRXY_RETRY_STATUSES")},
+			&cli.StringSliceFlag{Name: "retry-methods", Value: config.Defaults.Retry.Methods, Usage: "request methods that are retried", Sources: cli.EnvVars("PRXY_RETRY_METHODS")},
+			&cli.Int64Flag{Name: "retry-buffer", Value: config.Defaults.Retry.Buffer, Usage: "maximum request body size in bytes buffered to be replayed on retries", Sources: cli.EnvVars("PRXY_RETRY_BUFFER")},
+			&cli.DurationFlag{Name: "retry-deadline", Value: config.Defaults.Retry.Deadline, Usage: "total time after which no more retries are attempted", Sources: cli.EnvVars("PRXY_RETRY_DEADLINE")},
+			&cli.DurationFlag{Name: "retry-wait-min", Value: config.Defaults.Retry.Wait.Min, Usage: "wait before the first retry", Sources: cli.EnvVars("PRXY_RETRY_WAIT_MIN")},
+			&cli.DurationFlag{Name: "retry-wait-max", Value: config.Defaults.Retry.Wait.Max, Usage: "maximum wait between retries", Sources: cli.EnvVars("PRXY_RETRY_WAIT_MAX")},
+			&cli.IntFlag{Name: "breaker-failures", Value: config.Defaults.Breaker.Failures, Usage: "consecutive upstream failures that open the circuit breaker (0 disables it)", Sources: cli.EnvVars("PRXY_BREAKER_FAILURES")},
+			&cli.IntSliceFlag{Name: "breaker-statuses", Usage: "response status codes counted as failures, besides connection errors", Sources: cli.EnvVars("PRXY_BREAKER_STATUSES")},
+			&cli.DurationFlag{Name: "breaker-cooldown", Value: config.Defaults.Breaker.Cooldown, Usage: "how long the circuit breaker stays open before letting probes through", Sources: cli.EnvVars("PRXY_BREAKER_COOLDOWN")},
+			&cli.IntFlag{Name: "breaker-probes", Value: config.Defaults.Breaker.Probes, Usage: "successful probes needed to close the circuit breaker again", Sources: cli.EnvVars("PRXY_BREAKER_PROBES")},
+			&cli.StringFlag{Name: "error-format", Value: string(config.Defaults.Error.Format), Usage: fmt.Sprintf("set the format of error responses. Available options: %s", config.ValidErrorFormats), Sources: cli.EnvVars("PRXY_ERROR_FORMAT")},
+			&cli.StringFlag{Name: "error-template", Usage: "HTML template of error responses (requires the html format)", Sources: cli.EnvVars("PRXY_ERROR_TEMPLATE"), TakesFile: true},
+			&cli.StringFlag{Name: "record-file", Usage: "record the proxied traffic to this HAR file", Sources: cli.EnvVars("PRXY_RECORD_FILE"), Aliases: []string{"record"}, TakesFile: true},
+			&cli.Int64Flag{Name: "record-body-max", Value: config.Defaults.Record.Body.Max, Usage: "maximum recorded size in bytes of each body (0 records no bodies)", Sources: cli.EnvVars("PRXY_RECORD_BODY_MAX")},
+			&cli.StringSliceFlag{Name: "record-redact", Value: config.Defaults.Record.Redact, Usage: "headers whose values are redacted in the recording", Sources: cli.EnvVars("PRXY_RECORD_REDACT")},
+			&cli.StringFlag{Name: "replay-path", Usage: "serve the responses recorded in this HAR file or directory instead of contacting the target", Sources: cli.EnvVars("PRXY_REPLAY_PATH"), Aliases: []string{"replay"}, TakesFile: true},
+			&cli.BoolFlag{Name: "replay-match-body", Usage: "match the request body too when replaying", Sources: cli.EnvVars("PRXY_REPLAY_MATCH_BODY")},
+			&cli.StringFlag{Name: "replay-unmatched", Value: string(config.Defaults.Replay.Unmatched), Usage: fmt.Sprintf("what to do with requests without a recorded response. Available options: %s", config.ValidReplayUnmatched), Sources: cli.EnvVars("PRXY_REPLAY_UNMATCHED")},
+			&cli.StringFlag{Name: "mirror-target", Usage: "secondary target URL that receives a copy of the traffic", Sources: cli.EnvVars("PRXY_MIRROR_TARGET")},
+			&cli.StringFlag{Name: "mirror-proxy", Usage: "outbound HTTP Proxy URL of the secondary target (defaults to --proxy)", Sources: cli.EnvVars("PRXY_MIRROR_PROXY")},
+			&cli.Float64Flag{Name: "mirror-sample", Value: config.Defaults.Mirror.Sample, Usage: "fraction of the requests that are mirrored, from 0 to 1", Sources: cli.EnvVars("PRXY_MIRROR_SAMPLE")},
+			&cli.IntFlag{Name: "mirror-queue", Value: config.Defaults.Mirror.Queue, Usage: "maximum number of requests waiting to be mirrored", Sources: cli.EnvVars("PRXY_MIRROR_QUEUE")},
+			&cli.IntFlag{Name: "mirror-workers", Value: config.Defaults.Mirror.Workers, Usage: "number of requests mirrored concurrently", Sources: cli.EnvVars("PRXY_MIRROR_WORKERS")},
+			&cli.Int64Flag{Name: "mirror-buffer", Value: config.Defaults.Mirror.Buffer, Usage: "maximum request body size in bytes buffered to be mirrored", Sources: cli.EnvVars("PRXY_MIRROR_BUFFER")},
+			&cli.DurationFlag{Name: "mirror-timeout", Value: config.Defaults.Mirror.Timeout, Usage: "maximum duration of a mirrored request", Sources: cli.EnvVars("PRXY_MIRROR_TIMEOUT")},
+			&cli.StringFlag{Name: "cache-store", Value: string(config.Defaults.Cache.Store), Usage: fmt.Sprintf("where the responses of the target are cached. Available options: %s", config.ValidCacheStores), Sources: cli.EnvVars("PRXY_CACHE_STORE")},
+			&cli.StringFlag{Name: "cache-path", Usage: "directory of the disk cache store", Sources: cli.EnvVars("PRXY_CACHE_PATH"), TakesFile: true},
+			&cli.Int64Flag{Name: "cache-size", Value: config.Defaults.Cache.Size, Usage: "maximum size of all the cached responses, in bytes", Sources: cli.EnvVars("PRXY_CACHE_SIZE")},
+			&cli.Int64Flag{Name: "cache-object-max", Value: config.Defaults.Cache.Object.Max, Usage: "maximum size of a cached response body, in bytes", Sources: cli.EnvVars("PRXY_CACHE_OBJECT_MAX")},
+			&cli.DurationFlag{Name: "cache-stale", Usage: "maximum staleness of the cached responses served when the target fails (0 disables it unless the target allows it)", Sources: cli.EnvVars("PRXY_CACHE_STALE")},
+			&cli.BoolFlag{Name: "coalesce-enabled", Usage: "collapse identical concurrent GET requests into a single upstream round trip", Sources: cli.EnvVars("PRXY_COALESCE_ENABLED"), Aliases: []string{"coalesce"}},
+			&cli.StringSliceFlag{Name: "coalesce-headers", Value: config.Defaults.Coalesce.Headers, Usage: "request headers that must match for requests to be coalesced, besides the method and URL", Sources: cli.EnvVars("PRXY_COALESCE_HEADERS")},
+			&cli.Int64Flag{Name: "coalesce-buffer", Value: config.Defaults.Coalesce.Buffer, Usage: "maximum response body size shared with the coalesced requests, in bytes", Sources: cli.EnvVars("PRXY_COALESCE_BUFFER")},
+			&cli.DurationFlag{Name: "body-flush", Value: config.Defaults.Body.Flush, Usage: "interval between flushes of the response to the client (0 leaves it to the response, negative flushes after every write)", Sources: cli.EnvVars("PRXY_BODY_FLUSH")},
+			&cli.BoolFlag{Name: "body-buffer", Value: config.Defaults.Body.Buffer, Usage: "read request bodies in full before sending them to the target, instead of streaming them", Sources: cli.EnvVars("PRXY_BODY_BUFFER")},
+			&cli.Int64Flag{Name: "body-max", Value: config.Defaults.Body.Max, Usage: "maximum size of a buffered request body, in bytes", Sources: cli.EnvVars("PRXY_BODY_MAX")},
+			&cli.IntFlag{Name: "limit-concurrency", Value: config.Defaults.Limit.Concurrency, Usage: "maximum requests in flight to the target (0 disables it)", Sources: cli.EnvVars("PRXY_LIMIT_CONCURRENCY")},
+			&cli.IntFlag{Name: "limit-queue", Value: config.Defaults.Limit.Queue, Usage: "maximum requests waiting for their turn when the concurrency limit is reached", Sources: cli.EnvVars("PRXY_LIMIT_QUEUE")},
+			&cli.DurationFlag{Name: "limit-timeout", Value: config.Defaults.Limit.Timeout, Usage: "maximum time a request waits for its turn (0 waits indefinitely)", Sources: cli.EnvVars("PRXY_LIMIT_TIMEOUT")},
+			&cli.Int64Flag{Name: "throttle-download", Value: config.Defaults.Throttle.Download, Usage: "maximum response rate of all the clients, in bytes per second (0 disables it)", Sources: cli.EnvVars("PRXY_THROTTLE_DOWNLOAD")},
+			&cli.Int64Flag{Name: "throttle-upload", Value: config.Defaults.Throttle.Upload, Usage: "maximum request rate of all the clients, in bytes per second (0 disables it)", Sources: cli.EnvVars("PRXY_THROTTLE_UPLOAD")},
+			&cli.Int64Flag{Name: "throttle-connection-download", Value: config.Defaults.Throttle.Connection.Download, Usage: "maximum response rate of every client connection, in bytes per second (0 disables it)", Sources: cli.EnvVars("PRXY_THROTTLE_CONNECTION_DOWNLOAD")},
+			&cli.Int64Flag{Name: "throttle-connection-upload", Value: config.Defaults.Throttle.Connection.Upload, Usage: "maximum request rate of every client connection, in bytes per second (0 disables it)", Sources: cli.EnvVars("PRXY_THROTTLE_CONNECTION_UPLOAD")},
+			&cli.DurationFlag{Name: "throttle-latency", Value: config.Defaults.Throttle.Latency, Usage: "delay added before forwarding every request", Sources: cli.EnvVars("PRXY_THROTTLE_LATENCY")},
+			&cli.DurationFlag{Name: "throttle-jitter", Value: config.Defaults.Throttle.Jitter, Usage: "maximum random variation of the added latency", Sources: cli.EnvVars("PRXY_THROTTLE_JITTER")},
+			&cli.DurationFlag{Name: "upgrade-idle", Value: config.Defaults.Upgrade.Idle, Usage: "maximum time an upgraded connection, such as a WebSocket, may stay idle (0 disables it)", Sources: cli.EnvVars("PRXY_UPGRADE_IDLE")},
+			&cli.DurationFlag{Name: "upgrade-grace", Value: config.Defaults.Upgrade.Grace, Usage: "time given to the upgraded connections to close on shutdown", Sources: cli.EnvVars("PRXY_UPGRADE_GRACE")},
 			&cli.StringFlag{Name: "log-level", Value: string(config.Defaults.Logging.Level), Usage: fmt.Sprintf("set log level. Available options: %s", config.ValidLogLevels), Sources: cli.EnvVars("PRXY_LOG_LEVEL"), Aliases: []string{"l"}},
 			&cli.StringFlag{Name: "log-format", Value: string(config.Defaults.Logging.Format), Usage: fmt.Sprintf("set log format. Available options: %s", config.ValidLogFormats), Sources: cli.EnvVars("PRXY_LOG_FORMAT"), Aliases: []string{"f"}},
 			&cli.StringFlag{Name: "log-output", Value: string(config.Defaults.Logging.Output), Usage: fmt.Sprintf("set log output. Available options: %s", config.ValidLogOutputs), Sources: cli.EnvVars("PRXY_LOG_OUTPUT"), Aliases: []string{"o"}},
+			&cli.StringFlag{Name: "ready-file", Usage: "write the bound address to this file once listening", Sources: cli.EnvVars("PRXY_READY_FILE"), Aliases: []string{"port-file"}, TakesFile: true},
+			&cli.BoolFlag{Name: "ready-json", Usage: "print a JSON ready line on stdout once listening", Sources: cli.EnvVars("PRXY_READY_JSON")},
+			&cli.StringFlag{Name: "admin-prefix", Value: config.Defaults.Admin.Prefix, Usage: "reserved path prefix for the built-in endpoints", Sources: cli.EnvVars("PRXY_ADMIN_PREFIX")},
+			&cli.DurationFlag{Name: "health-interval", Value: config.Defaults.Health.Interval, Usage: "how long a readiness check result is cached", Sources: cli.EnvVars("PRXY_HEALTH_INTERVAL")},
+			&cli.DurationFlag{Name: "health-timeout", Value: config.Defaults.Health.Timeout, Usage: "maximum duration of a readiness check", Sources: cli.EnvVars("PRXY_HEALTH_TIMEOUT")},
+			&cli.StringFlag{Name: "health-path", Usage: "path on the target to probe on readiness checks", Sources: cli.EnvVars("PRXY_HEALTH_PATH")},
+			&cli.IntFlag{Name: "health-status", Value: config.Defaults.Health.Status, Usage: "expected status code of the target probe", Sources: cli.EnvVars("PRXY_HEALTH_STATUS")},
 		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			// Load configuration
@@ -78,29 +169,62 @@ func main() {
 			signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM) // TODO: https://pkg.go.dev/os/signal#hdr-Windows
 			defer stop()
 
+			// Use the sockets passed by systemd socket activation, if any.
+			listeners, err := systemd.Listeners()
+			if err != nil {
+				return fmt.Errorf("failed to get systemd sockets: %v", err)
+			}
+			if len(listeners) > 0 {
+				logger.Info("Using sockets passed by systemd", "count", len(listeners))
+			}
+
+			// Bind the address before serving, so the actual address is known
+			// even when a random port is requested.
+			if err := prxyServer.Listen(listeners...); err != nil {
+				return fmt.Errorf("failed to listen: %v", err)
+			}
+
 			// Run the server in a separate goroutine so that it doesn't block.
 			errChan := make(chan error, 1)
 			go func() {
-				logger.Info("Server starting to listen...", "address", prxyServer.Addr(), "target", cfg.Target, "proxy", cfg.Proxy)
+				logger.Info("Server starting to listen...", "address", prxyServer.Addr(), "targets", prxyServer.Targets(), "proxy", cfg.Proxy)
 				errChan <- prxyServer.Run()
 			}()
 
+			// Reload the configuration on SIGHUP.
+			reloadChan := make(chan os.Signal, 1)
+			signal.Notify(reloadChan, syscall.SIGHUP)
+			defer signal.Stop(reloadChan)
+
 			// Block until we receive a signal or the server exits with an error.
-			select {
-			case err := <-errChan:
-				if err != nil {
-					return fmt.Errorf("server stopped with an error: %v", err)
-				}
-				logger.Info("Server stopped gracefully.")
-			case <-signalCtx.Done():
-				stop() // Clean up the signal notifier.
-				logger.Info("Shutdown signal received. Shutting down gracefully...")
-				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancel()
-				if err := prxyServer.Shutdown(shutdownCtx); err != nil {
-					return fmt.Errorf("error during graceful shutdown: %v", err)
+		loop:
+			for {
+				select {
+				case err := <-errChan:
+					if err != nil {
+						return fmt.Errorf("server stopped with an error: %v", err)
+					}
+					logger.Info("Server stopped gracefully.")
+					break loop
+				case <-reloadChan:
+					logger.Info("Reload signal received. Reloading configuration...")
+					newCfg, err := config.New(cmd)
+					if err != nil {
+						logger.Error("Failed to reload configuration", "error", err)
+						continue
+					}
+					prxyServer.Reload(newCfg)
+				case <-signalCtx.Done():
+					stop() // Clean up the signal notifier.
+					logger.Info("Shutdown signal received. Shutting down gracefully...")
+					shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := prxyServer.Shutdown(shutdownCtx); err != nil {
+						return fmt.Errorf("error during graceful shutdown: %v", err)
+					}
+					logger.Info("All done! prxy has been shut down.")
+					break loop
 				}
-				logger.Info("All done! prxy has been shut down.")
 			}
 
 			// Cleanly close the logger before exiting.